@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/ShriKaranHanda/atomic/internal/daemon"
 	"github.com/ShriKaranHanda/atomic/internal/engine"
@@ -35,15 +37,69 @@ func main() {
 
 func parseFlags(args []string) (daemon.Config, error) {
 	var cfg daemon.Config
+	var configPath string
 	fs := flag.NewFlagSet("atomicd", flag.ContinueOnError)
 	fs.SetOutput(os.Stderr)
+	fs.StringVar(&configPath, "config", "", "JSON config file (default "+daemon.DefaultConfigPath+" if present)")
 	fs.StringVar(&cfg.SocketPath, "socket", daemon.DefaultSocketPath, "unix socket path")
 	fs.StringVar(&cfg.StateDir, "state-dir", engine.DefaultStateDir, "state directory")
 	fs.StringVar(&cfg.WorkDir, "work-dir", engine.DefaultWorkDir, "run workspace")
 	fs.StringVar(&cfg.JournalDir, "journal-dir", engine.DefaultJournalDir, "journal directory")
 	fs.StringVar(&cfg.RootPrefix, "root-prefix", "", "test-only root prefix")
+	fs.BoolVar(&cfg.PIDNamespace, "pid-ns", false, "run scripts in a new PID namespace unless the request says otherwise")
+	fs.StringVar(&cfg.Network, "net", overlay.NetworkHost, "default network for scripts: host, none or loopback")
+	fs.IntVar(&cfg.MaxConcurrent, "max-concurrent", 0, "how many runs may execute at once (default 4)")
+	fs.IntVar(&cfg.MaxQueue, "max-queue", 0, "how many runs may wait for a free slot (default 16)")
+	fs.Func("max-timeout", "longest run time allowed for a script (e.g. 1h; 0 for none)", durationFlag(&cfg.MaxTimeout))
+	fs.Func("max-health-timeout", "longest health window allowed for post-commit actions and health checks (default 5m)", durationFlag(&cfg.MaxHealthTimeout))
+	fs.Func("max-idle-timeout", "longest time a script may run without output (0 for none)", durationFlag(&cfg.MaxIdleTimeout))
+	fs.Func("env-allow", "comma-separated caller environment variables passed to scripts (globs allowed)", func(v string) error {
+		cfg.Env.Allow = splitList(v)
+		return nil
+	})
+	fs.Func("env-deny", "comma-separated caller environment variables never passed to scripts (globs allowed)", func(v string) error {
+		cfg.Env.Deny = splitList(v)
+		return nil
+	})
 	if err := fs.Parse(args); err != nil {
 		return daemon.Config{}, err
 	}
+	if configPath == "" {
+		path, err := daemon.DefaultConfigFile()
+		if err != nil {
+			return daemon.Config{}, err
+		}
+		configPath = path
+	}
+	if configPath != "" {
+		if err := daemon.LoadConfigFile(configPath, &cfg); err != nil {
+			return daemon.Config{}, err
+		}
+		// Flags given on the command line take precedence over the file.
+		if err := fs.Parse(args); err != nil {
+			return daemon.Config{}, err
+		}
+	}
 	return cfg, nil
 }
+
+func durationFlag(d *daemon.Duration) func(string) error {
+	return func(v string) error {
+		parsed, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*d = daemon.Duration(parsed)
+		return nil
+	}
+}
+
+func splitList(v string) []string {
+	out := []string{}
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
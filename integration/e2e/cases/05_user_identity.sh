@@ -15,9 +15,11 @@ dir=$(e2e_new_case_dir "identity-user")
 target="$dir/uid.txt"
 script="$dir/user.sh"
 want_uid=$(current_user_uid)
-write_script "$script" "id -u > '$target'"
+write_script "$script" "id -u > '$target'; id -G > '$dir/groups.txt'"
 
 e2e_expect_exit 0 run_atomic_user "$script"
 [[ $(<"$target") == "$want_uid" ]] || e2e_fail "expected user uid $want_uid, got $(<"$target")"
+want_groups=$(run_as_user id -G)
+[[ $(<"$dir/groups.txt") == "$want_groups" ]] || e2e_fail "expected groups $want_groups, got $(<"$dir/groups.txt")"
 
 print_step "pass: user identity"
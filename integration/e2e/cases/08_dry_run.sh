@@ -0,0 +1,23 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+SCRIPT_DIR=$(cd -- "$(dirname -- "${BASH_SOURCE[0]}")" && pwd)
+# shellcheck source=../lib.sh
+source "$SCRIPT_DIR/../lib.sh"
+
+trap e2e_cleanup EXIT
+
+e2e_require_linux
+e2e_require_commands
+e2e_setup_case "dry-run"
+
+dir=$(e2e_new_case_dir "dry-run")
+target="$dir/planned.txt"
+script="$dir/dry.sh"
+write_script "$script" "echo planned > '$target'"
+
+out=$(run_atomic_user --dry-run "$script")
+[[ ! -e "$target" ]] || e2e_fail "dry run committed changes"
+grep -qF "upsert file $target" <<<"$out" || e2e_fail "dry run output missing planned upsert: $out"
+
+print_step "pass: dry run"
@@ -0,0 +1,35 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+SCRIPT_DIR=$(cd -- "$(dirname -- "${BASH_SOURCE[0]}")" && pwd)
+# shellcheck source=../lib.sh
+source "$SCRIPT_DIR/../lib.sh"
+
+trap e2e_cleanup EXIT
+
+e2e_require_linux
+e2e_require_commands
+e2e_setup_case "diff-kept-run"
+
+dir=$(e2e_new_case_dir "diff")
+target="$dir/app.conf"
+printf 'port=80\nhost=local\n' >"$target"
+chmod 0666 "$target"
+script="$dir/edit.sh"
+write_script "$script" "sed -i 's/port=80/port=8080/' '$target'"
+
+err=$(run_atomic_user --dry-run --keep-artifacts "$script" 2>&1 >/dev/null)
+run_id=$(sed -n 's/^atomic: run \([^ ]*\) .*/\1/p' <<<"$err")
+[[ -n "$run_id" ]] || e2e_fail "missing run id in output: $err"
+
+out=$(run_atomic_user diff "$run_id")
+grep -qx -- "-port=80" <<<"$out" || e2e_fail "diff missing removed line: $out"
+grep -qx -- "+port=8080" <<<"$out" || e2e_fail "diff missing added line: $out"
+
+out=$(run_atomic_user diff --stat "$run_id")
+grep -q "1 paths changed, 1 insertions(+), 1 deletions(-)" <<<"$out" || e2e_fail "unexpected stat output: $out"
+
+out=$(run_atomic_user diff --json "$run_id")
+grep -q '"added": 1' <<<"$out" || e2e_fail "unexpected json output: $out"
+
+print_step "pass: diff kept run"
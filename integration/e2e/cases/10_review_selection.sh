@@ -0,0 +1,30 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+SCRIPT_DIR=$(cd -- "$(dirname -- "${BASH_SOURCE[0]}")" && pwd)
+# shellcheck source=../lib.sh
+source "$SCRIPT_DIR/../lib.sh"
+
+trap e2e_cleanup EXIT
+
+e2e_require_linux
+e2e_require_commands
+command -v script >/dev/null 2>&1 || e2e_fail "script is required"
+e2e_setup_case "review-selection"
+
+dir=$(e2e_new_case_dir "review")
+script="$dir/review.sh"
+write_script "$script" "echo a > '$dir/a.txt'; echo b > '$dir/b.txt'"
+
+# Answers: select paths, drop a.txt, keep b.txt. script(1) provides the tty.
+(sleep 1; printf 's\r'; sleep 0.3; printf 'n\r'; sleep 0.3; printf 'y\r'; sleep 1) |
+  script -qec "ATOMIC_SOCKET='$SOCKET_PATH' '$ATOMIC_BIN' --review '$script'" /dev/null >/dev/null
+[[ ! -e "$dir/a.txt" ]] || e2e_fail "deselected path was committed"
+[[ $(<"$dir/b.txt") == "b" ]] || e2e_fail "selected path was not committed"
+
+(sleep 1; printf 'n\r'; sleep 1) |
+  script -qec "ATOMIC_SOCKET='$SOCKET_PATH' '$ATOMIC_BIN' --review '$script'" /dev/null >/dev/null && rc=0 || rc=$?
+[[ $rc -eq 11 ]] || e2e_fail "expected rejected exit 11, got $rc"
+[[ ! -e "$dir/a.txt" ]] || e2e_fail "rejected run committed changes"
+
+print_step "pass: review selection"
@@ -0,0 +1,35 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+SCRIPT_DIR=$(cd -- "$(dirname -- "${BASH_SOURCE[0]}")" && pwd)
+# shellcheck source=../lib.sh
+source "$SCRIPT_DIR/../lib.sh"
+
+trap e2e_cleanup EXIT
+
+e2e_require_linux
+e2e_require_commands
+e2e_setup_case "stdin-forwarding"
+
+dir=$(e2e_new_case_dir "stdin")
+target="$dir/imported.txt"
+script="$dir/import.sh"
+write_script "$script" "cat > '$target'"
+
+printf 'line one\nline two\n' | run_atomic_user "$script"
+[[ $(<"$target") == $'line one\nline two' ]] || e2e_fail "unexpected imported content: $(<"$target")"
+
+# A script that ignores stdin must not hang on an input stream that never ends.
+quiet="$dir/quiet.sh"
+write_script "$quiet" "echo done > '$dir/quiet.txt'"
+(while true; do echo tick; sleep 0.2; done) | run_atomic_user "$quiet" &
+tx_pid=$!
+for _ in $(seq 100); do
+  kill -0 "$tx_pid" 2>/dev/null || break
+  sleep 0.2
+done
+kill -0 "$tx_pid" 2>/dev/null && e2e_fail "script ignoring stdin did not finish"
+wait "$tx_pid" || e2e_fail "script ignoring stdin failed"
+[[ $(<"$dir/quiet.txt") == "done" ]] || e2e_fail "quiet script did not commit"
+
+print_step "pass: stdin forwarding"
@@ -0,0 +1,39 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+SCRIPT_DIR=$(cd -- "$(dirname -- "${BASH_SOURCE[0]}")" && pwd)
+# shellcheck source=../lib.sh
+source "$SCRIPT_DIR/../lib.sh"
+
+trap e2e_cleanup EXIT
+
+e2e_require_linux
+e2e_require_commands
+command -v script >/dev/null 2>&1 || e2e_fail "script is required"
+e2e_setup_case "tty"
+
+dir=$(e2e_new_case_dir "tty")
+script="$dir/ask.sh"
+write_script "$script" "[ -t 0 ] && echo tty > '$dir/tty.txt'; stty size > '$dir/size.txt'; ls /dev/pts > '$dir/pts.txt'; read -r name; echo \"hi \$name\" > '$dir/name.txt'"
+
+(sleep 1; printf 'bob\r'; sleep 1) |
+  script -qec "stty rows 30 cols 100; ATOMIC_SOCKET='$SOCKET_PATH' '$ATOMIC_BIN' -t '$script'" /dev/null >/dev/null
+[[ $(<"$dir/tty.txt") == "tty" ]] || e2e_fail "script stdin was not a terminal"
+[[ $(<"$dir/size.txt") == "30 100" ]] || e2e_fail "unexpected window size: $(<"$dir/size.txt")"
+[[ $(<"$dir/name.txt") == "hi bob" ]] || e2e_fail "terminal input was not delivered"
+# The run has its own devpts, holding only the terminal it was given.
+[[ $(<"$dir/pts.txt") == $'0\nptmx' ]] || e2e_fail "unexpected terminals in the sandbox: $(<"$dir/pts.txt")"
+
+# An unprivileged script can open terminals of its own.
+pty_script="$dir/pty.sh"
+write_script "$pty_script" "script -qec 'tty' /dev/null > '$dir/pty.txt'"
+run_atomic_user "$pty_script" </dev/null >/dev/null || e2e_fail "script could not open a terminal"
+grep -q '^/dev/pts/' "$dir/pty.txt" || e2e_fail "unexpected terminal: $(<"$dir/pty.txt")"
+
+# Closed client stdin must reach the script as end of input.
+eof_script="$dir/eof.sh"
+write_script "$eof_script" "read -r line || echo eof > '$dir/eof.txt'"
+run_atomic_user -t "$eof_script" </dev/null >/dev/null
+[[ $(<"$dir/eof.txt") == "eof" ]] || e2e_fail "script did not see end of input"
+
+print_step "pass: tty"
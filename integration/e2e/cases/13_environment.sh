@@ -0,0 +1,40 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+SCRIPT_DIR=$(cd -- "$(dirname -- "${BASH_SOURCE[0]}")" && pwd)
+# shellcheck source=../lib.sh
+source "$SCRIPT_DIR/../lib.sh"
+
+trap e2e_cleanup EXIT
+
+e2e_require_linux
+e2e_require_commands
+e2e_setup_case "environment"
+
+dir=$(e2e_new_case_dir "environment")
+script="$dir/env.sh"
+write_script "$script" "env > '$dir/env.txt'"
+
+LANG=C.UTF-8 https_proxy=http://proxy.example:3128 E2E_SECRET=leak BASH_ENV=/nonexistent PATH="/e2e/bin:$PATH" \
+  run_atomic_root "$script"
+
+env_has() {
+  grep -qx -- "$1" "$dir/env.txt"
+}
+env_has "LANG=C.UTF-8" || e2e_fail "LANG was not forwarded"
+env_has "https_proxy=http://proxy.example:3128" || e2e_fail "proxy was not forwarded"
+env_has "HOME=/root" || e2e_fail "HOME was not set for root"
+env_has "USER=root" || e2e_fail "USER was not set for root"
+env_has "LOGNAME=root" || e2e_fail "LOGNAME was not set for root"
+grep -q "^E2E_SECRET=" "$dir/env.txt" && e2e_fail "variable outside the allowlist was forwarded"
+grep -q "^BASH_ENV=" "$dir/env.txt" && e2e_fail "denied variable was forwarded"
+grep -q "/e2e/bin" "$dir/env.txt" && e2e_fail "caller PATH was forwarded"
+
+if [[ $(id -u) -eq 0 ]] && getent passwd nobody >/dev/null 2>&1; then
+  rm -f "$dir/env.txt"
+  run_atomic_user "$script"
+  env_has "USER=nobody" || e2e_fail "USER was not set for the calling user"
+  env_has "HOME=$(getent passwd nobody | cut -d: -f6)" || e2e_fail "HOME was not set for the calling user"
+fi
+
+print_step "pass: environment"
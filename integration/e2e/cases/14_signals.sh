@@ -0,0 +1,71 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+SCRIPT_DIR=$(cd -- "$(dirname -- "${BASH_SOURCE[0]}")" && pwd)
+# shellcheck source=../lib.sh
+source "$SCRIPT_DIR/../lib.sh"
+
+trap e2e_cleanup EXIT
+
+e2e_require_linux
+e2e_require_commands
+e2e_setup_case "signals"
+
+dir=$(e2e_new_case_dir "signals")
+out="$TMP_ROOT/signals.out"
+
+# Started directly rather than through run_atomic_root so that $! is the
+# client process itself.
+start_client() {
+  ATOMIC_SOCKET="$SOCKET_PATH" "$ATOMIC_BIN" "$@" >"$out" 2>&1 &
+  tx_pid=$!
+}
+
+wait_for_output() {
+  for _ in $(seq 50); do
+    grep -q "$1" "$out" 2>/dev/null && return 0
+    sleep 0.2
+  done
+  e2e_fail "timed out waiting for '$1' in client output"
+}
+
+trapped="$dir/trapped.sh"
+write_script "$trapped" "trap 'echo got-int >&2; exit 7' INT; echo > '$dir/partial.txt'; echo started; sleep 30"
+start_client "$trapped"
+wait_for_output started
+kill -INT "$tx_pid"
+wait "$tx_pid" && rc=0 || rc=$?
+[[ $rc -eq 10 ]] || e2e_fail "expected script failure exit 10 after SIGINT, got $rc"
+grep -q got-int "$out" || e2e_fail "script did not receive SIGINT"
+[[ ! -e "$dir/partial.txt" ]] || e2e_fail "interrupted run committed changes"
+
+# Stdin the script never reads must not hold up the signal.
+start_client "$trapped" < <(yes)
+wait_for_output started
+sleep 1
+kill -INT "$tx_pid"
+wait "$tx_pid" && rc=0 || rc=$?
+[[ $rc -eq 10 ]] || e2e_fail "expected script failure exit 10 after SIGINT with unread stdin, got $rc"
+grep -q got-int "$out" || e2e_fail "SIGINT was held up behind unread stdin"
+
+plain="$dir/plain.sh"
+write_script "$plain" "echo started; sleep 30"
+start_client "$plain"
+wait_for_output started
+kill -TERM "$tx_pid"
+wait "$tx_pid" && rc=0 || rc=$?
+[[ $rc -eq 10 ]] || e2e_fail "expected script failure exit 10 after SIGTERM, got $rc"
+grep -q "terminated by signal 15" "$out" || e2e_fail "result did not report the signal: $(<"$out")"
+
+# A client that dies without a result aborts the run: no commit, no leftovers.
+slow="$dir/slow.sh"
+write_script "$slow" "echo started; sleep 3; echo late > '$dir/late.txt'"
+start_client "$slow"
+wait_for_output started
+kill -KILL "$tx_pid"
+{ wait "$tx_pid"; } 2>/dev/null || true
+sleep 5
+[[ ! -e "$dir/late.txt" ]] || e2e_fail "run committed after the client disconnected"
+[[ -z $(ls -A "$WORK_DIR") ]] || e2e_fail "run dir was not cleaned up after disconnect"
+
+print_step "pass: signals"
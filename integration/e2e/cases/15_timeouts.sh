@@ -0,0 +1,33 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+SCRIPT_DIR=$(cd -- "$(dirname -- "${BASH_SOURCE[0]}")" && pwd)
+# shellcheck source=../lib.sh
+source "$SCRIPT_DIR/../lib.sh"
+
+trap e2e_cleanup EXIT
+
+e2e_require_linux
+e2e_require_commands
+e2e_setup_case "timeouts"
+
+dir=$(e2e_new_case_dir "timeouts")
+
+hung="$dir/hung.sh"
+write_script "$hung" "echo partial > '$dir/hung.txt'; sleep 60"
+start=$SECONDS
+e2e_expect_exit 12 run_atomic_user --timeout 2s "$hung"
+(( SECONDS - start < 20 )) || e2e_fail "timeout did not stop the script promptly"
+[[ ! -e "$dir/hung.txt" ]] || e2e_fail "timed out run committed changes"
+
+chatty="$dir/chatty.sh"
+write_script "$chatty" "for i in 1 2 3 4 5 6; do echo tick; sleep 0.5; done; echo partial > '$dir/chatty.txt'; sleep 60"
+start=$SECONDS
+e2e_expect_exit 12 run_atomic_user --idle-timeout 2s "$chatty" >/dev/null
+(( SECONDS - start >= 3 )) || e2e_fail "idle timeout fired while the script was producing output"
+(( SECONDS - start < 20 )) || e2e_fail "idle timeout did not stop the script promptly"
+[[ ! -e "$dir/chatty.txt" ]] || e2e_fail "idle run committed changes"
+
+[[ -z $(ls -A "$WORK_DIR") ]] || e2e_fail "timed out runs left their run dirs behind"
+
+print_step "pass: timeouts"
@@ -0,0 +1,32 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+SCRIPT_DIR=$(cd -- "$(dirname -- "${BASH_SOURCE[0]}")" && pwd)
+# shellcheck source=../lib.sh
+source "$SCRIPT_DIR/../lib.sh"
+
+trap e2e_cleanup EXIT
+
+e2e_require_linux
+e2e_require_commands
+e2e_setup_case "cgroup-cleanup"
+
+if grep -q "cgroup v2 unavailable" "$DAEMON_LOG"; then
+  print_step "skip: cgroup v2 unavailable"
+  exit 0
+fi
+
+dir=$(e2e_new_case_dir "cgroup")
+script="$dir/detach.sh"
+# setsid escapes the script's process group; only the run cgroup catches it.
+write_script "$script" "setsid bash -c \"sleep 1; echo late > '$dir/late.txt'; sleep 60\" </proc/self/fd/0 >/proc/self/fd/1 2>&1 &
+echo now > '$dir/now.txt'"
+
+out=$(run_atomic_user --verbose "$script" 2>&1)
+[[ $out == *"resource usage: cpu"* ]] || e2e_fail "resource usage was not reported: $out"
+[[ $(<"$dir/now.txt") == "now" ]] || e2e_fail "script changes were not committed"
+sleep 2
+[[ ! -e "$dir/late.txt" ]] || e2e_fail "detached process outlived the run"
+pgrep -f "echo late > $dir/late.txt" >/dev/null && e2e_fail "detached process is still running"
+
+print_step "pass: cgroup cleanup"
@@ -0,0 +1,30 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+SCRIPT_DIR=$(cd -- "$(dirname -- "${BASH_SOURCE[0]}")" && pwd)
+# shellcheck source=../lib.sh
+source "$SCRIPT_DIR/../lib.sh"
+
+trap e2e_cleanup EXIT
+
+e2e_require_linux
+e2e_require_commands
+e2e_setup_case "pid-namespace"
+
+dir=$(e2e_new_case_dir "pidns")
+script="$dir/service.sh"
+write_script "$script" "if [ -r /proc/1/cmdline ]; then tr '\\0' ' ' </proc/1/cmdline; else echo none; fi > '$dir/init.txt'
+ls /proc | grep -c '^[0-9]' > '$dir/procs.txt' || true
+setsid bash -c \"sleep 1; echo late > '$dir/late.txt'; sleep 60\" 0<&- 1>&- 2>&- &"
+
+run_atomic_user --pid-ns "$script"
+[[ $(<"$dir/init.txt") == *"__runner"* ]] || e2e_fail "PID 1 is not the runner: $(<"$dir/init.txt")"
+(( $(<"$dir/procs.txt") < 10 )) || e2e_fail "script sees host processes: $(<"$dir/procs.txt") pids"
+sleep 2
+[[ ! -e "$dir/late.txt" ]] || e2e_fail "process started by the script outlived it"
+
+rm -f "$dir/init.txt"
+run_atomic_user --pid-ns=false "$script"
+[[ $(<"$dir/init.txt") != *"__runner"* ]] || e2e_fail "--pid-ns=false still used a PID namespace"
+
+print_step "pass: pid namespace"
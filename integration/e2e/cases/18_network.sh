@@ -0,0 +1,30 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+SCRIPT_DIR=$(cd -- "$(dirname -- "${BASH_SOURCE[0]}")" && pwd)
+# shellcheck source=../lib.sh
+source "$SCRIPT_DIR/../lib.sh"
+
+trap e2e_cleanup EXIT
+
+e2e_require_linux
+e2e_require_commands
+e2e_setup_case "network"
+
+dir=$(e2e_new_case_dir "network")
+probe="$dir/probe.sh"
+# Port 1 on loopback has no listener: "refused" means loopback is up,
+# "unreachable" means there is no usable interface at all.
+write_script "$probe" "probe() { (exec 3<>\"/dev/tcp/\$1/\$2\") 2>&1 | tr -d '\\n' || true; }
+probe 127.0.0.1 1 > \"\$1\""
+
+run_atomic_user --net=none "$probe" "$dir/none.txt"
+[[ $(<"$dir/none.txt") == *"unreachable"* ]] || e2e_fail "--net=none left a network: $(<"$dir/none.txt")"
+
+run_atomic_user --net=loopback "$probe" "$dir/loopback.txt"
+[[ $(<"$dir/loopback.txt") == *"refused"* ]] || e2e_fail "--net=loopback has no loopback: $(<"$dir/loopback.txt")"
+
+out=$(e2e_expect_exit 20 run_atomic_user --net=bogus "$probe" "$dir/bogus.txt" 2>&1)
+[[ $out == *"unsupported network mode"* ]] || e2e_fail "unexpected error for an unknown network mode: $out"
+
+print_step "pass: network"
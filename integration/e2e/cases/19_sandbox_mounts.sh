@@ -0,0 +1,44 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+SCRIPT_DIR=$(cd -- "$(dirname -- "${BASH_SOURCE[0]}")" && pwd)
+# shellcheck source=../lib.sh
+source "$SCRIPT_DIR/../lib.sh"
+
+trap e2e_cleanup EXIT
+
+e2e_require_linux
+e2e_require_commands
+e2e_setup_case "sandbox-mounts"
+
+dir=$(e2e_new_case_dir "sandbox")
+target="$dir/result.txt"
+script="$dir/sandbox.sh"
+# The host's resolver config stays readable when it lives under /run.
+resolv_check=true
+if [[ -e /etc/resolv.conf ]]; then
+  cp /etc/resolv.conf "$dir/resolv.conf"
+  resolv_check="cmp -s /etc/resolv.conf '$dir/resolv.conf'"
+fi
+write_script "$script" "echo discarded > /dev/null
+$resolv_check
+[ \$(stat -f -c %b /run) -lt \$(stat -f -c %b /tmp) ]
+head -c 16 /dev/urandom > /tmp/atomic-e2e-scratch
+echo scratch > /run/lock/atomic-e2e-scratch
+echo scratch > /dev/shm/atomic-e2e-scratch
+[ -r /proc/self/status ]
+[ -d /sys/class ]
+if touch /sys/atomic-e2e 2>/dev/null; then exit 1; fi
+echo ok > '$target'"
+
+out=$(run_atomic_user --dry-run "$script")
+grep -qF "upsert file $target" <<<"$out" || e2e_fail "dry run output missing planned upsert: $out"
+if grep -E "^(upsert|delete) [a-z]+ /(dev|tmp|run|proc|sys)(/|$)" <<<"$out"; then
+  e2e_fail "sandbox mounts leaked into the changeset: $out"
+fi
+
+run_atomic_user "$script"
+[[ $(<"$target") == "ok" ]] || e2e_fail "script did not commit its own change"
+[[ ! -e /tmp/atomic-e2e-scratch && ! -e /run/lock/atomic-e2e-scratch ]] || e2e_fail "private /tmp or /run leaked to the host"
+
+print_step "pass: sandbox mounts"
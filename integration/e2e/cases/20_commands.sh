@@ -0,0 +1,44 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+SCRIPT_DIR=$(cd -- "$(dirname -- "${BASH_SOURCE[0]}")" && pwd)
+# shellcheck source=../lib.sh
+source "$SCRIPT_DIR/../lib.sh"
+
+trap e2e_cleanup EXIT
+
+e2e_require_linux
+e2e_require_commands
+e2e_setup_case "commands"
+
+dir=$(e2e_new_case_dir "commands")
+
+# The kernel runs the file through its shebang interpreter, here cat(1).
+printf '#!/bin/cat\nshebang-body\n' > "$dir/catme"
+chmod 755 "$dir/catme"
+out=$(run_atomic_user "$dir/catme")
+[[ $out == *"shebang-body"* ]] || e2e_fail "shebang interpreter was not used: $out"
+
+# Without a shebang or the execute bit, scripts still run with bash.
+printf 'echo plain > "$1"\n' > "$dir/plain.sh"
+chmod 644 "$dir/plain.sh"
+run_atomic_user "$dir/plain.sh" "$dir/plain.txt"
+[[ $(<"$dir/plain.txt") == "plain" ]] || e2e_fail "script without shebang did not run"
+
+# Without the execute bit, the shebang and its argument are still honoured.
+printf '#!/bin/cat -n\nnoexec-body\n' > "$dir/catme-noexec"
+chmod 644 "$dir/catme-noexec"
+out=$(run_atomic_user "$dir/catme-noexec")
+[[ $out == *"2"$'\t'"noexec-body"* ]] || e2e_fail "shebang of a script without the execute bit was not used: $out"
+
+# Commands are looked up on PATH and run in the caller's directory.
+(cd "$dir" && run_atomic_user touch from-path.txt)
+[[ -e "$dir/from-path.txt" ]] || e2e_fail "command from PATH did not commit"
+
+(cd "$dir" && run_atomic_user -c 'echo "$0" > from-c.txt && test -e from-path.txt' arg0)
+[[ $(<"$dir/from-c.txt") == "arg0" ]] || e2e_fail "-c command did not commit"
+
+(cd "$dir" && e2e_expect_exit 10 run_atomic_user -c 'touch failed.txt; false')
+[[ ! -e "$dir/failed.txt" ]] || e2e_fail "failed -c command committed changes"
+
+print_step "pass: commands"
@@ -0,0 +1,37 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+SCRIPT_DIR=$(cd -- "$(dirname -- "${BASH_SOURCE[0]}")" && pwd)
+# shellcheck source=../lib.sh
+source "$SCRIPT_DIR/../lib.sh"
+
+trap e2e_cleanup EXIT
+
+e2e_require_linux
+e2e_require_commands
+e2e_setup_case "groups-umask"
+
+dir=$(e2e_new_case_dir "groups")
+
+write_script "$dir/umask.sh" "touch \"\$1\""
+(umask 077 && run_atomic_root "$dir/umask.sh" "$dir/private.txt")
+[[ $(stat -c %a "$dir/private.txt") == "600" ]] || e2e_fail "caller umask 077 not applied: $(stat -c %a "$dir/private.txt")"
+(umask 002 && run_atomic_root "$dir/umask.sh" "$dir/shared.txt")
+[[ $(stat -c %a "$dir/shared.txt") == "664" ]] || e2e_fail "caller umask 002 not applied: $(stat -c %a "$dir/shared.txt")"
+
+if [[ $(id -u) -ne 0 ]] || ! getent passwd nobody >/dev/null 2>&1 || ! getent group users >/dev/null 2>&1; then
+  print_step "skip: supplementary groups need root, nobody and a users group"
+  print_step "pass: groups and umask"
+  exit 0
+fi
+
+# nobody may edit this file only through its supplementary users group.
+target="$dir/group-writable.txt"
+printf 'old\n' > "$target"
+chown root:users "$target"
+chmod 0664 "$target"
+write_script "$dir/edit.sh" "echo new > '$target'"
+su -s /bin/bash -G users nobody -c "ATOMIC_SOCKET='$SOCKET_PATH' '$ATOMIC_BIN' '$dir/edit.sh'"
+[[ $(<"$target") == "new" ]] || e2e_fail "supplementary group did not grant write access"
+
+print_step "pass: groups and umask"
@@ -0,0 +1,42 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+SCRIPT_DIR=$(cd -- "$(dirname -- "${BASH_SOURCE[0]}")" && pwd)
+# shellcheck source=../lib.sh
+source "$SCRIPT_DIR/../lib.sh"
+
+trap e2e_cleanup EXIT
+
+e2e_require_linux
+e2e_require_commands
+e2e_setup_case "run-as"
+
+if [[ $(id -u) -ne 0 ]] || ! getent passwd nobody >/dev/null 2>&1; then
+  print_step "skip: --user needs root and a nobody account"
+  print_step "pass: run as"
+  exit 0
+fi
+
+dir=$(e2e_new_case_dir "runas")
+write_script "$dir/whoami.sh" "id -u > \"\$1\""
+
+out=$(run_atomic_root --verbose --user nobody "$dir/whoami.sh" "$dir/uid.txt" 2>&1)
+[[ $(<"$dir/uid.txt") == "$(id -u nobody)" ]] || e2e_fail "script did not run as nobody: $(<"$dir/uid.txt")"
+[[ $(stat -c %U "$dir/uid.txt") == "nobody" ]] || e2e_fail "committed file is not owned by nobody"
+[[ $out == *"ran as nobody"* ]] || e2e_fail "--verbose did not report the run-as account: $out"
+grep -q "runs .* as nobody" "$DAEMON_LOG" || e2e_fail "daemon did not audit the run-as override"
+
+# A kept run belongs to the caller that started it, not the user it ran as.
+err=$(run_atomic_root --dry-run --keep-artifacts --user nobody "$dir/whoami.sh" "$dir/kept.txt" 2>&1 >/dev/null)
+run_id=$(sed -n 's/^atomic: run \([^ ]*\) .*/\1/p' <<<"$err")
+[[ -n "$run_id" ]] || e2e_fail "missing run id in output: $err"
+out=$(e2e_expect_exit 20 run_atomic_user diff "$run_id" 2>&1)
+[[ $out == *"belongs to another user"* ]] || e2e_fail "the run-as user could inspect a run it did not start: $out"
+run_atomic_root diff --stat "$run_id" > /dev/null || e2e_fail "the caller could not inspect its run"
+
+out=$(e2e_expect_exit 20 run_atomic_user --user root "$dir/whoami.sh" "$dir/root.txt" 2>&1)
+[[ $out == *"may not run transactions as root"* ]] || e2e_fail "unexpected error for a denied --user: $out"
+[[ ! -e "$dir/root.txt" ]] || e2e_fail "denied --user run still committed"
+grep -q "denied run as \"root\"" "$DAEMON_LOG" || e2e_fail "daemon did not log the denied override"
+
+print_step "pass: run as"
@@ -0,0 +1,37 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+SCRIPT_DIR=$(cd -- "$(dirname -- "${BASH_SOURCE[0]}")" && pwd)
+# shellcheck source=../lib.sh
+source "$SCRIPT_DIR/../lib.sh"
+
+trap e2e_cleanup EXIT
+
+e2e_require_linux
+e2e_require_commands
+# One slot, so the second run has to queue.
+DAEMON_ARGS=(--max-concurrent 1)
+e2e_setup_case "queue"
+
+dir=$(e2e_new_case_dir "queue")
+# Client output goes elsewhere: writing into $dir during a run is a conflict.
+logs=$(e2e_new_case_dir "queue-logs")
+write_script "$dir/slow.sh" "sleep 3; echo first > \"\$1\""
+write_script "$dir/append.sh" "echo \"\$2\" >> \"\$1\""
+
+run_atomic_user "$dir/slow.sh" "$dir/order.txt" &
+first=$!
+sleep 1
+run_atomic_user "$dir/append.sh" "$dir/order.txt" second 2>"$logs/second.err" &
+second=$!
+sleep 0.5
+
+out=$(e2e_expect_exit 22 run_atomic_user --no-wait "$dir/append.sh" "$dir/order.txt" skipped 2>&1)
+[[ $out == *"busy"* ]] || e2e_fail "unexpected --no-wait error: $out"
+
+wait "$first" || e2e_fail "first run failed"
+wait "$second" || e2e_fail "queued run failed: $(<"$logs/second.err")"
+grep -q "position 1 in queue" "$logs/second.err" || e2e_fail "queued run did not report its position: $(<"$logs/second.err")"
+[[ $(<"$dir/order.txt") == $'first\nsecond' ]] || e2e_fail "queued runs did not commit in order: $(<"$dir/order.txt")"
+
+print_step "pass: queue"
@@ -0,0 +1,47 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+SCRIPT_DIR=$(cd -- "$(dirname -- "${BASH_SOURCE[0]}")" && pwd)
+# shellcheck source=../lib.sh
+source "$SCRIPT_DIR/../lib.sh"
+
+trap e2e_cleanup EXIT
+
+e2e_require_linux
+e2e_require_commands
+e2e_setup_case "concurrent"
+
+app_a=$(e2e_new_case_dir "app-a")
+app_b=$(e2e_new_case_dir "app-b")
+logs=$(e2e_new_case_dir "concurrent-logs")
+printf 'v1\n' > "$app_a/config"
+printf 'v1\n' > "$app_b/config"
+chmod 0666 "$app_a/config" "$app_b/config"
+write_script "$logs/slow-edit.sh" "echo \"\$2\" > \"\$1\"; sleep 3"
+write_script "$logs/edit.sh" "echo \"\$2\" > \"\$1\""
+
+# Disjoint trees: the fast run commits while the slow one is still running.
+run_atomic_user "$logs/slow-edit.sh" "$app_a/config" v2 &
+slow=$!
+sleep 1
+run_atomic_user --no-wait "$logs/edit.sh" "$app_b/config" v2 || e2e_fail "run on a disjoint tree was blocked"
+[[ $(<"$app_b/config") == "v2" ]] || e2e_fail "disjoint run did not commit"
+kill -0 "$slow" 2>/dev/null || e2e_fail "slow run finished before the concurrent run committed"
+wait "$slow" || e2e_fail "slow run on a disjoint tree failed"
+[[ $(<"$app_a/config") == "v2" ]] || e2e_fail "slow run did not commit"
+
+# Overlapping writers: the later commit fails with the path and the run it
+# collided with, and the first commit stands.
+run_atomic_user "$logs/slow-edit.sh" "$app_a/config" slow 2>"$logs/slow.err" &
+slow=$!
+sleep 1
+run_atomic_user "$logs/edit.sh" "$app_a/config" fast
+set +e
+wait "$slow"
+rc=$?
+set -e
+[[ $rc -eq 21 ]] || e2e_fail "expected overlapping run to exit 21, got $rc: $(<"$logs/slow.err")"
+grep -q "$app_a/config was committed by run" "$logs/slow.err" || e2e_fail "conflict does not name the path and run: $(<"$logs/slow.err")"
+[[ $(<"$app_a/config") == "fast" ]] || e2e_fail "conflicting run overwrote the first commit"
+
+print_step "pass: concurrent"
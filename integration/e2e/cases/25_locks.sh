@@ -0,0 +1,37 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+SCRIPT_DIR=$(cd -- "$(dirname -- "${BASH_SOURCE[0]}")" && pwd)
+# shellcheck source=../lib.sh
+source "$SCRIPT_DIR/../lib.sh"
+
+trap e2e_cleanup EXIT
+
+e2e_require_linux
+e2e_require_commands
+e2e_setup_case "locks"
+
+first=$(e2e_new_case_dir "locks-first")
+second=$(e2e_new_case_dir "locks-second")
+other=$(e2e_new_case_dir "locks-other")
+logs=$(e2e_new_case_dir "locks-logs")
+write_script "$logs/slow.sh" "sleep 3; touch \"\$1\""
+# Records whether the first run had committed when this one started.
+write_script "$logs/after.sh" "if [[ -e \"\$1\" ]]; then echo after; else echo overlapped; fi > \"\$2\""
+
+run_atomic_user --lock deploy --lock /etc/ssl/ "$logs/slow.sh" "$first/done" &
+holder=$!
+sleep 1
+
+out=$(e2e_expect_exit 22 run_atomic_user --no-wait --lock deploy "$logs/after.sh" "$first/done" "$second/order" 2>&1)
+[[ $out == *"lock deploy is held by run"* ]] || e2e_fail "unexpected --no-wait lock error: $out"
+
+run_atomic_user --no-wait --lock nginx "$logs/after.sh" "$first/done" "$other/order" || e2e_fail "unrelated lock was blocked"
+[[ $(<"$other/order") == "overlapped" ]] || e2e_fail "unrelated lock did not run alongside the holder"
+
+run_atomic_user --lock /etc/ssl "$logs/after.sh" "$first/done" "$second/order" 2>"$logs/waiter.err"
+grep -q "lock /etc/ssl is held by run" "$logs/waiter.err" || e2e_fail "waiting run did not report the held lock: $(<"$logs/waiter.err")"
+[[ $(<"$second/order") == "after" ]] || e2e_fail "lock waiter started before the holder committed"
+wait "$holder" || e2e_fail "lock holder failed"
+
+print_step "pass: locks"
@@ -0,0 +1,38 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+SCRIPT_DIR=$(cd -- "$(dirname -- "${BASH_SOURCE[0]}")" && pwd)
+# shellcheck source=../lib.sh
+source "$SCRIPT_DIR/../lib.sh"
+
+trap e2e_cleanup EXIT
+
+e2e_require_linux
+e2e_require_commands
+e2e_setup_case "detach"
+
+dir=$(e2e_new_case_dir "detach")
+write_script "$dir/slow.sh" "for i in 1 2 3; do echo \"step \$i\"; sleep 1; done; echo done > \"\$1\""
+write_script "$dir/fail.sh" "echo failing >&2; exit 4"
+
+start=$SECONDS
+run_id=$(run_atomic_user --detach "$dir/slow.sh" "$dir/result.txt")
+(( SECONDS - start < 2 )) || e2e_fail "--detach did not return immediately"
+[[ $run_id =~ ^[0-9]+-[0-9]+$ ]] || e2e_fail "--detach did not print a run id: $run_id"
+[[ ! -e "$dir/result.txt" ]] || e2e_fail "detached run committed before it finished"
+
+sleep 1.5
+out=$(run_atomic_user attach "$run_id")
+[[ $out == *"step 1"*"step 2"*"step 3"* ]] || e2e_fail "attach did not replay and follow the output: $out"
+[[ $(<"$dir/result.txt") == "done" ]] || e2e_fail "detached run did not commit"
+run_atomic_user wait "$run_id" || e2e_fail "wait on a committed run failed"
+
+run_id=$(run_atomic_user --detach "$dir/fail.sh")
+e2e_expect_exit 10 run_atomic_user wait "$run_id"
+out=$(e2e_expect_exit 10 run_atomic_user attach "$run_id" 2>&1)
+[[ $out == *"failing"* ]] || e2e_fail "attach to a finished run did not replay its output: $out"
+
+e2e_expect_exit 20 run_atomic_user wait "1-1"
+e2e_expect_exit 20 run_atomic_user --detach --review "$dir/slow.sh" "$dir/result.txt"
+
+print_step "pass: detach"
@@ -0,0 +1,51 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+SCRIPT_DIR=$(cd -- "$(dirname -- "${BASH_SOURCE[0]}")" && pwd)
+# shellcheck source=../lib.sh
+source "$SCRIPT_DIR/../lib.sh"
+
+trap e2e_cleanup EXIT
+
+e2e_require_linux
+e2e_require_commands
+e2e_setup_case "prepare"
+
+dir=$(e2e_new_case_dir "prepare")
+logs=$(e2e_new_case_dir "prepare-logs")
+write_script "$dir/write.sh" "echo \"\$2\" > \"\$1\""
+run_atomic_user "$dir/write.sh" "$dir/shared.txt" old
+
+run_atomic_user prepare "$dir/write.sh" "$dir/new.txt" new 2> "$logs/prepare.err"
+run_id=$(grep -o 'atomic commit [0-9-]*' "$logs/prepare.err" | cut -d' ' -f3)
+[[ -n $run_id ]] || e2e_fail "prepare did not report the run id: $(<"$logs/prepare.err")"
+[[ ! -e "$dir/new.txt" ]] || e2e_fail "prepare committed the changes"
+out=$(run_atomic_user list --prepared)
+[[ $out == *"$run_id"*"prepared (1 changes)"* ]] || e2e_fail "list --prepared did not show the run: $out"
+out=$(run_atomic_user diff --stat "$run_id")
+[[ $out == *"$dir/new.txt"* ]] || e2e_fail "diff did not show the prepared change: $out"
+run_atomic_user commit "$run_id" || e2e_fail "commit of a prepared run failed"
+[[ $(<"$dir/new.txt") == "new" ]] || e2e_fail "commit did not apply the prepared change"
+e2e_expect_exit 20 run_atomic_user commit "$run_id"
+[[ -z $(run_atomic_user list --prepared | tail -n +2) ]] || e2e_fail "a committed run is still listed as prepared"
+
+run_id=$(run_atomic_user prepare --detach "$dir/write.sh" "$dir/aborted.txt" x)
+run_atomic_user wait "$run_id" || e2e_fail "prepared detached run failed"
+run_atomic_user abort "$run_id" 2>/dev/null || e2e_fail "abort of a prepared run failed"
+[[ ! -e "$dir/aborted.txt" ]] || e2e_fail "abort committed the changes"
+e2e_expect_exit 20 run_atomic_user commit "$run_id"
+
+# A run committed in between makes the prepared changeset conflict; the run
+# stays prepared until it is aborted.
+run_id=$(run_atomic_user prepare --detach "$dir/write.sh" "$dir/shared.txt" prepared)
+run_atomic_user wait "$run_id" || e2e_fail "prepared detached run failed"
+run_atomic_user "$dir/write.sh" "$dir/shared.txt" direct || e2e_fail "direct run failed"
+e2e_expect_exit 21 run_atomic_user commit "$run_id"
+[[ $(<"$dir/shared.txt") == "direct" ]] || e2e_fail "conflicting prepared run overwrote a later commit"
+out=$(run_atomic_user list --prepared)
+[[ $out == *"$run_id"* ]] || e2e_fail "a conflicting run was not kept prepared: $out"
+run_atomic_user abort "$run_id" 2>/dev/null || e2e_fail "abort after a conflict failed"
+
+e2e_expect_exit 20 run_atomic_user prepare --dry-run "$dir/write.sh" "$dir/x" y
+
+print_step "pass: prepare"
@@ -0,0 +1,63 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+SCRIPT_DIR=$(cd -- "$(dirname -- "${BASH_SOURCE[0]}")" && pwd)
+# shellcheck source=../lib.sh
+source "$SCRIPT_DIR/../lib.sh"
+
+setup_base_dir
+# The guarded path must be known before the daemon starts.
+guarded=$(mktemp -d "$BASE_DIR/approval-guarded.XXXXXX")
+chmod 0777 "$guarded"
+config="$guarded.json"
+cat > "$config" <<EOF_CONFIG
+{"approval": [{"paths": ["$guarded"], "approvers": ["root"]}]}
+EOF_CONFIG
+trap 'e2e_cleanup; rm -rf "$guarded" "$config"' EXIT
+
+e2e_require_linux
+e2e_require_commands
+DAEMON_ARGS=(--config "$config")
+e2e_setup_case "approval"
+
+if [[ $(current_user_uid) -eq 0 ]]; then
+  print_step "skip: approval needs a non-root user to start the run"
+  print_step "pass: approval"
+  exit 0
+fi
+
+dir=$(e2e_new_case_dir "approval")
+logs=$(e2e_new_case_dir "approval-logs")
+write_script "$dir/write.sh" "echo \"\$2\" > \"\$1\""
+
+run_atomic_user "$dir/write.sh" "$dir/free.txt" free || e2e_fail "unguarded run failed"
+[[ $(<"$dir/free.txt") == "free" ]] || e2e_fail "unguarded run was not committed"
+
+e2e_expect_exit 13 run_atomic_user "$dir/write.sh" "$guarded/app.conf" guarded 2> "$logs/run.err"
+run_id=$(grep -o 'atomic approve [0-9-]*' "$logs/run.err" | cut -d' ' -f3)
+[[ -n $run_id ]] || e2e_fail "pending run did not report its id: $(<"$logs/run.err")"
+[[ ! -e "$guarded/app.conf" ]] || e2e_fail "guarded change was committed without approval"
+out=$(run_atomic_user list --prepared)
+[[ $out == *"$run_id"*"pending approval (1 changes)"* ]] || e2e_fail "list did not show the pending run: $out"
+
+e2e_expect_exit 13 run_atomic_user commit "$run_id"
+out=$(e2e_expect_exit 20 run_atomic_user approve --yes "$run_id" 2>&1)
+[[ $out == *"other than the one who started it"* ]] || e2e_fail "the requester could approve their own run: $out"
+[[ ! -e "$guarded/app.conf" ]] || e2e_fail "guarded change was committed by its requester"
+
+out=$(run_atomic_root approve --yes "$run_id" 2>&1) || e2e_fail "approval by root failed: $out"
+[[ $out == *"$guarded/app.conf"* ]] || e2e_fail "the approver was not shown the diff: $out"
+[[ $(<"$guarded/app.conf") == "guarded" ]] || e2e_fail "approved change was not committed"
+grep -q "run $run_id: uid 0 .* approved the changes of uid $(current_user_uid)" "$DAEMON_LOG" || e2e_fail "daemon did not record both identities"
+e2e_expect_exit 20 run_atomic_root approve --yes "$run_id"
+
+# An approval whose commit fails its conflict checks is not kept.
+e2e_expect_exit 13 run_atomic_user "$dir/write.sh" "$guarded/race.conf" mine 2> "$logs/race.err"
+race_id=$(grep -o 'atomic approve [0-9-]*' "$logs/race.err" | cut -d' ' -f3)
+echo theirs > "$guarded/race.conf"
+e2e_expect_exit 21 run_atomic_root approve --yes "$race_id" > /dev/null 2>&1
+rm "$guarded/race.conf"
+e2e_expect_exit 13 run_atomic_user commit "$race_id"
+[[ ! -e "$guarded/race.conf" ]] || e2e_fail "a failed approval let the requester commit"
+
+print_step "pass: approval"
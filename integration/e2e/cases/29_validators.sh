@@ -0,0 +1,50 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+SCRIPT_DIR=$(cd -- "$(dirname -- "${BASH_SOURCE[0]}")" && pwd)
+# shellcheck source=../lib.sh
+source "$SCRIPT_DIR/../lib.sh"
+
+setup_base_dir
+# The daemon validator's path must be known before the daemon starts. It
+# runs as root from /, with /usr/sbin tools on its PATH.
+checked=$(mktemp -d "$BASE_DIR/validated.XXXXXX")
+chmod 0777 "$checked"
+config="$checked.json"
+cat > "$config" <<EOF_CONFIG
+{"validators": [{"command": "[ \\"\$PWD\\" = / ] && chroot --version >/dev/null && grep -q '^valid' $checked/app.conf", "paths": ["$checked"]}]}
+EOF_CONFIG
+trap 'e2e_cleanup; rm -rf "$checked" "$config"' EXIT
+
+e2e_require_linux
+e2e_require_commands
+DAEMON_ARGS=(--config "$config")
+e2e_setup_case "validators"
+
+dir=$(e2e_new_case_dir "validators")
+logs=$(e2e_new_case_dir "validators-logs")
+write_script "$dir/write.sh" "echo \"\$2\" > \"\$1\""
+
+run_atomic_user --validate "grep -q good $dir/out.txt && echo validator saw the change" "$dir/write.sh" "$dir/out.txt" good > "$logs/pass.out" \
+  || e2e_fail "run with a passing validator failed"
+[[ $(<"$dir/out.txt") == "good" ]] || e2e_fail "run with a passing validator was not committed"
+grep -q "validator saw the change" "$logs/pass.out" || e2e_fail "validator output was not streamed"
+
+e2e_expect_exit 14 run_atomic_user --validate "grep -q good $dir/out.txt" "$dir/write.sh" "$dir/out.txt" bad 2> "$logs/fail.err"
+[[ $(<"$dir/out.txt") == "good" ]] || e2e_fail "change that failed validation reached disk"
+grep -q "failed with exit status 1" "$logs/fail.err" || e2e_fail "validation failure was not reported: $(<"$logs/fail.err")"
+
+e2e_expect_exit 14 run_atomic_user --validate "touch $dir/from-validator" "$dir/write.sh" "$dir/other.txt" x 2>/dev/null
+[[ ! -e "$dir/from-validator" && ! -e "$dir/other.txt" ]] || e2e_fail "validator could write to the run's view"
+
+e2e_expect_exit 14 run_atomic_user "$dir/write.sh" "$checked/app.conf" broken 2>/dev/null
+[[ ! -e "$checked/app.conf" ]] || e2e_fail "daemon validator did not stop a broken config"
+run_atomic_user "$dir/write.sh" "$checked/app.conf" valid || e2e_fail "valid config was rejected"
+run_atomic_user "$dir/write.sh" "$dir/unrelated.txt" x || e2e_fail "daemon validator ran for an unrelated path"
+
+# A review could commit a selection the validators never saw.
+e2e_expect_exit 20 run_atomic_user --review "$dir/write.sh" "$checked/app.conf" valid-reviewed 2> "$logs/review.err"
+grep -q "cannot be reviewed" "$logs/review.err" || e2e_fail "review of validated changes was not refused: $(<"$logs/review.err")"
+[[ $(<"$checked/app.conf") == "valid" ]] || e2e_fail "refused review committed its changes"
+
+print_step "pass: validators"
@@ -0,0 +1,34 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+SCRIPT_DIR=$(cd -- "$(dirname -- "${BASH_SOURCE[0]}")" && pwd)
+# shellcheck source=../lib.sh
+source "$SCRIPT_DIR/../lib.sh"
+
+trap e2e_cleanup EXIT
+
+e2e_require_linux
+e2e_require_commands
+e2e_setup_case "health-check"
+
+dir=$(e2e_new_case_dir "health-check")
+logs=$(e2e_new_case_dir "health-check-logs")
+write_script "$dir/deploy.sh" "echo \"\$1\" > \"$dir/app.conf\"; echo added > \"$dir/added.txt\""
+run_atomic_user "$dir/deploy.sh" good
+
+run_atomic_user --post-commit "cat $dir/app.conf >> $logs/restarts" --health-check "grep -q good $dir/app.conf" "$dir/deploy.sh" good-v2 \
+  || e2e_fail "run with a passing health check failed"
+[[ $(<"$dir/app.conf") == "good-v2" ]] || e2e_fail "healthy commit was not kept"
+[[ $(<"$logs/restarts") == "good-v2" ]] || e2e_fail "post-commit action did not see the commit"
+
+rm "$dir/added.txt" "$logs/restarts"
+e2e_expect_exit 15 run_atomic_user --post-commit "cat $dir/app.conf >> $logs/restarts" --health-check "grep -q good $dir/app.conf" --health-timeout 2s "$dir/deploy.sh" broken 2> "$logs/revert.err"
+[[ $(<"$dir/app.conf") == "good-v2" ]] || e2e_fail "failed health check did not restore the file"
+[[ ! -e "$dir/added.txt" ]] || e2e_fail "failed health check left a created file behind"
+[[ $(<"$logs/restarts") == $'broken\ngood-v2' ]] || e2e_fail "post-commit action did not run again after the revert: $(<"$logs/restarts")"
+grep -q "did not pass within 2s; the changes were reverted" "$logs/revert.err" || e2e_fail "revert was not reported: $(<"$logs/revert.err")"
+
+e2e_expect_exit 15 run_atomic_user --post-commit "exit 3" "$dir/deploy.sh" other 2>/dev/null
+[[ $(<"$dir/app.conf") == "good-v2" ]] || e2e_fail "failed post-commit action did not revert the commit"
+
+print_step "pass: health-check"
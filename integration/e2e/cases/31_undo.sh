@@ -0,0 +1,49 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+SCRIPT_DIR=$(cd -- "$(dirname -- "${BASH_SOURCE[0]}")" && pwd)
+# shellcheck source=../lib.sh
+source "$SCRIPT_DIR/../lib.sh"
+
+trap e2e_cleanup EXIT
+
+e2e_require_linux
+e2e_require_commands
+e2e_setup_case "undo"
+
+dir=$(e2e_new_case_dir "undo")
+write_script "$dir/seed.sh" "echo v1 > \"$dir/app.conf\"; echo keep > \"$dir/old.conf\""
+write_script "$dir/change.sh" "echo v2 > \"$dir/app.conf\"; rm \"$dir/old.conf\"; echo new > \"$dir/new.conf\""
+run_atomic_user "$dir/seed.sh"
+run_atomic_user "$dir/change.sh"
+
+latest_run() {
+  run_atomic_user list --history | awk 'NR == 2 { print $1 }'
+}
+
+run_id=$(latest_run)
+run_atomic_user list --history | grep -q "$run_id.*committed (3 changes).*change.sh" || e2e_fail "committed run not listed for undo"
+run_atomic_user undo "$run_id" || e2e_fail "undo failed"
+[[ $(<"$dir/app.conf") == "v1" && $(<"$dir/old.conf") == "keep" && ! -e "$dir/new.conf" ]] || e2e_fail "undo did not restore the previous state"
+if [[ $(id -u) -eq 0 ]]; then
+  [[ $(stat -c %U "$dir/old.conf") == "nobody" ]] || e2e_fail "undo did not restore the owner"
+fi
+e2e_expect_exit 20 run_atomic_user undo "$run_id" 2>/dev/null
+
+run_atomic_user undo "$(latest_run)" || e2e_fail "undoing the undo failed"
+[[ $(<"$dir/app.conf") == "v2" && -e "$dir/new.conf" && ! -e "$dir/old.conf" ]] || e2e_fail "undoing the undo did not redo the run"
+
+run_id=$(latest_run)
+echo edited > "$dir/new.conf"
+e2e_expect_exit 21 run_atomic_user undo "$run_id" 2> "$dir/conflict.err"
+grep -q "new.conf changed after run $run_id committed it" "$dir/conflict.err" || e2e_fail "conflict was not reported: $(<"$dir/conflict.err")"
+[[ $(<"$dir/app.conf") == "v2" && $(<"$dir/new.conf") == "edited" ]] || e2e_fail "conflicting undo changed files"
+
+if [[ $(id -u) -eq 0 ]]; then
+  run_atomic_root "$dir/seed.sh"
+  root_run=$(run_atomic_root list --history | awk 'NR == 2 { print $1 }')
+  e2e_expect_exit 20 run_atomic_user undo "$root_run" 2>/dev/null
+  [[ $(<"$dir/app.conf") == "v1" ]] || e2e_fail "another user undid root's run"
+fi
+
+print_step "pass: undo"
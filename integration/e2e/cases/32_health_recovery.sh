@@ -0,0 +1,56 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+SCRIPT_DIR=$(cd -- "$(dirname -- "${BASH_SOURCE[0]}")" && pwd)
+# shellcheck source=../lib.sh
+source "$SCRIPT_DIR/../lib.sh"
+
+trap e2e_cleanup EXIT
+
+e2e_require_linux
+e2e_require_commands
+e2e_setup_case "health-recovery"
+
+as_root() {
+  if [[ $(id -u) -eq 0 ]]; then
+    "$@"
+  else
+    sudo "$@"
+  fi
+}
+
+# Kills atomicd once a run is in its health window.
+crash_in_health_window() {
+  local deadline=$((SECONDS + 20))
+  until as_root grep -qs '"state": *"verifying"' "$JOURNAL_DIR"/*.json; do
+    (( SECONDS < deadline )) || e2e_fail "run never reached its health window"
+    sleep 0.2
+  done
+  as_root kill -9 "$DAEMON_PID"
+  wait "$DAEMON_PID" 2>/dev/null || true
+  as_root rm -f "$SOCKET_PATH"
+}
+
+dir=$(e2e_new_case_dir "health-recovery")
+write_script "$dir/deploy.sh" "echo \"\$1\" > \"$dir/app.conf\""
+run_atomic_user "$dir/deploy.sh" v1
+
+run_atomic_user --detach --health-check "[ -e $dir/healthy ]" --health-timeout 30s "$dir/deploy.sh" v2 > /dev/null
+crash_in_health_window
+touch "$dir/healthy"
+# Recovery runs the health check again before atomicd listens.
+start_daemon
+[[ $(<"$dir/app.conf") == "v2" ]] || e2e_fail "recovery reverted a commit whose health check passed"
+run_id=$(run_atomic_user list --history | awk 'NR == 2 { print $1 }')
+run_atomic_user list --history | grep -q "$run_id.*committed (1 changes)" || e2e_fail "recovered commit was not recorded for undo"
+run_atomic_user undo "$run_id" || e2e_fail "recovered commit could not be undone"
+[[ $(<"$dir/app.conf") == "v1" ]] || e2e_fail "undo of the recovered commit did not restore the file"
+
+rm "$dir/healthy"
+run_atomic_user --detach --health-check "[ -e $dir/healthy ]" --health-timeout 3s "$dir/deploy.sh" v3 > /dev/null
+crash_in_health_window
+start_daemon
+[[ $(<"$dir/app.conf") == "v1" ]] || e2e_fail "recovery kept a commit whose health check failed"
+grep -q "did not pass within 3s; the changes were reverted" "$DAEMON_LOG" || e2e_fail "recovery did not report the revert"
+
+print_step "pass: health-recovery"
@@ -16,6 +16,8 @@ CASES_DIR=""
 DAEMON_LOG=""
 DAEMON_PID_FILE=""
 DAEMON_PID=""
+# Extra atomicd flags; set by a case before e2e_setup_case.
+DAEMON_ARGS=()
 
 print_step() {
   echo "[e2e] $*"
@@ -55,9 +57,6 @@ e2e_require_linux() {
 
 e2e_require_commands() {
   command -v go >/dev/null 2>&1 || e2e_fail "go is required"
-  command -v unshare >/dev/null 2>&1 || e2e_fail "unshare is required"
-  command -v chroot >/dev/null 2>&1 || e2e_fail "chroot is required"
-  command -v setpriv >/dev/null 2>&1 || e2e_fail "setpriv is required"
 }
 
 setup_base_dir() {
@@ -99,6 +98,7 @@ start_daemon() {
       --state-dir "$STATE_DIR" \
       --work-dir "$WORK_DIR" \
       --journal-dir "$JOURNAL_DIR" \
+      "${DAEMON_ARGS[@]}" \
       >"$DAEMON_LOG" 2>&1 &
     DAEMON_PID=$!
 
@@ -115,7 +115,8 @@ start_daemon() {
     JOURNAL_DIR="$JOURNAL_DIR" \
     DAEMON_LOG="$DAEMON_LOG" \
     DAEMON_PID_FILE="$DAEMON_PID_FILE" \
-    bash -lc '"$ATOMICD_BIN" --socket "$SOCKET_PATH" --state-dir "$STATE_DIR" --work-dir "$WORK_DIR" --journal-dir "$JOURNAL_DIR" >"$DAEMON_LOG" 2>&1 & echo $! >"$DAEMON_PID_FILE"'
+    DAEMON_ARGS="${DAEMON_ARGS[*]}" \
+    bash -lc '"$ATOMICD_BIN" --socket "$SOCKET_PATH" --state-dir "$STATE_DIR" --work-dir "$WORK_DIR" --journal-dir "$JOURNAL_DIR" $DAEMON_ARGS >"$DAEMON_LOG" 2>&1 & echo $! >"$DAEMON_PID_FILE"'
 
   [[ -f "$DAEMON_PID_FILE" ]] || e2e_fail "daemon pid file missing"
   DAEMON_PID=$(<"$DAEMON_PID_FILE")
@@ -156,20 +157,22 @@ current_user_uid() {
 }
 
 run_atomic_user() {
-  local script_path=$1
+  run_as_user env ATOMIC_SOCKET="$SOCKET_PATH" "$ATOMIC_BIN" "$@"
+}
+
+run_as_user() {
   if [[ $(id -u) -eq 0 ]] && getent passwd nobody >/dev/null 2>&1; then
-    su -s /bin/bash nobody -c "ATOMIC_SOCKET='$SOCKET_PATH' '$ATOMIC_BIN' '$script_path'"
+    su -s /bin/bash nobody -c "$(printf '%q ' "$@")"
   else
-    ATOMIC_SOCKET="$SOCKET_PATH" "$ATOMIC_BIN" "$script_path"
+    "$@"
   fi
 }
 
 run_atomic_root() {
-  local script_path=$1
   if [[ $(id -u) -eq 0 ]]; then
-    ATOMIC_SOCKET="$SOCKET_PATH" "$ATOMIC_BIN" "$script_path"
+    ATOMIC_SOCKET="$SOCKET_PATH" "$ATOMIC_BIN" "$@"
   else
-    sudo ATOMIC_SOCKET="$SOCKET_PATH" "$ATOMIC_BIN" "$script_path"
+    sudo ATOMIC_SOCKET="$SOCKET_PATH" "$ATOMIC_BIN" "$@"
   fi
 }
 
@@ -12,6 +12,31 @@ CASES=(
   "$SCRIPT_DIR/cases/05_user_identity.sh"
   "$SCRIPT_DIR/cases/06_root_identity.sh"
   "$SCRIPT_DIR/cases/07_recover_command.sh"
+  "$SCRIPT_DIR/cases/08_dry_run.sh"
+  "$SCRIPT_DIR/cases/09_diff_kept_run.sh"
+  "$SCRIPT_DIR/cases/10_review_selection.sh"
+  "$SCRIPT_DIR/cases/11_stdin_forwarding.sh"
+  "$SCRIPT_DIR/cases/12_tty.sh"
+  "$SCRIPT_DIR/cases/13_environment.sh"
+  "$SCRIPT_DIR/cases/14_signals.sh"
+  "$SCRIPT_DIR/cases/15_timeouts.sh"
+  "$SCRIPT_DIR/cases/16_cgroup_cleanup.sh"
+  "$SCRIPT_DIR/cases/17_pid_namespace.sh"
+  "$SCRIPT_DIR/cases/18_network.sh"
+  "$SCRIPT_DIR/cases/19_sandbox_mounts.sh"
+  "$SCRIPT_DIR/cases/20_commands.sh"
+  "$SCRIPT_DIR/cases/21_groups_umask.sh"
+  "$SCRIPT_DIR/cases/22_run_as.sh"
+  "$SCRIPT_DIR/cases/23_queue.sh"
+  "$SCRIPT_DIR/cases/24_concurrent.sh"
+  "$SCRIPT_DIR/cases/25_locks.sh"
+  "$SCRIPT_DIR/cases/26_detach.sh"
+  "$SCRIPT_DIR/cases/27_prepare.sh"
+  "$SCRIPT_DIR/cases/28_approval.sh"
+  "$SCRIPT_DIR/cases/29_validators.sh"
+  "$SCRIPT_DIR/cases/30_health_check.sh"
+  "$SCRIPT_DIR/cases/31_undo.sh"
+  "$SCRIPT_DIR/cases/32_health_recovery.sh"
 )
 
 BUILD_ROOT=$(mktemp -d /tmp/atomic-e2e-build.XXXXXX)
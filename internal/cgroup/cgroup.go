@@ -0,0 +1,100 @@
+package cgroup
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Limits are per-run resource limits. Zero leaves a resource unlimited.
+type Limits struct {
+	CPUs       float64 `json:"cpus,omitempty"`
+	MemoryMax  int64   `json:"memory_max,omitempty"`
+	PidsMax    int64   `json:"pids_max,omitempty"`
+	IOReadBPS  int64   `json:"io_read_bps,omitempty"`
+	IOWriteBPS int64   `json:"io_write_bps,omitempty"`
+}
+
+func (l Limits) IsZero() bool {
+	return l == Limits{}
+}
+
+// Cap clamps l to the ceilings in max. A resource l leaves unlimited gets
+// the ceiling.
+func (l Limits) Cap(max Limits) Limits {
+	return Limits{
+		CPUs:       capValue(l.CPUs, max.CPUs),
+		MemoryMax:  capValue(l.MemoryMax, max.MemoryMax),
+		PidsMax:    capValue(l.PidsMax, max.PidsMax),
+		IOReadBPS:  capValue(l.IOReadBPS, max.IOReadBPS),
+		IOWriteBPS: capValue(l.IOWriteBPS, max.IOWriteBPS),
+	}
+}
+
+func capValue[T int64 | float64](requested, max T) T {
+	if max > 0 && (requested <= 0 || requested > max) {
+		return max
+	}
+	if requested < 0 {
+		return 0
+	}
+	return requested
+}
+
+// Usage is what a run consumed, read from its cgroup after the script exits.
+type Usage struct {
+	CPUUsec      uint64 `json:"cpu_usec"`
+	MemoryPeak   uint64 `json:"memory_peak,omitempty"`
+	PidsPeak     uint64 `json:"pids_peak,omitempty"`
+	IOReadBytes  uint64 `json:"io_read_bytes"`
+	IOWriteBytes uint64 `json:"io_write_bytes"`
+}
+
+func (u Usage) String() string {
+	parts := []string{fmt.Sprintf("cpu %.2fs", float64(u.CPUUsec)/1e6)}
+	if u.MemoryPeak > 0 {
+		parts = append(parts, "peak memory "+FormatSize(int64(u.MemoryPeak)))
+	}
+	if u.PidsPeak > 0 {
+		parts = append(parts, fmt.Sprintf("peak pids %d", u.PidsPeak))
+	}
+	parts = append(parts, "io read "+FormatSize(int64(u.IOReadBytes)), "io write "+FormatSize(int64(u.IOWriteBytes)))
+	return strings.Join(parts, ", ")
+}
+
+var sizeUnits = []string{"K", "M", "G", "T"}
+
+// ParseSize parses a byte count with an optional binary suffix: 512M, 2G.
+func ParseSize(value string) (int64, error) {
+	s := strings.TrimSpace(strings.ToUpper(value))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+	mult := int64(1)
+	for i, unit := range sizeUnits {
+		if strings.HasSuffix(s, unit) {
+			s = strings.TrimSuffix(s, unit)
+			mult = int64(1) << (10 * (i + 1))
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return n * mult, nil
+}
+
+func FormatSize(n int64) string {
+	if n < 1024 {
+		return fmt.Sprintf("%dB", n)
+	}
+	v := float64(n)
+	unit := ""
+	for _, u := range sizeUnits {
+		if v < 1024 {
+			break
+		}
+		v /= 1024
+		unit = u
+	}
+	return fmt.Sprintf("%.1f%siB", v, unit)
+}
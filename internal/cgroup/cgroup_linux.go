@@ -0,0 +1,373 @@
+//go:build linux
+
+package cgroup
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ShriKaranHanda/atomic/internal/mounts"
+)
+
+const killTimeout = 5 * time.Second
+
+var wantedControllers = []string{"cpu", "memory", "pids", "io"}
+
+// Manager creates per-run cgroups under the daemon's own cgroup v2 group.
+type Manager struct {
+	runsDir     string
+	controllers map[string]bool
+}
+
+// Setup prepares the daemon's cgroup for per-run children. The daemon moves
+// itself into a "daemon" leaf so controllers can be delegated to the "runs"
+// subtree (cgroup v2 does not allow processes in inner groups).
+func Setup() (*Manager, error) {
+	root, err := unifiedMount()
+	if err != nil {
+		return nil, err
+	}
+	own, err := ownCgroup()
+	if err != nil {
+		return nil, err
+	}
+	if filepath.Base(own) == "daemon" {
+		// Already moved by an earlier Setup in this cgroup.
+		own = filepath.Dir(own)
+	}
+	base := filepath.Join(root, own)
+	if own != "/" {
+		leaf := filepath.Join(base, "daemon")
+		if err := os.MkdirAll(leaf, 0o755); err != nil {
+			return nil, fmt.Errorf("create daemon cgroup: %w", err)
+		}
+		if err := writeFile(filepath.Join(leaf, "cgroup.procs"), strconv.Itoa(os.Getpid())); err != nil {
+			return nil, fmt.Errorf("move daemon into %s: %w", leaf, err)
+		}
+	}
+	available, err := readControllers(filepath.Join(base, "cgroup.controllers"))
+	if err != nil {
+		return nil, err
+	}
+	enabled := enableControllers(base, available)
+	runsDir := filepath.Join(base, "runs")
+	if err := os.MkdirAll(runsDir, 0o755); err != nil {
+		return nil, fmt.Errorf("create runs cgroup: %w", err)
+	}
+	enabled = enableControllers(runsDir, enabled)
+	return &Manager{runsDir: runsDir, controllers: enabled}, nil
+}
+
+// Group is the cgroup of a single run.
+type Group struct {
+	dir string
+	fd  *os.File
+}
+
+func (m *Manager) Create(runID string, limits Limits) (*Group, error) {
+	for controller, set := range map[string]bool{
+		"cpu":    limits.CPUs > 0,
+		"memory": limits.MemoryMax > 0,
+		"pids":   limits.PidsMax > 0,
+		"io":     limits.IOReadBPS > 0 || limits.IOWriteBPS > 0,
+	} {
+		if set && !m.controllers[controller] {
+			return nil, fmt.Errorf("cgroup controller %q is not available to atomicd", controller)
+		}
+	}
+	dir := filepath.Join(m.runsDir, runID)
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create run cgroup: %w", err)
+	}
+	g := &Group{dir: dir}
+	if err := g.apply(limits); err != nil {
+		_ = g.Remove()
+		return nil, err
+	}
+	fd, err := os.Open(dir)
+	if err != nil {
+		_ = g.Remove()
+		return nil, err
+	}
+	g.fd = fd
+	return g, nil
+}
+
+func (g *Group) apply(limits Limits) error {
+	if limits.CPUs > 0 {
+		const period = 100000
+		quota := int64(limits.CPUs * period)
+		if quota < 1000 {
+			quota = 1000
+		}
+		if err := writeFile(filepath.Join(g.dir, "cpu.max"), fmt.Sprintf("%d %d", quota, period)); err != nil {
+			return fmt.Errorf("set cpu.max: %w", err)
+		}
+	}
+	if limits.MemoryMax > 0 {
+		if err := writeFile(filepath.Join(g.dir, "memory.max"), strconv.FormatInt(limits.MemoryMax, 10)); err != nil {
+			return fmt.Errorf("set memory.max: %w", err)
+		}
+		_ = writeFile(filepath.Join(g.dir, "memory.swap.max"), "0")
+	}
+	if limits.PidsMax > 0 {
+		if err := writeFile(filepath.Join(g.dir, "pids.max"), strconv.FormatInt(limits.PidsMax, 10)); err != nil {
+			return fmt.Errorf("set pids.max: %w", err)
+		}
+	}
+	if limits.IOReadBPS > 0 || limits.IOWriteBPS > 0 {
+		devices, err := wholeDisks()
+		if err != nil {
+			return err
+		}
+		var rule string
+		if limits.IOReadBPS > 0 {
+			rule += fmt.Sprintf(" rbps=%d", limits.IOReadBPS)
+		}
+		if limits.IOWriteBPS > 0 {
+			rule += fmt.Sprintf(" wbps=%d", limits.IOWriteBPS)
+		}
+		limited := 0
+		for _, dev := range devices {
+			if err := writeFile(filepath.Join(g.dir, "io.max"), dev+rule); err == nil {
+				limited++
+			}
+		}
+		if limited == 0 {
+			return errors.New("set io.max: no block device accepted the limit")
+		}
+	}
+	return nil
+}
+
+// Attach makes cmd start directly inside the group.
+func (g *Group) Attach(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(g.fd.Fd())
+}
+
+// Kill kills every process left in the group and waits until it is empty.
+func (g *Group) Kill() error {
+	deadline := time.Now().Add(killTimeout)
+	useKillFile := true
+	for {
+		populated, err := g.populated()
+		if err != nil {
+			return err
+		}
+		if !populated {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("processes in %s did not exit", g.dir)
+		}
+		if useKillFile {
+			if err := writeFile(filepath.Join(g.dir, "cgroup.kill"), "1"); err != nil {
+				// cgroup.kill needs Linux 5.14; fall back to signalling
+				// each member.
+				useKillFile = false
+			}
+		}
+		if !useKillFile {
+			if err := g.killMembers(); err != nil {
+				return err
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (g *Group) killMembers() error {
+	blob, err := os.ReadFile(filepath.Join(g.dir, "cgroup.procs"))
+	if err != nil {
+		return err
+	}
+	for _, field := range strings.Fields(string(blob)) {
+		if pid, err := strconv.Atoi(field); err == nil {
+			_ = syscall.Kill(pid, syscall.SIGKILL)
+		}
+	}
+	return nil
+}
+
+func (g *Group) populated() (bool, error) {
+	fields, err := readKeyed(filepath.Join(g.dir, "cgroup.events"))
+	if err != nil {
+		return false, err
+	}
+	return fields["populated"] != 0, nil
+}
+
+// Usage reads the group's accumulated resource usage. Counters that the
+// kernel does not provide are left at zero.
+func (g *Group) Usage() Usage {
+	var u Usage
+	if cpu, err := readKeyed(filepath.Join(g.dir, "cpu.stat")); err == nil {
+		u.CPUUsec = cpu["usage_usec"]
+	}
+	u.MemoryPeak = readUint(filepath.Join(g.dir, "memory.peak"))
+	u.PidsPeak = readUint(filepath.Join(g.dir, "pids.peak"))
+	if blob, err := os.ReadFile(filepath.Join(g.dir, "io.stat")); err == nil {
+		for _, line := range strings.Split(string(blob), "\n") {
+			for _, field := range strings.Fields(line) {
+				key, value, ok := strings.Cut(field, "=")
+				if !ok {
+					continue
+				}
+				n, _ := strconv.ParseUint(value, 10, 64)
+				switch key {
+				case "rbytes":
+					u.IOReadBytes += n
+				case "wbytes":
+					u.IOWriteBytes += n
+				}
+			}
+		}
+	}
+	return u
+}
+
+func (g *Group) Remove() error {
+	if g.fd != nil {
+		_ = g.fd.Close()
+		g.fd = nil
+	}
+	var err error
+	for i := 0; i < 50; i++ {
+		if err = syscall.Rmdir(g.dir); err == nil || errors.Is(err, syscall.ENOENT) {
+			return nil
+		}
+		if !errors.Is(err, syscall.EBUSY) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return fmt.Errorf("remove cgroup %s: %w", g.dir, err)
+}
+
+func unifiedMount() (string, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	parsed, err := mounts.ParseMountInfo(f)
+	if err != nil {
+		return "", err
+	}
+	for _, m := range parsed {
+		if m.FSType == "cgroup2" {
+			return m.MountPoint, nil
+		}
+	}
+	return "", errors.New("cgroup v2 is not mounted")
+}
+
+func ownCgroup() (string, error) {
+	f, err := os.Open("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if path, ok := strings.CutPrefix(sc.Text(), "0::"); ok {
+			return path, nil
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return "", err
+	}
+	return "", errors.New("process is not in a cgroup v2 hierarchy")
+}
+
+func readControllers(path string) (map[string]bool, error) {
+	blob, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	out := map[string]bool{}
+	for _, c := range strings.Fields(string(blob)) {
+		out[c] = true
+	}
+	return out, nil
+}
+
+// enableControllers delegates the wanted controllers from available to the
+// children of dir and returns the ones that were enabled.
+func enableControllers(dir string, available map[string]bool) map[string]bool {
+	enabled := map[string]bool{}
+	for _, c := range wantedControllers {
+		if !available[c] {
+			continue
+		}
+		if err := writeFile(filepath.Join(dir, "cgroup.subtree_control"), "+"+c); err == nil {
+			enabled[c] = true
+		}
+	}
+	return enabled
+}
+
+// wholeDisks lists MAJ:MIN of block devices that are not partitions; io.max
+// only accepts whole devices.
+func wholeDisks() ([]string, error) {
+	entries, err := os.ReadDir("/sys/block")
+	if err != nil {
+		return nil, fmt.Errorf("list block devices: %w", err)
+	}
+	var out []string
+	for _, e := range entries {
+		blob, err := os.ReadFile(filepath.Join("/sys/block", e.Name(), "dev"))
+		if err != nil {
+			continue
+		}
+		out = append(out, strings.TrimSpace(string(blob)))
+	}
+	if len(out) == 0 {
+		return nil, errors.New("no block devices found for io limits")
+	}
+	return out, nil
+}
+
+func readKeyed(path string) (map[string]uint64, error) {
+	blob, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	out := map[string]uint64{}
+	for _, line := range strings.Split(string(blob), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		n, err := strconv.ParseUint(fields[1], 10, 64)
+		if err == nil {
+			out[fields[0]] = n
+		}
+	}
+	return out, nil
+}
+
+func readUint(path string) uint64 {
+	blob, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	n, _ := strconv.ParseUint(strings.TrimSpace(string(blob)), 10, 64)
+	return n
+}
+
+func writeFile(path, value string) error {
+	return os.WriteFile(path, []byte(value), 0o644)
+}
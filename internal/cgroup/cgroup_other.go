@@ -0,0 +1,28 @@
+//go:build !linux
+
+package cgroup
+
+import (
+	"errors"
+	"os/exec"
+)
+
+type Manager struct{}
+
+type Group struct{}
+
+func Setup() (*Manager, error) {
+	return nil, errors.New("cgroups are only supported on Linux")
+}
+
+func (m *Manager) Create(runID string, limits Limits) (*Group, error) {
+	return nil, errors.New("cgroups are only supported on Linux")
+}
+
+func (g *Group) Attach(cmd *exec.Cmd) {}
+
+func (g *Group) Kill() error { return nil }
+
+func (g *Group) Usage() Usage { return Usage{} }
+
+func (g *Group) Remove() error { return nil }
@@ -0,0 +1,28 @@
+package cgroup
+
+import "testing"
+
+func TestLimitsCap(t *testing.T) {
+	max := Limits{CPUs: 2, MemoryMax: 1 << 30, PidsMax: 512}
+	got := Limits{CPUs: 4, MemoryMax: 256 << 20, IOWriteBPS: 1 << 20}.Cap(max)
+	want := Limits{CPUs: 2, MemoryMax: 256 << 20, PidsMax: 512, IOWriteBPS: 1 << 20}
+	if got != want {
+		t.Fatalf("unexpected capped limits: got %+v want %+v", got, want)
+	}
+	if !(Limits{}).Cap(Limits{}).IsZero() {
+		t.Fatalf("expected no limits without ceilings")
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	cases := map[string]int64{"4096": 4096, "512K": 512 << 10, "512M": 512 << 20, "2G": 2 << 30, "1GiB": 1 << 30, "64mb": 64 << 20}
+	for in, want := range cases {
+		got, err := ParseSize(in)
+		if err != nil || got != want {
+			t.Fatalf("ParseSize(%q) = %d, %v; want %d", in, got, err, want)
+		}
+	}
+	if _, err := ParseSize("lots"); err == nil {
+		t.Fatalf("expected error for invalid size")
+	}
+}
@@ -0,0 +1,223 @@
+package changeset
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"unicode/utf8"
+
+	"github.com/ShriKaranHanda/atomic/internal/journal"
+)
+
+const maxTextDiffBytes = 1 << 20
+
+type Node struct {
+	Type   journal.NodeType `json:"type"`
+	Mode   os.FileMode      `json:"mode"`
+	UID    uint32           `json:"uid"`
+	GID    uint32           `json:"gid"`
+	Size   int64            `json:"size"`
+	SHA256 string           `json:"sha256,omitempty"`
+	Target string           `json:"target,omitempty"`
+	// Unreadable is set for a file the run's user may not read; its content
+	// and hash are left out.
+	Unreadable bool `json:"unreadable,omitempty"`
+}
+
+type Change struct {
+	Kind     journal.OperationKind `json:"kind"`
+	Path     string                `json:"path"`
+	NodeType journal.NodeType      `json:"node_type"`
+	Opaque   bool                  `json:"opaque,omitempty"`
+	Old      *Node                 `json:"old,omitempty"`
+	New      *Node                 `json:"new,omitempty"`
+	Binary   bool                  `json:"binary,omitempty"`
+	Diff     string                `json:"diff,omitempty"`
+	Added    int                   `json:"added,omitempty"`
+	Removed  int                   `json:"removed,omitempty"`
+}
+
+// Describe renders ops against the files under rootPrefix. File content
+// and hashes are only included where reader, the run's user, may read the
+// file; nil reads everything.
+func Describe(ops []journal.Operation, rootPrefix string, reader *syscall.Credential) ([]Change, error) {
+	out := make([]Change, 0, len(ops))
+	for _, op := range ops {
+		change, err := describeOne(op, rootPrefix, reader)
+		if err != nil {
+			return nil, fmt.Errorf("describe %s: %w", op.Path, err)
+		}
+		out = append(out, change)
+	}
+	return out, nil
+}
+
+func describeOne(op journal.Operation, rootPrefix string, reader *syscall.Credential) (Change, error) {
+	change := Change{Kind: op.Kind, Path: op.Path, NodeType: op.NodeType, Opaque: op.Opaque}
+	if rootPrefix == "" {
+		rootPrefix = "/"
+	}
+	oldNode, oldData, err := inspect(rootPrefix, op.Path, reader)
+	if err != nil {
+		return Change{}, err
+	}
+	change.Old = oldNode
+	var newData []byte
+	if op.Kind == journal.OperationUpsert {
+		if op.SourcePath == "" {
+			return Change{}, errors.New("upsert without source path")
+		}
+		change.New, newData, err = inspect(filepath.Dir(op.SourcePath), filepath.Base(op.SourcePath), reader)
+		if err != nil {
+			return Change{}, err
+		}
+		if change.New == nil {
+			return Change{}, fmt.Errorf("source %s does not exist", op.SourcePath)
+		}
+	}
+	if change.NodeType == journal.NodeUnknown && change.Old != nil {
+		change.NodeType = change.Old.Type
+	}
+
+	oldIsFile := change.Old != nil && change.Old.Type == journal.NodeFile
+	newIsFile := change.New != nil && change.New.Type == journal.NodeFile
+	if !oldIsFile && !newIsFile {
+		return change, nil
+	}
+	if (oldIsFile && change.Old.Unreadable) || (newIsFile && change.New.Unreadable) {
+		return change, nil
+	}
+	if (oldIsFile && !isText(oldData, change.Old.Size)) || (newIsFile && !isText(newData, change.New.Size)) {
+		change.Binary = true
+		return change, nil
+	}
+	oldName, newName := "a"+op.Path, "b"+op.Path
+	if !oldIsFile {
+		oldName, oldData = "/dev/null", nil
+	}
+	if !newIsFile {
+		newName, newData = "/dev/null", nil
+	}
+	change.Diff, change.Added, change.Removed = Unified(oldName, newName, oldData, newData, 3)
+	return change, nil
+}
+
+// inspect describes the node at rel under root, which the daemon reads as
+// root: symlinks in rel are never followed, and file content is only kept
+// when it is small enough to diff. A file reader may not read, deleted or
+// replaced by a script that could write its directory, is only described.
+func inspect(root, rel string, reader *syscall.Credential) (*Node, []byte, error) {
+	rel = filepath.Clean("/" + rel)
+	parent, searchable, done, err := openParent(root, filepath.Dir(rel), reader)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+	defer done()
+	path := filepath.Join(parent, filepath.Base(rel))
+	info, err := os.Lstat(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+	node := &Node{Mode: info.Mode(), Size: info.Size()}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if ok {
+		node.UID = st.Uid
+		node.GID = st.Gid
+	}
+	switch {
+	case info.IsDir():
+		node.Type = journal.NodeDirectory
+		node.Size = 0
+	case info.Mode()&os.ModeSymlink != 0:
+		node.Type = journal.NodeSymlink
+		if node.Target, err = os.Readlink(path); err != nil {
+			return nil, nil, err
+		}
+	case info.Mode().IsRegular():
+		node.Type = journal.NodeFile
+		if !searchable || !ok || !permits(reader, st, 0o4) {
+			node.Unreadable = true
+			return node, nil, nil
+		}
+		data, err := readRegular(path, node)
+		if err != nil {
+			return nil, nil, fmt.Errorf("read %s: %w", rel, err)
+		}
+		return node, data, nil
+	default:
+		node.Type = journal.NodeUnknown
+	}
+	return node, nil, nil
+}
+
+// readRegular hashes the regular file at path into node and returns its
+// content if it is no larger than maxTextDiffBytes.
+func readRegular(path string, node *Node) ([]byte, error) {
+	f, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NOFOLLOW|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, errors.New("file was replaced while it was read")
+	}
+	node.Size = info.Size()
+	hash := sha256.New()
+	var content *bytes.Buffer
+	w := io.Writer(hash)
+	if node.Size <= maxTextDiffBytes {
+		content = bytes.NewBuffer(make([]byte, 0, node.Size))
+		w = io.MultiWriter(hash, content)
+	}
+	if _, err := io.Copy(w, f); err != nil {
+		return nil, err
+	}
+	node.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	if content == nil {
+		return nil, nil
+	}
+	return content.Bytes(), nil
+}
+
+// permits reports whether the permission bits of st grant reader want, a
+// combination of 4 (read), 2 (write) and 1 (execute or search). ACLs are
+// not consulted, and root is granted everything.
+func permits(reader *syscall.Credential, st *syscall.Stat_t, want uint32) bool {
+	mode := uint32(st.Mode)
+	switch {
+	case reader == nil || reader.Uid == 0:
+		return true
+	case st.Uid == reader.Uid:
+		return mode>>6&want == want
+	case st.Gid == reader.Gid || slices.Contains(reader.Groups, st.Gid):
+		return mode>>3&want == want
+	}
+	return mode&want == want
+}
+
+func isText(data []byte, size int64) bool {
+	if size > maxTextDiffBytes {
+		return false
+	}
+	if bytes.IndexByte(data, 0) >= 0 {
+		return false
+	}
+	return utf8.Valid(data)
+}
@@ -0,0 +1,187 @@
+package changeset
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/ShriKaranHanda/atomic/internal/journal"
+)
+
+func TestUnified(t *testing.T) {
+	oldData := []byte("one\ntwo\nthree\nfour\n")
+	newData := []byte("one\n2\nthree\nfour\nfive")
+
+	got, added, removed := Unified("a/f", "b/f", oldData, newData, 1)
+	want := "--- a/f\n+++ b/f\n" +
+		"@@ -1,4 +1,5 @@\n" +
+		" one\n-two\n+2\n three\n four\n+five\n\\ No newline at end of file\n"
+	if got != want {
+		t.Fatalf("unexpected unified diff:\n%s\nwant:\n%s", got, want)
+	}
+	if added != 2 || removed != 1 {
+		t.Fatalf("unexpected counts added=%d removed=%d", added, removed)
+	}
+}
+
+func TestUnifiedSplitsDistantHunks(t *testing.T) {
+	var oldLines, newLines []string
+	for i := 0; i < 20; i++ {
+		line := string(rune('a'+i)) + "\n"
+		oldLines = append(oldLines, line)
+		newLines = append(newLines, line)
+	}
+	newLines[1] = "B\n"
+	newLines[18] = "S\n"
+
+	got, _, _ := Unified("a/f", "b/f", []byte(strings.Join(oldLines, "")), []byte(strings.Join(newLines, "")), 3)
+	if strings.Count(got, "@@ -") != 2 {
+		t.Fatalf("expected two hunks, got:\n%s", got)
+	}
+	if !strings.Contains(got, "@@ -1,5 +1,5 @@") || !strings.Contains(got, "@@ -16,5 +16,5 @@") {
+		t.Fatalf("unexpected hunk ranges:\n%s", got)
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	tmp := t.TempDir()
+	root := filepath.Join(tmp, "root")
+	upper := filepath.Join(tmp, "upper")
+	for _, dir := range []string{filepath.Join(root, "etc"), upper} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+	}
+	writes := map[string][]byte{
+		filepath.Join(root, "etc", "app.conf"): []byte("port=80\n"),
+		filepath.Join(upper, "app.conf"):       []byte("port=8080\n"),
+		filepath.Join(root, "etc", "blob"):     {0, 1, 2},
+		filepath.Join(upper, "blob"):           {0, 1, 2, 3},
+	}
+	for path, data := range writes {
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatalf("write %s: %v", path, err)
+		}
+	}
+	if err := os.Chmod(filepath.Join(upper, "app.conf"), 0o600); err != nil {
+		t.Fatalf("chmod: %v", err)
+	}
+	if err := os.Symlink("/opt/v2", filepath.Join(upper, "current")); err != nil {
+		t.Fatalf("symlink: %v", err)
+	}
+
+	changes, err := Describe([]journal.Operation{
+		{Kind: journal.OperationUpsert, Path: "/etc/app.conf", SourcePath: filepath.Join(upper, "app.conf"), NodeType: journal.NodeFile},
+		{Kind: journal.OperationUpsert, Path: "/etc/blob", SourcePath: filepath.Join(upper, "blob"), NodeType: journal.NodeFile},
+		{Kind: journal.OperationUpsert, Path: "/etc/current", SourcePath: filepath.Join(upper, "current"), NodeType: journal.NodeSymlink},
+		{Kind: journal.OperationDelete, Path: "/etc/missing", NodeType: journal.NodeUnknown},
+	}, root, nil)
+	if err != nil {
+		t.Fatalf("Describe returned error: %v", err)
+	}
+	if len(changes) != 4 {
+		t.Fatalf("expected 4 changes, got %d", len(changes))
+	}
+	if changes[0].Added != 1 || changes[0].Removed != 1 || !strings.Contains(changes[0].Diff, "+port=8080") {
+		t.Fatalf("unexpected text change: %#v", changes[0])
+	}
+	if changes[0].Old.Mode.Perm() != 0o644 || changes[0].New.Mode.Perm() != 0o600 {
+		t.Fatalf("expected mode change to be captured: %#v", changes[0])
+	}
+	if !changes[1].Binary || changes[1].Diff != "" || changes[1].New.SHA256 == "" {
+		t.Fatalf("expected binary summary: %#v", changes[1])
+	}
+	if changes[2].Old != nil || changes[2].New.Target != "/opt/v2" {
+		t.Fatalf("expected new symlink target: %#v", changes[2])
+	}
+
+	buf := &bytes.Buffer{}
+	if err := RenderHuman(buf, changes); err != nil {
+		t.Fatalf("RenderHuman returned error: %v", err)
+	}
+	for _, want := range []string{"upsert file /etc/app.conf\n", "mode -rw-r--r-- -> -rw-------", "symlink target \"\" -> \"/opt/v2\"", "delete /etc/missing\n"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Fatalf("human output missing %q:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestDescribeHidesUnreadableFiles(t *testing.T) {
+	tmp := t.TempDir()
+	root := filepath.Join(tmp, "root")
+	upper := filepath.Join(tmp, "upper")
+	for _, dir := range []string{filepath.Join(root, "home"), filepath.Join(root, "private"), upper} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+	}
+	writes := map[string]os.FileMode{
+		filepath.Join(root, "home", "secret"):  0o600,
+		filepath.Join(root, "private", "open"): 0o644,
+		filepath.Join(upper, "moved"):          0o600,
+	}
+	for path, mode := range writes {
+		if err := os.WriteFile(path, []byte("hunter2\n"), mode); err != nil {
+			t.Fatalf("write %s: %v", path, err)
+		}
+	}
+	if err := os.Chmod(filepath.Join(root, "private"), 0o700); err != nil {
+		t.Fatalf("chmod: %v", err)
+	}
+
+	// The reader is neither the files' owner nor in their group.
+	reader := &syscall.Credential{Uid: uint32(os.Getuid()) + 1, Gid: uint32(os.Getgid()) + 1}
+	changes, err := Describe([]journal.Operation{
+		{Kind: journal.OperationDelete, Path: "/home/secret", NodeType: journal.NodeUnknown},
+		{Kind: journal.OperationDelete, Path: "/private/open", NodeType: journal.NodeUnknown},
+		{Kind: journal.OperationUpsert, Path: "/home/copy", SourcePath: filepath.Join(upper, "moved"), NodeType: journal.NodeFile},
+	}, root, reader)
+	if err != nil {
+		t.Fatalf("Describe returned error: %v", err)
+	}
+	for _, c := range changes {
+		for _, n := range []*Node{c.Old, c.New} {
+			if n != nil && (!n.Unreadable || n.SHA256 != "") {
+				t.Fatalf("expected %s to be described without content: %#v", c.Path, n)
+			}
+		}
+		if c.Diff != "" || c.Removed != 0 || c.Added != 0 {
+			t.Fatalf("unreadable content leaked into the diff of %s: %q", c.Path, c.Diff)
+		}
+	}
+	buf := &bytes.Buffer{}
+	if err := RenderJSON(buf, changes); err != nil {
+		t.Fatalf("RenderJSON returned error: %v", err)
+	}
+	if strings.Contains(buf.String(), "hunter2") {
+		t.Fatalf("unreadable content leaked into the JSON output:\n%s", buf.String())
+	}
+
+	changes, err = Describe([]journal.Operation{
+		{Kind: journal.OperationDelete, Path: "/home/secret", NodeType: journal.NodeUnknown},
+	}, root, &syscall.Credential{Uid: uint32(os.Getuid())})
+	if err != nil {
+		t.Fatalf("Describe returned error: %v", err)
+	}
+	if changes[0].Old.Unreadable || !strings.Contains(changes[0].Diff, "-hunter2") {
+		t.Fatalf("expected the owner to see the file's content: %#v", changes[0])
+	}
+}
+
+func TestRenderStat(t *testing.T) {
+	changes := []Change{
+		{Kind: journal.OperationUpsert, Path: "/etc/app.conf", NodeType: journal.NodeFile, Old: &Node{}, New: &Node{}, Added: 2, Removed: 1},
+		{Kind: journal.OperationDelete, Path: "/etc/old", NodeType: journal.NodeDirectory, Old: &Node{}},
+	}
+	buf := &bytes.Buffer{}
+	if err := RenderStat(buf, changes); err != nil {
+		t.Fatalf("RenderStat returned error: %v", err)
+	}
+	want := " /etc/app.conf | 3 ++-\n /etc/old      | deleted\n 2 paths changed, 2 insertions(+), 1 deletions(-)\n"
+	if buf.String() != want {
+		t.Fatalf("unexpected stat output:\n%q\nwant:\n%q", buf.String(), want)
+	}
+}
@@ -0,0 +1,56 @@
+//go:build linux
+
+package changeset
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// openParent opens dir, a path under root, one component at a time without
+// following symlinks, and returns a path that reaches it through the open
+// descriptor. The descriptor stays open until done is called. searchable
+// reports whether reader may search root and every directory below it.
+func openParent(root, dir string, reader *syscall.Credential) (path string, searchable bool, done func(), err error) {
+	fd, err := syscall.Open(root, syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return "", false, nil, &os.PathError{Op: "open", Path: root, Err: err}
+	}
+	walked := root
+	searchable = true
+	search := func() error {
+		var st syscall.Stat_t
+		if err := syscall.Fstat(fd, &st); err != nil {
+			_ = syscall.Close(fd)
+			return &os.PathError{Op: "stat", Path: walked, Err: err}
+		}
+		searchable = searchable && permits(reader, &st, 0o1)
+		return nil
+	}
+	if err := search(); err != nil {
+		return "", false, nil, err
+	}
+	for _, name := range strings.Split(dir, "/") {
+		if name == "" || name == "." {
+			continue
+		}
+		walked = filepath.Join(walked, name)
+		next, err := syscall.Openat(fd, name, syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_NOFOLLOW|syscall.O_CLOEXEC, 0)
+		_ = syscall.Close(fd)
+		if err != nil {
+			if errors.Is(err, syscall.ELOOP) || errors.Is(err, syscall.ENOTDIR) {
+				return "", false, nil, fmt.Errorf("%s is not a directory (symlinks are not followed)", walked)
+			}
+			return "", false, nil, &os.PathError{Op: "open", Path: walked, Err: err}
+		}
+		fd = next
+		if err := search(); err != nil {
+			return "", false, nil, err
+		}
+	}
+	return fmt.Sprintf("/proc/self/fd/%d", fd), searchable, func() { _ = syscall.Close(fd) }, nil
+}
@@ -0,0 +1,41 @@
+//go:build linux
+
+package changeset
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInspectDoesNotFollowParents(t *testing.T) {
+	tmp := t.TempDir()
+	root := filepath.Join(tmp, "root")
+	secret := filepath.Join(tmp, "secret")
+	for _, dir := range []string{root, secret} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(secret, "key"), []byte("hunter2\n"), 0o600); err != nil {
+		t.Fatalf("write secret: %v", err)
+	}
+	if err := os.Symlink(secret, filepath.Join(root, "etc")); err != nil {
+		t.Fatalf("symlink: %v", err)
+	}
+	node, data, err := inspect(root, "/etc/key", nil)
+	if err == nil || !strings.Contains(err.Error(), "not a directory") || node != nil || data != nil {
+		t.Fatalf("expected a symlinked parent to be refused, got %#v %q %v", node, data, err)
+	}
+
+	big := filepath.Join(root, "big")
+	if err := os.WriteFile(big, bytes.Repeat([]byte("a"), maxTextDiffBytes+1), 0o644); err != nil {
+		t.Fatalf("write big file: %v", err)
+	}
+	node, data, err = inspect(root, "/big", nil)
+	if err != nil || data != nil || node.Size != maxTextDiffBytes+1 || node.SHA256 == "" {
+		t.Fatalf("expected a large file to be hashed but not kept, got %#v (%d bytes) %v", node, len(data), err)
+	}
+}
@@ -0,0 +1,23 @@
+//go:build !linux
+
+package changeset
+
+import (
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+func openParent(root, dir string, reader *syscall.Credential) (path string, searchable bool, done func(), err error) {
+	walked := root
+	searchable = true
+	for _, name := range append([]string{""}, strings.Split(dir, "/")...) {
+		walked = filepath.Join(walked, name)
+		var st syscall.Stat_t
+		if err := syscall.Stat(walked, &st); err != nil {
+			return "", false, nil, err
+		}
+		searchable = searchable && permits(reader, &st, 0o1)
+	}
+	return filepath.Join(root, dir), searchable, func() {}, nil
+}
@@ -0,0 +1,163 @@
+package changeset
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ShriKaranHanda/atomic/internal/journal"
+)
+
+const (
+	FormatHuman = "human"
+	FormatJSON  = "json"
+	FormatStat  = "stat"
+)
+
+func Render(w io.Writer, changes []Change, format string) error {
+	switch format {
+	case "", FormatHuman:
+		return RenderHuman(w, changes)
+	case FormatJSON:
+		return RenderJSON(w, changes)
+	case FormatStat:
+		return RenderStat(w, changes)
+	default:
+		return fmt.Errorf("unknown output format %q", format)
+	}
+}
+
+func RenderJSON(w io.Writer, changes []Change) error {
+	if changes == nil {
+		changes = []Change{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(changes)
+}
+
+func RenderHuman(w io.Writer, changes []Change) error {
+	var sb strings.Builder
+	for _, c := range changes {
+		sb.WriteString(Header(c))
+		sb.WriteByte('\n')
+		for _, line := range metadataLines(c) {
+			sb.WriteString("  ")
+			sb.WriteString(line)
+			sb.WriteByte('\n')
+		}
+		sb.WriteString(c.Diff)
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func RenderStat(w io.Writer, changes []Change) error {
+	width := 0
+	for _, c := range changes {
+		if len(c.Path) > width {
+			width = len(c.Path)
+		}
+	}
+	var sb strings.Builder
+	added, removed := 0, 0
+	for _, c := range changes {
+		added += c.Added
+		removed += c.Removed
+		fmt.Fprintf(&sb, " %-*s | %s\n", width, c.Path, statDetail(c))
+	}
+	fmt.Fprintf(&sb, " %d paths changed, %d insertions(+), %d deletions(-)\n", len(changes), added, removed)
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func Header(c Change) string {
+	if c.Kind == journal.OperationDelete {
+		return fmt.Sprintf("delete %s", c.Path)
+	}
+	line := fmt.Sprintf("%s %s %s", c.Kind, c.NodeType, c.Path)
+	if c.Opaque {
+		line += " (replaces directory contents)"
+	}
+	return line
+}
+
+func statDetail(c Change) string {
+	switch {
+	case c.Binary:
+		return fmt.Sprintf("Bin %d -> %d bytes", nodeSize(c.Old), nodeSize(c.New))
+	case c.Added > 0 || c.Removed > 0:
+		return fmt.Sprintf("%d %s%s", c.Added+c.Removed, strings.Repeat("+", min(c.Added, 40)), strings.Repeat("-", min(c.Removed, 40)))
+	case c.Kind == journal.OperationDelete:
+		return "deleted"
+	case c.Old == nil:
+		return "created"
+	case unreadable(c.Old) || unreadable(c.New):
+		return "content hidden"
+	default:
+		return "metadata"
+	}
+}
+
+func metadataLines(c Change) []string {
+	var lines []string
+	switch {
+	case c.Old == nil && c.New != nil:
+		lines = append(lines, fmt.Sprintf("new %s, mode %s, owner %d:%d", c.New.Type, c.New.Mode, c.New.UID, c.New.GID))
+	case c.Old != nil && c.New == nil:
+		lines = append(lines, fmt.Sprintf("removed %s", c.Old.Type))
+	case c.Old != nil && c.New != nil:
+		if c.Old.Type != c.New.Type {
+			lines = append(lines, fmt.Sprintf("type %s -> %s", c.Old.Type, c.New.Type))
+		}
+		if c.Old.Mode.Perm() != c.New.Mode.Perm() {
+			lines = append(lines, fmt.Sprintf("mode %s -> %s", c.Old.Mode, c.New.Mode))
+		}
+		if c.Old.UID != c.New.UID || c.Old.GID != c.New.GID {
+			lines = append(lines, fmt.Sprintf("owner %d:%d -> %d:%d", c.Old.UID, c.Old.GID, c.New.UID, c.New.GID))
+		}
+	}
+	oldTarget, newTarget := "", ""
+	if c.Old != nil {
+		oldTarget = c.Old.Target
+	}
+	if c.New != nil {
+		newTarget = c.New.Target
+	}
+	if oldTarget != newTarget {
+		lines = append(lines, fmt.Sprintf("symlink target %q -> %q", oldTarget, newTarget))
+	}
+	if c.Binary {
+		lines = append(lines, fmt.Sprintf("binary %s -> %s", binarySummary(c.Old), binarySummary(c.New)))
+	}
+	if unreadable(c.Old) || unreadable(c.New) {
+		lines = append(lines, "content hidden: the run's user may not read the file")
+	}
+	return lines
+}
+
+func unreadable(n *Node) bool {
+	return n != nil && n.Unreadable
+}
+
+func binarySummary(n *Node) string {
+	if n == nil || n.Type != journal.NodeFile {
+		return "(none)"
+	}
+	return fmt.Sprintf("%d bytes sha256:%s", n.Size, shortHash(n.SHA256))
+}
+
+func shortHash(sum string) string {
+	if len(sum) > 12 {
+		return sum[:12]
+	}
+	return sum
+}
+
+func nodeSize(n *Node) int64 {
+	if n == nil {
+		return 0
+	}
+	return n.Size
+}
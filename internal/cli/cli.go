@@ -1,13 +1,22 @@
 package cli
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"net"
 	"os"
+	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/ShriKaranHanda/atomic/internal/cgroup"
+	"github.com/ShriKaranHanda/atomic/internal/changeset"
 	"github.com/ShriKaranHanda/atomic/internal/exitcode"
 	"github.com/ShriKaranHanda/atomic/internal/ipc"
 	"github.com/ShriKaranHanda/atomic/internal/preflight"
@@ -15,6 +24,9 @@ import (
 
 const defaultSocketPath = "/run/atomicd.sock"
 
+// bashPath runs -c command lines.
+const bashPath = "/bin/bash"
+
 const (
 	ansiRed   = "\x1b[31m"
 	ansiReset = "\x1b[0m"
@@ -24,6 +36,51 @@ type Config struct {
 	SocketPath    string
 	KeepArtifacts bool
 	Verbose       bool
+	DryRun        bool
+	JSON          bool
+	Stat          bool
+	Review        bool
+	TTY           bool
+	Timeout       time.Duration
+	IdleTimeout   time.Duration
+	Limits        cgroup.Limits
+	PIDNamespace  optionalBool
+	Network       string
+	Command       string
+	RunAs         string
+	// AfterDashes is set when "--" ended the flags, so the next argument is
+	// a command even if it names a subcommand.
+	AfterDashes bool
+	// Wait queues the run until a run slot and its locks are free instead
+	// of failing with exitcode.Busy.
+	Wait bool
+	// Locks are named advisory locks the run must hold.
+	Locks  []string
+	Detach bool
+	// Prepare is set by "atomic prepare": the run stops before its commit.
+	Prepare bool
+	// Validators are command lines that must pass before commit.
+	Validators []string
+	// PostCommit actions and HealthCheck run after the commit, within
+	// HealthTimeout; a failure reverts it.
+	PostCommit    []string
+	HealthCheck   string
+	HealthTimeout time.Duration
+	// Yes approves with "atomic approve --yes" without asking.
+	Yes bool
+}
+
+func (c Config) format() (string, error) {
+	switch {
+	case c.JSON && c.Stat:
+		return "", errors.New("--json and --stat are mutually exclusive")
+	case c.JSON:
+		return changeset.FormatJSON, nil
+	case c.Stat:
+		return changeset.FormatStat, nil
+	default:
+		return changeset.FormatHuman, nil
+	}
 }
 
 func Run(args []string) int {
@@ -36,8 +93,18 @@ func Run(args []string) int {
 		fmt.Fprintln(os.Stderr, "preflight failed:", err)
 		return exitcode.Unsupported
 	}
-	if len(rest) == 0 {
-		fmt.Fprintln(os.Stderr, "usage: atomic [flags] <script_path> [script_args...] | atomic recover")
+	if len(rest) == 0 && cfg.Command == "" {
+		fmt.Fprintln(os.Stderr, "usage: atomic [flags] <command|script_path> [args...] | atomic [flags] -c <command> | atomic recover | atomic diff [--json|--stat] <run_id> | atomic attach|wait <run_id> | atomic prepare [flags] <command|script_path> [args...] | atomic commit|abort|undo <run_id> | atomic approve [--yes] <run_id> | atomic list [--prepared|--history]")
+		return exitcode.Unsupported
+	}
+	req, err := buildRequest(&cfg, rest)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitcode.Unsupported
+	}
+	format, err := cfg.format()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
 		return exitcode.Unsupported
 	}
 
@@ -52,29 +119,47 @@ func Run(args []string) int {
 	writer := ipc.NewWriter(conn)
 	reader := ipc.NewReader(conn)
 
-	var req ipc.Request
-	if rest[0] == "recover" {
-		req = ipc.Request{Type: ipc.RequestRecover, Version: ipc.Version}
-	} else {
-		scriptPath, scriptArgs, cwd, err := resolveScript(rest)
-		if err != nil {
-			fmt.Fprintln(os.Stderr, err)
-			return exitcode.Unsupported
-		}
-		req = ipc.Request{
-			Type:          ipc.RequestRun,
-			Version:       ipc.Version,
-			ScriptPath:    scriptPath,
-			ScriptArgs:    scriptArgs,
-			CWD:           cwd,
-			KeepArtifacts: cfg.KeepArtifacts,
-			Verbose:       cfg.Verbose,
-		}
-	}
 	if err := writer.WriteRequest(req); err != nil {
 		fmt.Fprintln(os.Stderr, "failed to send request to atomicd:", err)
 		return exitcode.Unsupported
 	}
+	var stdin *stdinForwarder
+	var term *terminalState
+	restoreTerminal := func() {
+		if term != nil {
+			_ = term.restore()
+			term = nil
+		}
+	}
+	defer restoreTerminal()
+	var stops []func()
+	defer func() {
+		for _, stop := range stops {
+			stop()
+		}
+	}()
+	// A queued run only waits for its start: until then stdin stays unread,
+	// the terminal stays cooked and signals end the client (which takes the
+	// run out of the queue).
+	startRun := func() error {
+		stdin = newStdinForwarder(&ipc.StreamFrameWriter{Sink: writer.WriteFrame})
+		go stdin.run(os.Stdin)
+		stops = append(stops, forwardSignals(writer))
+		if !req.TTY || !isTerminal(os.Stdin.Fd()) {
+			return nil
+		}
+		raw, err := makeRaw(os.Stdin.Fd())
+		if err != nil {
+			return fmt.Errorf("failed to put terminal in raw mode: %w", err)
+		}
+		term = raw
+		stopResize := make(chan struct{})
+		stops = append(stops, func() { close(stopResize) })
+		watchWindowSize(os.Stdin.Fd(), func(rows, cols uint16) {
+			_ = writer.WriteFrame(ipc.Frame{Type: ipc.FrameResize, Rows: rows, Cols: cols})
+		}, stopResize)
+		return nil
+	}
 
 	for {
 		ev, err := reader.ReadEvent()
@@ -101,7 +186,46 @@ func Run(args []string) int {
 				return exitcode.RecoveryFailure
 			}
 			_, _ = os.Stderr.Write(data)
+		case ipc.EventStdinAck:
+			if stdin != nil {
+				stdin.ack(ev.Acked)
+			}
+		case ipc.EventPlan:
+			if err := changeset.Render(os.Stdout, ev.Changes, format); err != nil {
+				fmt.Fprintln(os.Stderr, "failed to render changes:", err)
+				return exitcode.Unsupported
+			}
+			if req.DryRun {
+				fmt.Fprintf(os.Stderr, "atomic: dry run complete (%d planned operations); nothing committed.\n", len(ev.Changes))
+			}
+		case ipc.EventReview:
+			if req.Type == ipc.RequestApprove {
+				frame, err := approveOnTerminal(ev, cfg.Yes)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, "atomic:", err)
+				}
+				if err := writer.WriteFrame(frame); err != nil {
+					fmt.Fprintln(os.Stderr, "failed to send approval to atomicd:", err)
+					return exitcode.RecoveryFailure
+				}
+				continue
+			}
+			if term != nil {
+				_ = term.restore()
+			}
+			frame, err := reviewOnTerminal(ev.Changes, stdin)
+			if term != nil {
+				_ = term.reapply()
+			}
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "atomic:", err)
+			}
+			if err := writer.WriteFrame(frame); err != nil {
+				fmt.Fprintln(os.Stderr, "failed to send review decision to atomicd:", err)
+				return exitcode.RecoveryFailure
+			}
 		case ipc.EventError:
+			restoreTerminal()
 			if ev.Message != "" {
 				fmt.Fprintln(os.Stderr, ev.Message)
 			}
@@ -110,15 +234,42 @@ func Run(args []string) int {
 			}
 			return exitcode.Unsupported
 		case ipc.EventResult:
+			restoreTerminal()
+			if req.Verbose && ev.Usage != nil {
+				fmt.Fprintf(os.Stderr, "atomic: resource usage: %s\n", ev.Usage)
+			}
+			if req.Verbose && ev.RunAs != nil {
+				fmt.Fprintf(os.Stderr, "atomic: ran as %s (uid %d, gid %d)\n", ev.RunAs.User, ev.RunAs.UID, ev.RunAs.GID)
+			}
 			if ev.AtomicExitCode != 0 {
 				msg := resultMessage(ev)
 				if msg != "" {
 					fmt.Fprintln(os.Stderr, msg)
 				}
+			} else if req.Prepare || req.Type == ipc.RequestAbort || req.Type == ipc.RequestApprove || req.Type == ipc.RequestUndo {
+				fmt.Fprintf(os.Stderr, "atomic: %s\n", ev.Message)
 			}
 			return ev.AtomicExitCode
+		case ipc.EventRuns:
+			if err := renderRuns(os.Stdout, ev.Runs); err != nil {
+				fmt.Fprintln(os.Stderr, "failed to print runs:", err)
+				return exitcode.Unsupported
+			}
+		case ipc.EventDetached:
+			fmt.Println(ev.RunID)
+			return exitcode.OK
+		case ipc.EventQueued:
+			fmt.Fprintf(os.Stderr, "atomic: waiting (position %d in queue): %s\n", ev.Position, ev.Message)
 		case ipc.EventStart:
-			continue
+			if req.Type == ipc.RequestRun {
+				if err := startRun(); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					return exitcode.Unsupported
+				}
+			}
+			if req.KeepArtifacts {
+				fmt.Fprintf(os.Stderr, "atomic: run %s (artifacts will be kept)\n", ev.RunID)
+			}
 		default:
 			continue
 		}
@@ -126,12 +277,166 @@ func Run(args []string) int {
 }
 
 func resultMessage(ev ipc.Event) string {
+	if ev.AtomicExitCode == exitcode.ScriptFailed && ev.ScriptSignal != 0 {
+		return ansiRed + fmt.Sprintf("atomic: script terminated by signal %d. Reverting filesystem changes.", ev.ScriptSignal) + ansiReset
+	}
 	if ev.AtomicExitCode == exitcode.ScriptFailed {
 		return ansiRed + "atomic: script failed. Reverting filesystem changes." + ansiReset
 	}
 	return ev.Message
 }
 
+// forwardSignals relays SIGINT, SIGTERM and SIGHUP to the script instead of
+// letting them kill the client, so the daemon still reports the outcome.
+func forwardSignals(writer *ipc.Writer) (stop func()) {
+	ch := make(chan os.Signal, 4)
+	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		for sig := range ch {
+			if s, ok := sig.(syscall.Signal); ok {
+				_ = writer.WriteFrame(ipc.Frame{Type: ipc.FrameSignal, Signal: int(s)})
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(ch)
+		close(ch)
+	}
+}
+
+func buildRequest(cfg *Config, rest []string) (ipc.Request, error) {
+	subcommand := ""
+	if cfg.Command == "" && !cfg.AfterDashes {
+		subcommand = rest[0]
+	}
+	switch subcommand {
+	case "recover":
+		return ipc.Request{Type: ipc.RequestRecover, Version: ipc.Version}, nil
+	case "diff":
+		fs := flag.NewFlagSet("atomic diff", flag.ContinueOnError)
+		fs.SetOutput(os.Stderr)
+		fs.BoolVar(&cfg.JSON, "json", cfg.JSON, "print changes as JSON")
+		fs.BoolVar(&cfg.Stat, "stat", cfg.Stat, "print a per-path summary")
+		if err := fs.Parse(rest[1:]); err != nil {
+			return ipc.Request{}, err
+		}
+		if fs.NArg() != 1 {
+			return ipc.Request{}, errors.New("usage: atomic diff [--json|--stat] <run_id>")
+		}
+		return ipc.Request{Type: ipc.RequestDiff, Version: ipc.Version, RunID: fs.Arg(0)}, nil
+	case "attach", "wait":
+		if len(rest) != 2 {
+			return ipc.Request{}, fmt.Errorf("usage: atomic %s <run_id>", subcommand)
+		}
+		reqType := ipc.RequestAttach
+		if subcommand == "wait" {
+			reqType = ipc.RequestWait
+		}
+		return ipc.Request{Type: reqType, Version: ipc.Version, RunID: rest[1], Verbose: cfg.Verbose}, nil
+	case "commit", "abort", "undo":
+		if len(rest) != 2 {
+			return ipc.Request{}, fmt.Errorf("usage: atomic %s <run_id>", subcommand)
+		}
+		reqType := ipc.RequestCommit
+		switch subcommand {
+		case "abort":
+			reqType = ipc.RequestAbort
+		case "undo":
+			reqType = ipc.RequestUndo
+		}
+		return ipc.Request{Type: reqType, Version: ipc.Version, RunID: rest[1]}, nil
+	case "approve":
+		fs := flag.NewFlagSet("atomic approve", flag.ContinueOnError)
+		fs.SetOutput(os.Stderr)
+		fs.BoolVar(&cfg.Yes, "yes", false, "approve after printing the changes, without asking")
+		if err := fs.Parse(rest[1:]); err != nil {
+			return ipc.Request{}, err
+		}
+		if fs.NArg() != 1 {
+			return ipc.Request{}, errors.New("usage: atomic approve [--yes] <run_id>")
+		}
+		return ipc.Request{Type: ipc.RequestApprove, Version: ipc.Version, RunID: fs.Arg(0)}, nil
+	case "list":
+		fs := flag.NewFlagSet("atomic list", flag.ContinueOnError)
+		fs.SetOutput(os.Stderr)
+		prepared := fs.Bool("prepared", false, "list only prepared runs")
+		history := fs.Bool("history", false, "list committed runs that can be undone")
+		if err := fs.Parse(rest[1:]); err != nil {
+			return ipc.Request{}, err
+		}
+		if fs.NArg() != 0 || *prepared && *history {
+			return ipc.Request{}, errors.New("usage: atomic list [--prepared|--history]")
+		}
+		return ipc.Request{Type: ipc.RequestList, Version: ipc.Version, Prepared: *prepared, History: *history}, nil
+	}
+	if cfg.Review && cfg.DryRun {
+		return ipc.Request{}, errors.New("--review and --dry-run are mutually exclusive")
+	}
+	if cfg.Review && len(cfg.Validators) > 0 {
+		return ipc.Request{}, errors.New("--review cannot be combined with --validate")
+	}
+	if cfg.Prepare && cfg.DryRun {
+		return ipc.Request{}, errors.New("atomic prepare cannot be combined with --dry-run")
+	}
+	if cfg.Detach && (cfg.Review || cfg.TTY) {
+		return ipc.Request{}, errors.New("--detach cannot be combined with -t or --review")
+	}
+	if cfg.Timeout < 0 || cfg.IdleTimeout < 0 || cfg.HealthTimeout < 0 {
+		return ipc.Request{}, errors.New("--timeout, --idle-timeout and --health-timeout must not be negative")
+	}
+	if cfg.DryRun && (len(cfg.PostCommit) > 0 || cfg.HealthCheck != "") {
+		return ipc.Request{}, errors.New("--dry-run cannot be combined with --post-commit or --health-check")
+	}
+	if cfg.Limits.CPUs < 0 || cfg.Limits.PidsMax < 0 {
+		return ipc.Request{}, errors.New("--cpus and --pids must not be negative")
+	}
+	var scriptPath, cwd string
+	var scriptArgs []string
+	var err error
+	if cfg.Command != "" {
+		scriptPath = bashPath
+		scriptArgs = append([]string{"-c", cfg.Command}, rest...)
+		cwd, err = os.Getwd()
+	} else {
+		scriptPath, scriptArgs, cwd, err = resolveScript(rest)
+	}
+	if err != nil {
+		return ipc.Request{}, err
+	}
+	req := ipc.Request{
+		Type:          ipc.RequestRun,
+		Version:       ipc.Version,
+		ScriptPath:    scriptPath,
+		ScriptArgs:    scriptArgs,
+		CWD:           cwd,
+		Env:           callerEnv(),
+		KeepArtifacts: cfg.KeepArtifacts,
+		Verbose:       cfg.Verbose,
+		DryRun:        cfg.DryRun,
+		Review:        cfg.Review,
+		TTY:           cfg.TTY,
+		Timeout:       cfg.Timeout,
+		IdleTimeout:   cfg.IdleTimeout,
+		Limits:        cfg.Limits,
+		PIDNamespace:  cfg.PIDNamespace.value,
+		Network:       cfg.Network,
+		Umask:         currentUmask(),
+		RunAs:         cfg.RunAs,
+		NoWait:        !cfg.Wait,
+		Locks:         cfg.Locks,
+		Detach:        cfg.Detach,
+		Prepare:       cfg.Prepare,
+		Validators:    cfg.Validators,
+		PostCommit:    cfg.PostCommit,
+		HealthCheck:   cfg.HealthCheck,
+		HealthTimeout: cfg.HealthTimeout,
+	}
+	if cfg.TTY {
+		req.Rows, req.Cols, _ = windowSize(os.Stdin.Fd())
+	}
+	return req, nil
+}
+
 func parseFlags(args []string) (Config, []string, error) {
 	cfg := Config{}
 	fs := flag.NewFlagSet("atomic", flag.ContinueOnError)
@@ -139,13 +444,122 @@ func parseFlags(args []string) (Config, []string, error) {
 	fs.StringVar(&cfg.SocketPath, "socket", socketPathFromEnv(), "atomicd unix socket path")
 	fs.BoolVar(&cfg.KeepArtifacts, "keep-artifacts", false, "keep run artifacts after completion")
 	fs.BoolVar(&cfg.Verbose, "verbose", false, "verbose output")
-	if err := fs.Parse(args); err != nil {
+	fs.BoolVar(&cfg.DryRun, "dry-run", false, "run the script and report planned changes without committing")
+	fs.StringVar(&cfg.Command, "c", "", "run a bash command line instead of a script; remaining arguments become $0, $1, ...")
+	fs.BoolVar(&cfg.TTY, "t", false, "run the script on a pseudo-terminal")
+	fs.BoolVar(&cfg.TTY, "tty", false, "run the script on a pseudo-terminal")
+	fs.DurationVar(&cfg.Timeout, "timeout", 0, "kill the script and discard its changes after this long (e.g. 10m)")
+	fs.DurationVar(&cfg.IdleTimeout, "idle-timeout", 0, "kill the script and discard its changes after this long without output")
+	fs.Float64Var(&cfg.Limits.CPUs, "cpus", 0, "CPU limit in cores (e.g. 1.5)")
+	fs.Func("memory", "memory limit (e.g. 512M)", sizeFlag(&cfg.Limits.MemoryMax))
+	fs.Int64Var(&cfg.Limits.PidsMax, "pids", 0, "maximum number of processes")
+	fs.Func("io-read-bps", "disk read limit in bytes per second (e.g. 50M)", sizeFlag(&cfg.Limits.IOReadBPS))
+	fs.Func("io-write-bps", "disk write limit in bytes per second (e.g. 50M)", sizeFlag(&cfg.Limits.IOWriteBPS))
+	fs.Var(&cfg.PIDNamespace, "pid-ns", "run the script in its own PID namespace (--pid-ns=false opts out; default set by atomicd)")
+	fs.StringVar(&cfg.RunAs, "user", "", "run as this account (root, or as allowed by atomicd's run_as policy)")
+	fs.StringVar(&cfg.Network, "net", "", "network for the script: host, none or loopback (default set by atomicd)")
+	fs.Func("lock", "hold this named lock (a name or path) from script start through commit; repeatable", func(v string) error {
+		cfg.Locks = append(cfg.Locks, v)
+		return nil
+	})
+	fs.Func("validate", "run this bash command line against the changes before commit and abort if it fails (e.g. 'nginx -t'); repeatable", func(v string) error {
+		cfg.Validators = append(cfg.Validators, v)
+		return nil
+	})
+	fs.Func("post-commit", "run this bash command line on the host after the commit (e.g. 'systemctl reload nginx'); repeatable", func(v string) error {
+		cfg.PostCommit = append(cfg.PostCommit, v)
+		return nil
+	})
+	fs.StringVar(&cfg.HealthCheck, "health-check", "", "after the post-commit actions, run this bash command line until it succeeds; revert the commit if it does not in time")
+	fs.DurationVar(&cfg.HealthTimeout, "health-timeout", 0, "time allowed for the post-commit actions and health check (default 1m)")
+	fs.BoolVar(&cfg.Wait, "wait", true, "wait for a run slot and locks (the default)")
+	fs.BoolFunc("no-wait", "exit 22 instead of waiting when no run slot or a lock is free", func(v string) error {
+		noWait, err := strconv.ParseBool(v)
+		cfg.Wait = !noWait
+		return err
+	})
+	fs.BoolVar(&cfg.Detach, "detach", false, "print the run ID and leave the run to atomicd; follow it with atomic attach or atomic wait")
+	fs.BoolVar(&cfg.Review, "review", false, "review planned changes and confirm before committing")
+	fs.BoolVar(&cfg.JSON, "json", false, "print changes as JSON")
+	fs.BoolVar(&cfg.Stat, "stat", false, "print a per-path summary of changes")
+	parse := func(args []string) ([]string, error) {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		rest := fs.Args()
+		cfg.AfterDashes = len(args) > len(rest) && args[len(args)-len(rest)-1] == "--"
+		return rest, nil
+	}
+	rest, err := parse(args)
+	if err != nil {
 		return Config{}, nil, err
 	}
-	return cfg, fs.Args(), nil
+	// Run flags may also follow "prepare".
+	if cfg.Command == "" && !cfg.AfterDashes && len(rest) > 0 && rest[0] == "prepare" {
+		cfg.Prepare = true
+		if rest, err = parse(rest[1:]); err != nil {
+			return Config{}, nil, err
+		}
+	}
+	return cfg, rest, nil
+}
+
+// optionalBool is a boolean flag that records whether it was given at all.
+type optionalBool struct {
+	value *bool
 }
 
+func (b *optionalBool) String() string {
+	if b == nil || b.value == nil {
+		return ""
+	}
+	return strconv.FormatBool(*b.value)
+}
+
+func (b *optionalBool) Set(s string) error {
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	b.value = &v
+	return nil
+}
+
+func (b *optionalBool) IsBoolFlag() bool { return true }
+
+func sizeFlag(dst *int64) func(string) error {
+	return func(v string) error {
+		n, err := cgroup.ParseSize(v)
+		if err != nil {
+			return err
+		}
+		*dst = n
+		return nil
+	}
+}
+
+// resolveScript resolves what to run. A path, or a name without a slash that
+// names a file in the working directory, runs in its own directory; any other
+// name is looked up on the caller's PATH and runs in the caller's working
+// directory.
 func resolveScript(args []string) (string, []string, string, error) {
+	if !strings.Contains(args[0], "/") {
+		if st, err := os.Stat(args[0]); err != nil || st.IsDir() {
+			path, err := exec.LookPath(args[0])
+			if err != nil {
+				return "", nil, "", fmt.Errorf("command not found: %w", err)
+			}
+			cwd, err := os.Getwd()
+			if err != nil {
+				return "", nil, "", err
+			}
+			abs, err := filepath.Abs(path)
+			if err != nil {
+				return "", nil, "", err
+			}
+			return abs, args[1:], cwd, nil
+		}
+	}
 	scriptPath, err := filepath.Abs(args[0])
 	if err != nil {
 		return "", nil, "", fmt.Errorf("resolve script path: %w", err)
@@ -157,9 +571,26 @@ func resolveScript(args []string) (string, []string, string, error) {
 	return filepath.Clean(scriptPath), args[1:], filepath.Clean(cwd), nil
 }
 
+func callerEnv() map[string]string {
+	env := map[string]string{}
+	for _, kv := range os.Environ() {
+		if name, value, ok := strings.Cut(kv, "="); ok && name != "" {
+			env[name] = value
+		}
+	}
+	return env
+}
+
 func socketPathFromEnv() string {
 	if path := os.Getenv("ATOMIC_SOCKET"); path != "" {
 		return path
 	}
 	return defaultSocketPath
 }
+
+func currentUmask() *uint32 {
+	mask := syscall.Umask(0)
+	syscall.Umask(mask)
+	m := uint32(mask)
+	return &m
+}
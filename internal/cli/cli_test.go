@@ -1,10 +1,17 @@
 package cli
 
 import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/ShriKaranHanda/atomic/internal/changeset"
 	"github.com/ShriKaranHanda/atomic/internal/exitcode"
 	"github.com/ShriKaranHanda/atomic/internal/ipc"
+	"github.com/ShriKaranHanda/atomic/internal/journal"
 )
 
 func TestResultMessageScriptFailureIsRedRollbackMessage(t *testing.T) {
@@ -16,6 +23,15 @@ func TestResultMessageScriptFailureIsRedRollbackMessage(t *testing.T) {
 	}
 }
 
+func TestResultMessageReportsTerminatingSignal(t *testing.T) {
+	ev := ipc.Event{AtomicExitCode: exitcode.ScriptFailed, ScriptExitCode: 143, ScriptSignal: 15}
+	got := resultMessage(ev)
+	want := "\x1b[31matomic: script terminated by signal 15. Reverting filesystem changes.\x1b[0m"
+	if got != want {
+		t.Fatalf("unexpected signal message: got %q want %q", got, want)
+	}
+}
+
 func TestResultMessageUsesDaemonMessageForNonScriptFailure(t *testing.T) {
 	ev := ipc.Event{AtomicExitCode: exitcode.Conflict, Message: "conflict detected"}
 	got := resultMessage(ev)
@@ -23,3 +39,252 @@ func TestResultMessageUsesDaemonMessageForNonScriptFailure(t *testing.T) {
 		t.Fatalf("unexpected non-script message: got %q", got)
 	}
 }
+
+func TestParseFlagsPIDNamespace(t *testing.T) {
+	for args, want := range map[string]string{"": "", "--pid-ns": "true", "--pid-ns=false": "false"} {
+		cfg, _, err := parseFlags(append(strings.Fields(args), "script.sh"))
+		if err != nil {
+			t.Fatalf("parse %q: %v", args, err)
+		}
+		if got := cfg.PIDNamespace.String(); got != want {
+			t.Fatalf("parse %q: got %q want %q", args, got, want)
+		}
+	}
+}
+
+func TestParseFlagsWait(t *testing.T) {
+	for args, want := range map[string]bool{"": true, "--no-wait": false, "--wait": true, "--wait=false": false, "--wait --no-wait": false, "--no-wait --wait": true} {
+		cfg, _, err := parseFlags(append(strings.Fields(args), "script.sh"))
+		if err != nil {
+			t.Fatalf("parse %q: %v", args, err)
+		}
+		if cfg.Wait != want {
+			t.Fatalf("parse %q: wait = %v, want %v", args, cfg.Wait, want)
+		}
+	}
+}
+
+func TestBuildDiffRequest(t *testing.T) {
+	cfg := Config{}
+	req, err := buildRequest(&cfg, []string{"diff", "--stat", "run-1"})
+	if err != nil {
+		t.Fatalf("buildRequest returned error: %v", err)
+	}
+	if req.Type != ipc.RequestDiff || req.RunID != "run-1" {
+		t.Fatalf("unexpected diff request: %#v", req)
+	}
+	if format, _ := cfg.format(); format != "stat" {
+		t.Fatalf("expected stat format, got %q", format)
+	}
+	if _, err := buildRequest(&Config{}, []string{"diff"}); err == nil {
+		t.Fatalf("expected error for missing run id")
+	}
+}
+
+func TestParseFlagsPrepare(t *testing.T) {
+	cfg, rest, err := parseFlags([]string{"--verbose", "prepare", "--lock", "nginx", "--", "prepare", "x"})
+	if err != nil {
+		t.Fatalf("parseFlags returned error: %v", err)
+	}
+	if !cfg.Prepare || !cfg.Verbose || len(cfg.Locks) != 1 || !cfg.AfterDashes || strings.Join(rest, " ") != "prepare x" {
+		t.Fatalf("unexpected prepare parse: %#v, %v", cfg, rest)
+	}
+	cfg, rest, err = parseFlags([]string{"--", "prepare"})
+	if err != nil || cfg.Prepare || len(rest) != 1 {
+		t.Fatalf("expected prepare after -- to be a command: %#v, %v, %v", cfg, rest, err)
+	}
+	req, err := buildRequest(&Config{}, []string{"list", "--prepared"})
+	if err != nil || req.Type != ipc.RequestList || !req.Prepared {
+		t.Fatalf("unexpected list request: %#v, %v", req, err)
+	}
+	if _, err := buildRequest(&Config{}, []string{"commit"}); err == nil {
+		t.Fatalf("expected error for missing run id")
+	}
+}
+
+func TestBuildRequestHooks(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "deploy.sh")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatalf("write script: %v", err)
+	}
+	cfg, rest, err := parseFlags([]string{"--post-commit", "systemctl reload nginx", "--post-commit", "sleep 1", "--health-check", "curl -fs localhost", "--health-timeout", "30s", script})
+	if err != nil {
+		t.Fatalf("parseFlags returned error: %v", err)
+	}
+	req, err := buildRequest(&cfg, rest)
+	if err != nil {
+		t.Fatalf("buildRequest returned error: %v", err)
+	}
+	if strings.Join(req.PostCommit, "|") != "systemctl reload nginx|sleep 1" || req.HealthCheck != "curl -fs localhost" || req.HealthTimeout != 30*time.Second {
+		t.Fatalf("unexpected hooks in request: %#v", req)
+	}
+	cfg.DryRun = true
+	if _, err := buildRequest(&cfg, rest); err == nil {
+		t.Fatalf("expected error for hooks on a dry run")
+	}
+	cfg = Config{Review: true, Validators: []string{"true"}}
+	if _, err := buildRequest(&cfg, rest); err == nil {
+		t.Fatalf("expected error for --review with --validate")
+	}
+}
+
+func TestBuildRequestResolvesCommands(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"tool", "diff"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"), 0o755); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	t.Setenv("PATH", dir)
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("getwd: %v", err)
+	}
+
+	req, err := buildRequest(&Config{}, []string{"tool", "install"})
+	if err != nil {
+		t.Fatalf("buildRequest returned error: %v", err)
+	}
+	if req.ScriptPath != filepath.Join(dir, "tool") || req.CWD != cwd || len(req.ScriptArgs) != 1 {
+		t.Fatalf("unexpected command request: %#v", req)
+	}
+
+	cfg, rest, err := parseFlags([]string{"--verbose", "--", "diff", "a", "b"})
+	if err != nil {
+		t.Fatalf("parseFlags returned error: %v", err)
+	}
+	req, err = buildRequest(&cfg, rest)
+	if err != nil || req.Type != ipc.RequestRun || req.ScriptPath != filepath.Join(dir, "diff") {
+		t.Fatalf("expected diff after -- to run as a command: %#v, %v", req, err)
+	}
+
+	req, err = buildRequest(&Config{Command: "make && make install"}, []string{"diff"})
+	if err != nil {
+		t.Fatalf("buildRequest returned error: %v", err)
+	}
+	if req.Type != ipc.RequestRun || req.ScriptPath != bashPath || strings.Join(req.ScriptArgs, "|") != "-c|make && make install|diff" || req.CWD != cwd {
+		t.Fatalf("unexpected -c request: %#v", req)
+	}
+
+	if _, err := buildRequest(&Config{}, []string{"no-such-tool"}); err == nil {
+		t.Fatalf("expected error for unknown command")
+	}
+}
+
+func TestResolveScriptPrefersWorkingDirectory(t *testing.T) {
+	bin, work := t.TempDir(), t.TempDir()
+	for _, path := range []string{filepath.Join(bin, "test"), filepath.Join(bin, "tool"), filepath.Join(work, "test")} {
+		if err := os.WriteFile(path, []byte("#!/bin/sh\n"), 0o755); err != nil {
+			t.Fatalf("write %s: %v", path, err)
+		}
+	}
+	// A directory named like a command does not shadow it.
+	if err := os.Mkdir(filepath.Join(work, "tool"), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	t.Setenv("PATH", bin)
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("getwd: %v", err)
+	}
+	if err := os.Chdir(work); err != nil {
+		t.Fatalf("chdir: %v", err)
+	}
+	defer os.Chdir(cwd)
+
+	path, _, dir, err := resolveScript([]string{"test"})
+	if err != nil || path != filepath.Join(work, "test") || dir != work {
+		t.Fatalf("expected the working directory's test: %q in %q, %v", path, dir, err)
+	}
+	path, _, dir, err = resolveScript([]string{"tool"})
+	if err != nil || path != filepath.Join(bin, "tool") || dir != work {
+		t.Fatalf("expected tool from PATH: %q in %q, %v", path, dir, err)
+	}
+}
+
+func TestPromptReview(t *testing.T) {
+	changes := []changeset.Change{
+		{Kind: journal.OperationUpsert, NodeType: journal.NodeFile, Path: "/etc/a"},
+		{Kind: journal.OperationDelete, NodeType: journal.NodeFile, Path: "/etc/b"},
+	}
+	cases := []struct {
+		input  string
+		accept bool
+		paths  []string
+	}{
+		{"y\n", true, nil},
+		{"\n", false, nil},
+		{"maybe\nno\n", false, nil},
+		{"s\nn\n\n", true, []string{"/etc/b"}},
+		{"s\nn\nn\n", false, nil},
+	}
+	for _, tc := range cases {
+		frame, err := promptReview(strings.NewReader(tc.input), io.Discard, changes)
+		if err != nil {
+			t.Fatalf("promptReview(%q) returned error: %v", tc.input, err)
+		}
+		if frame.Type != ipc.FrameReview || frame.Accept != tc.accept || strings.Join(frame.Paths, ",") != strings.Join(tc.paths, ",") {
+			t.Fatalf("promptReview(%q) = %#v", tc.input, frame)
+		}
+	}
+}
+
+type recordingSink struct {
+	data    []byte
+	closed  bool
+	written chan struct{}
+}
+
+func (s *recordingSink) Write(p []byte) (int, error) {
+	s.data = append(s.data, p...)
+	s.written <- struct{}{}
+	return len(p), nil
+}
+
+func (s *recordingSink) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestStdinForwarderDivertsToPrompt(t *testing.T) {
+	pr, pw := io.Pipe()
+	sink := &recordingSink{written: make(chan struct{}, 1)}
+	fwd := newStdinForwarder(sink)
+	go fwd.run(pr)
+
+	if _, err := pw.Write([]byte("for-script\n")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	<-sink.written
+	in, release := fwd.borrow()
+	go func() { _, _ = pw.Write([]byte("y\n")) }()
+	buf := make([]byte, 8)
+	n, err := in.Read(buf)
+	if err != nil || string(buf[:n]) != "y\n" {
+		t.Fatalf("expected diverted answer, got %q (err=%v)", buf[:n], err)
+	}
+	release()
+	_ = pw.Close()
+	<-fwd.finished
+
+	if string(sink.data) != "for-script\n" || !sink.closed {
+		t.Fatalf("unexpected forwarded stdin %q closed=%v", sink.data, sink.closed)
+	}
+}
+
+func TestPromptApproval(t *testing.T) {
+	ev := ipc.Event{RunID: "r", Message: "/etc/a is under /etc", Changes: []changeset.Change{{Kind: journal.OperationUpsert, NodeType: journal.NodeFile, Path: "/etc/a"}}}
+	for input, want := range map[string]bool{"y\n": true, "yes\n": true, "\n": false, "s\n": false} {
+		var out strings.Builder
+		frame, err := promptApproval(strings.NewReader(input), &out, ev)
+		if err != nil {
+			t.Fatalf("promptApproval(%q) returned error: %v", input, err)
+		}
+		if frame.Type != ipc.FrameReview || frame.Accept != want || len(frame.Paths) != 0 {
+			t.Fatalf("promptApproval(%q) = %#v", input, frame)
+		}
+		if !strings.Contains(out.String(), "/etc/a is under /etc") {
+			t.Fatalf("prompt did not show the reason: %q", out.String())
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/ShriKaranHanda/atomic/internal/ipc"
+)
+
+// renderRuns prints the runs from a list request as a table.
+func renderRuns(w io.Writer, runs []ipc.RunInfo) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "RUN ID\tSTATE\tUID\tSTARTED\tSCRIPT")
+	for _, run := range runs {
+		state := run.State
+		switch run.State {
+		case "prepared":
+			state = fmt.Sprintf("prepared (%d changes)", run.Changes)
+		case "pending":
+			state = fmt.Sprintf("pending approval (%d changes)", run.Changes)
+		case "committed":
+			state = fmt.Sprintf("committed (%d changes)", run.Changes)
+		case "finished":
+			state = fmt.Sprintf("finished (exit %d)", run.ExitCode)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\n", run.RunID, state, run.Owner, run.Started.Local().Format("2006-01-02 15:04:05"), run.ScriptPath)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,101 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ShriKaranHanda/atomic/internal/changeset"
+	"github.com/ShriKaranHanda/atomic/internal/ipc"
+)
+
+func reviewOnTerminal(changes []changeset.Change, stdin *stdinForwarder) (ipc.Frame, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return ipc.Frame{Type: ipc.FrameReview}, fmt.Errorf("review requires a terminal: %w", err)
+	}
+	defer tty.Close()
+	if stdin != nil && isTerminal(os.Stdin.Fd()) {
+		in, release := stdin.borrow()
+		defer release()
+		return promptReview(in, tty, changes)
+	}
+	return promptReview(tty, tty, changes)
+}
+
+func promptReview(in io.Reader, out io.Writer, changes []changeset.Change) (ipc.Frame, error) {
+	reject := ipc.Frame{Type: ipc.FrameReview}
+	if err := changeset.RenderHuman(out, changes); err != nil {
+		return reject, err
+	}
+	r := bufio.NewReader(in)
+	for {
+		fmt.Fprintf(out, "Apply %d changes? [y]es, [n]o, [s]elect paths: ", len(changes))
+		answer, err := readAnswer(r)
+		if err != nil {
+			return reject, err
+		}
+		switch answer {
+		case "y", "yes":
+			return ipc.Frame{Type: ipc.FrameReview, Accept: true}, nil
+		case "", "n", "no":
+			return reject, nil
+		case "s", "select":
+			paths := make([]string, 0, len(changes))
+			for _, c := range changes {
+				fmt.Fprintf(out, "  commit %s? [Y/n]: ", changeset.Header(c))
+				answer, err := readAnswer(r)
+				if err != nil {
+					return reject, err
+				}
+				if answer == "" || answer == "y" || answer == "yes" {
+					paths = append(paths, c.Path)
+				}
+			}
+			if len(paths) == 0 {
+				return reject, nil
+			}
+			return ipc.Frame{Type: ipc.FrameReview, Accept: true, Paths: paths}, nil
+		}
+	}
+}
+
+// approveOnTerminal shows the changes of a run held for approval and asks
+// whether to approve them; yes approves without asking.
+func approveOnTerminal(ev ipc.Event, yes bool) (ipc.Frame, error) {
+	if yes {
+		fmt.Fprintf(os.Stdout, "Run %s needs approval: %s\n", ev.RunID, ev.Message)
+		err := changeset.RenderHuman(os.Stdout, ev.Changes)
+		return ipc.Frame{Type: ipc.FrameReview, Accept: err == nil}, err
+	}
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return ipc.Frame{Type: ipc.FrameReview}, fmt.Errorf("approval requires a terminal or --yes: %w", err)
+	}
+	defer tty.Close()
+	return promptApproval(tty, tty, ev)
+}
+
+func promptApproval(in io.Reader, out io.Writer, ev ipc.Event) (ipc.Frame, error) {
+	reject := ipc.Frame{Type: ipc.FrameReview}
+	fmt.Fprintf(out, "Run %s needs approval: %s\n", ev.RunID, ev.Message)
+	if err := changeset.RenderHuman(out, ev.Changes); err != nil {
+		return reject, err
+	}
+	fmt.Fprintf(out, "Approve and commit %d changes? [y/N]: ", len(ev.Changes))
+	answer, err := readAnswer(bufio.NewReader(in))
+	if err != nil {
+		return reject, err
+	}
+	return ipc.Frame{Type: ipc.FrameReview, Accept: answer == "y" || answer == "yes"}, nil
+}
+
+func readAnswer(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+	return strings.ToLower(strings.TrimSpace(line)), nil
+}
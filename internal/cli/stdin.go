@@ -0,0 +1,109 @@
+package cli
+
+import (
+	"io"
+	"sync"
+
+	"github.com/ShriKaranHanda/atomic/internal/ipc"
+)
+
+// stdinForwarder streams the client's stdin to atomicd, keeping at most
+// ipc.StdinWindow bytes that atomicd has not acknowledged in flight. When
+// stdin is the terminal, a review prompt borrows the input through divert so
+// the prompt and the forwarder never compete for the same keystrokes.
+type stdinForwarder struct {
+	send     io.WriteCloser
+	finished chan struct{}
+
+	mu       sync.Mutex
+	divert   chan []byte
+	inFlight int
+	acked    *sync.Cond
+}
+
+func newStdinForwarder(send io.WriteCloser) *stdinForwarder {
+	f := &stdinForwarder{send: send, finished: make(chan struct{})}
+	f.acked = sync.NewCond(&f.mu)
+	return f
+}
+
+// ack records that atomicd delivered n bytes to the script.
+func (f *stdinForwarder) ack(n int) {
+	f.mu.Lock()
+	f.inFlight -= n
+	f.mu.Unlock()
+	f.acked.Broadcast()
+}
+
+// reserve waits until n more bytes fit in the window.
+func (f *stdinForwarder) reserve(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for f.inFlight > 0 && f.inFlight+n > ipc.StdinWindow {
+		f.acked.Wait()
+	}
+	f.inFlight += n
+}
+
+func (f *stdinForwarder) run(in io.Reader) {
+	defer close(f.finished)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := in.Read(buf)
+		if n > 0 {
+			chunk := append([]byte(nil), buf[:n]...)
+			if divert := f.diverted(); divert != nil {
+				divert <- chunk
+			} else {
+				f.reserve(n)
+				if _, werr := f.send.Write(chunk); werr != nil {
+					return
+				}
+			}
+		}
+		if err != nil {
+			_ = f.send.Close()
+			return
+		}
+	}
+}
+
+func (f *stdinForwarder) diverted() chan []byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.divert
+}
+
+// borrow diverts stdin to the returned reader until release is called.
+func (f *stdinForwarder) borrow() (io.Reader, func()) {
+	ch := make(chan []byte, 16)
+	f.mu.Lock()
+	f.divert = ch
+	f.mu.Unlock()
+	release := func() {
+		f.mu.Lock()
+		f.divert = nil
+		f.mu.Unlock()
+	}
+	return &chanReader{ch: ch, finished: f.finished}, release
+}
+
+type chanReader struct {
+	ch       <-chan []byte
+	finished <-chan struct{}
+	buf      []byte
+}
+
+func (r *chanReader) Read(p []byte) (int, error) {
+	if len(r.buf) == 0 {
+		select {
+		case chunk := <-r.ch:
+			r.buf = chunk
+		case <-r.finished:
+			return 0, io.EOF
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
@@ -0,0 +1,93 @@
+//go:build linux
+
+package cli
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"unsafe"
+)
+
+type terminalState struct {
+	fd      uintptr
+	termios syscall.Termios
+}
+
+type winsize struct {
+	Rows   uint16
+	Cols   uint16
+	XPixel uint16
+	YPixel uint16
+}
+
+func isTerminal(fd uintptr) bool {
+	var termios syscall.Termios
+	return ioctl(fd, syscall.TCGETS, uintptr(unsafe.Pointer(&termios))) == nil
+}
+
+// makeRaw puts the terminal in raw mode like cfmakeraw(3) so keystrokes,
+// including Ctrl-C, reach the script's pseudo-terminal untouched.
+func makeRaw(fd uintptr) (*terminalState, error) {
+	state := &terminalState{fd: fd}
+	if err := ioctl(fd, syscall.TCGETS, uintptr(unsafe.Pointer(&state.termios))); err != nil {
+		return nil, err
+	}
+	raw := state.termios
+	raw.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	raw.Oflag &^= syscall.OPOST
+	raw.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	raw.Cflag &^= syscall.CSIZE | syscall.PARENB
+	raw.Cflag |= syscall.CS8
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+	if err := ioctl(fd, syscall.TCSETS, uintptr(unsafe.Pointer(&raw))); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+func (s *terminalState) restore() error {
+	return ioctl(s.fd, syscall.TCSETS, uintptr(unsafe.Pointer(&s.termios)))
+}
+
+func (s *terminalState) reapply() error {
+	_, err := makeRaw(s.fd)
+	return err
+}
+
+func windowSize(fd uintptr) (uint16, uint16, bool) {
+	var ws winsize
+	if err := ioctl(fd, syscall.TIOCGWINSZ, uintptr(unsafe.Pointer(&ws))); err != nil {
+		return 0, 0, false
+	}
+	return ws.Rows, ws.Cols, true
+}
+
+// watchWindowSize calls fn with the terminal size on every SIGWINCH until
+// stop is closed.
+func watchWindowSize(fd uintptr, fn func(rows, cols uint16), stop <-chan struct{}) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGWINCH)
+	go func() {
+		defer signal.Stop(ch)
+		for {
+			select {
+			case <-ch:
+				if rows, cols, ok := windowSize(fd); ok {
+					fn(rows, cols)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+func ioctl(fd, req, arg uintptr) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, arg)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+//go:build !linux
+
+package cli
+
+import "errors"
+
+type terminalState struct{}
+
+func isTerminal(fd uintptr) bool {
+	return false
+}
+
+func makeRaw(fd uintptr) (*terminalState, error) {
+	return nil, errors.New("terminal raw mode is only supported on Linux")
+}
+
+func (s *terminalState) restore() error {
+	return nil
+}
+
+func (s *terminalState) reapply() error {
+	return nil
+}
+
+func windowSize(fd uintptr) (uint16, uint16, bool) {
+	return 0, 0, false
+}
+
+func watchWindowSize(fd uintptr, fn func(rows, cols uint16), stop <-chan struct{}) {}
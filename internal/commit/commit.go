@@ -91,6 +91,11 @@ func (e Engine) backupPath(j *journal.Journal, opPath string) error {
 	if _, ok := j.BackupRefs[opPath]; ok {
 		return nil
 	}
+	// A path under one already backed up was saved with it, as it was before
+	// the commit; a backup of its own would overwrite that copy.
+	if BackedUpAbove(j.BackupRefs, opPath) {
+		return nil
+	}
 	if err := ensureDir(j.BackupDir); err != nil {
 		return err
 	}
@@ -113,6 +118,19 @@ func (e Engine) backupPath(j *journal.Journal, opPath string) error {
 	return nil
 }
 
+// BackedUpAbove reports whether refs hold a backup of a directory above
+// path, which restores path along with it.
+func BackedUpAbove(refs map[string]journal.BackupRef, path string) bool {
+	for dir := filepath.Dir(filepath.Clean(path)); ; dir = filepath.Dir(dir) {
+		if _, ok := refs[dir]; ok {
+			return true
+		}
+		if dir == "/" || dir == "." {
+			return false
+		}
+	}
+}
+
 func (e Engine) applyOperation(op journal.Operation) error {
 	target := e.targetPath(op.Path)
 	switch op.Kind {
@@ -290,6 +308,12 @@ func copyRegularFile(src, dst string) error {
 		out.Close()
 		return err
 	}
+	// The create mode is masked by the daemon's umask and ignored for an
+	// existing file; the source mode is what the script produced.
+	if err := out.Chmod(info.Mode().Perm()); err != nil {
+		out.Close()
+		return err
+	}
 	if err := out.Sync(); err != nil {
 		out.Close()
 		return err
@@ -355,9 +379,16 @@ func copyPathWithSkips(src, dst string, skipPrefixes []string) error {
 		if err := os.RemoveAll(dst); err != nil && !errors.Is(err, os.ErrNotExist) {
 			return err
 		}
-		return os.Symlink(link, dst)
+		if err := os.Symlink(link, dst); err != nil {
+			return err
+		}
+		return chownLikeSource(dst, info)
+	}
+	// Backups are restored over the originals, owner included.
+	if err := copyRegularFile(src, dst); err != nil {
+		return err
 	}
-	return copyRegularFile(src, dst)
+	return chownLikeSource(dst, info)
 }
 
 func shouldSkip(path string, skipPrefixes []string) bool {
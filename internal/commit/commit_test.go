@@ -1,6 +1,7 @@
 package commit
 
 import (
+	"os"
 	"path/filepath"
 	"testing"
 
@@ -21,6 +22,9 @@ func TestApplyUpsert(t *testing.T) {
 	if err := writeFile(src, []byte("new"), 0o644); err != nil {
 		t.Fatalf("write src: %v", err)
 	}
+	if err := os.Chmod(src, 0o664); err != nil {
+		t.Fatalf("chmod src: %v", err)
+	}
 
 	eng := Engine{RootPrefix: root}
 	j := &journal.Journal{
@@ -48,6 +52,13 @@ func TestApplyUpsert(t *testing.T) {
 	if string(got) != "new" {
 		t.Fatalf("unexpected committed content %q", got)
 	}
+	info, err := os.Stat(filepath.Join(root, "etc", "app.conf"))
+	if err != nil {
+		t.Fatalf("stat committed file: %v", err)
+	}
+	if info.Mode().Perm() != 0o664 {
+		t.Fatalf("expected committed mode 0664, got %o", info.Mode().Perm())
+	}
 }
 
 func TestRollbackOnFailure(t *testing.T) {
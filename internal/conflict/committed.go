@@ -0,0 +1,119 @@
+package conflict
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ShriKaranHanda/atomic/internal/journal"
+)
+
+// Committed is the changeset of a transaction committed by this daemon.
+type Committed struct {
+	RunID string
+	At    time.Time
+	Paths []string
+}
+
+// CheckCommitted reports a conflict if a transaction committed after txnStart
+// changed a path that ops change, or a directory above or a path below one.
+func CheckCommitted(ops []journal.Operation, txnStart time.Time, committed []Committed) error {
+	for _, c := range committed {
+		if !c.At.After(txnStart) {
+			continue
+		}
+		changed := map[string]string{}
+		for _, path := range c.Paths {
+			path = cleanAbs(path)
+			for _, parent := range parentChain(path) {
+				if _, ok := changed[parent]; !ok {
+					changed[parent] = path
+				}
+			}
+			// An exact match names the path itself.
+			changed[path] = path
+		}
+		for _, op := range ops {
+			path := cleanAbs(op.Path)
+			if other, ok := changed[path]; ok {
+				return overlapError(path, other, c.RunID)
+			}
+			for _, parent := range parentChain(path)[1:] {
+				if other, ok := changed[parent]; ok && other == parent {
+					return overlapError(path, other, c.RunID)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func overlapError(path, other, runID string) error {
+	if path == other {
+		return fmt.Errorf("%s was committed by run %s after this run started", path, runID)
+	}
+	return fmt.Errorf("%s overlaps %s, committed by run %s after this run started", path, other, runID)
+}
+
+// Log keeps the changesets committed while other transactions are in
+// progress. Entries are dropped once no transaction that started before them
+// is still running or prepared.
+type Log struct {
+	mu      sync.Mutex
+	entries []Committed
+	active  map[string]time.Time
+}
+
+// Begin registers transaction runID, which started at start, until End.
+func (l *Log) Begin(runID string, start time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.active == nil {
+		l.active = map[string]time.Time{}
+	}
+	l.active[runID] = start
+}
+
+// End forgets transaction runID once it has committed or given up.
+func (l *Log) End(runID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.active, runID)
+	l.prune()
+}
+
+func (l *Log) Add(c Committed) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, c)
+	l.prune()
+}
+
+// Since returns the changesets committed after start.
+func (l *Log) Since(start time.Time) []Committed {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var out []Committed
+	for _, c := range l.entries {
+		if c.At.After(start) {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+func (l *Log) prune() {
+	var oldest time.Time
+	for _, start := range l.active {
+		if oldest.IsZero() || start.Before(oldest) {
+			oldest = start
+		}
+	}
+	kept := l.entries[:0]
+	for _, c := range l.entries {
+		if !oldest.IsZero() && c.At.After(oldest) {
+			kept = append(kept, c)
+		}
+	}
+	l.entries = kept
+}
@@ -0,0 +1,54 @@
+package conflict
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ShriKaranHanda/atomic/internal/journal"
+)
+
+func TestCheckCommitted(t *testing.T) {
+	txnStart := time.Unix(100, 0)
+	committed := []Committed{
+		{RunID: "old", At: time.Unix(90, 0), Paths: []string{"/srv/app-a/config"}},
+		{RunID: "b", At: time.Unix(110, 0), Paths: []string{"/srv/app-b/config", "/srv/shared/new"}},
+	}
+	cases := []struct {
+		path string
+		want string
+	}{
+		{"/srv/app-a/config", ""},
+		{"/srv/app-b/other", ""},
+		{"/srv/app-b/config", "/srv/app-b/config was committed by run b"},
+		{"/srv/shared", "/srv/shared overlaps /srv/shared/new"},
+		{"/srv/shared/new/file", "/srv/shared/new/file overlaps /srv/shared/new"},
+	}
+	for _, c := range cases {
+		err := CheckCommitted([]journal.Operation{{Path: c.path}}, txnStart, committed)
+		if c.want == "" {
+			if err != nil {
+				t.Fatalf("%s: unexpected conflict: %v", c.path, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Fatalf("%s: got %v, want %q", c.path, err, c.want)
+		}
+	}
+}
+
+func TestLogKeepsEntriesForRunningTransactions(t *testing.T) {
+	var l Log
+	l.Begin("a", time.Unix(100, 0))
+	l.Begin("b", time.Unix(105, 0))
+	l.Add(Committed{RunID: "b", At: time.Unix(110, 0)})
+	l.End("b")
+	if got := l.Since(time.Unix(100, 0)); len(got) != 1 {
+		t.Fatalf("expected the commit to be kept for the running transaction, got %v", got)
+	}
+	l.End("a")
+	if got := l.Since(time.Unix(0, 0)); len(got) != 0 {
+		t.Fatalf("expected the log to be empty once nothing runs, got %v", got)
+	}
+}
@@ -0,0 +1,137 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ShriKaranHanda/atomic/internal/engine"
+	"github.com/ShriKaranHanda/atomic/internal/exitcode"
+	"github.com/ShriKaranHanda/atomic/internal/ipc"
+	"github.com/ShriKaranHanda/atomic/internal/journal"
+)
+
+// ApprovalRule holds runs that change anything under Paths until one of
+// Approvers, other than the caller who started the run, approves them.
+// Approvers are user names, numeric UIDs or groups written as "%group".
+type ApprovalRule struct {
+	Paths     []string `json:"paths"`
+	Approvers []string `json:"approvers"`
+}
+
+func validateApproval(rules []ApprovalRule) error {
+	for _, rule := range rules {
+		if len(rule.Paths) == 0 || len(rule.Approvers) == 0 {
+			return errors.New("approval rules need paths and approvers")
+		}
+		for _, path := range rule.Paths {
+			if !filepath.IsAbs(path) {
+				return fmt.Errorf("approval path %q is not absolute", path)
+			}
+		}
+	}
+	return nil
+}
+
+// guards returns the rule path that path is at or under.
+func (r ApprovalRule) guards(path string) (string, bool) {
+	for _, guarded := range r.Paths {
+		guarded = filepath.Clean(guarded)
+		if guarded == "/" || path == guarded || strings.HasPrefix(path, guarded+"/") {
+			return guarded, true
+		}
+	}
+	return "", false
+}
+
+// approvalReason says why ops need approval, or returns "".
+func approvalReason(rules []ApprovalRule, ops []journal.Operation) string {
+	for _, op := range ops {
+		for _, rule := range rules {
+			if guarded, ok := rule.guards(op.Path); ok {
+				return fmt.Sprintf("%s is under %s", op.Path, guarded)
+			}
+		}
+	}
+	return ""
+}
+
+// checkApprover lets caller approve j only if caller did not start the run,
+// a rule still guards one of its changes, and every rule guarding one of
+// them lists caller.
+func checkApprover(rules []ApprovalRule, j *journal.Journal, caller credentials) error {
+	name := userName(caller.UID)
+	if caller.UID == j.Prepared.Owner {
+		return fmt.Errorf("run %s must be approved by a user other than the one who started it", j.RunID)
+	}
+	guarded := false
+	for _, rule := range rules {
+		for _, op := range j.Ops {
+			if _, ok := rule.guards(op.Path); ok {
+				if !matchesCaller(rule.Approvers, caller, name) {
+					return fmt.Errorf("%s may not approve changes under %s", name, strings.Join(rule.Paths, ", "))
+				}
+				guarded = true
+				break
+			}
+		}
+	}
+	if !guarded {
+		return fmt.Errorf("no approval rule guards the changes of run %s any more, so nobody may approve it; its owner can discard it with atomic abort %s", j.RunID, j.RunID)
+	}
+	return nil
+}
+
+// approve shows a run held for approval to caller and commits it once
+// caller accepts.
+func (s *Server) approve(caller credentials, req ipc.Request, reader *ipc.Reader, writer *ipc.Writer) {
+	j, err := engine.LoadPrepared(s.cfg.JournalDir, req.RunID)
+	if err != nil {
+		_ = writer.WriteEvent(ipc.Event{Type: ipc.EventError, AtomicExitCode: exitcode.Unsupported, Message: err.Error()})
+		return
+	}
+	approval := j.Prepared.Approval
+	if approval == nil || approval.Approver != nil {
+		_ = writer.WriteEvent(ipc.Event{Type: ipc.EventError, AtomicExitCode: exitcode.Unsupported, Message: fmt.Sprintf("run %s does not need approval; its owner commits it with atomic commit %s", req.RunID, req.RunID)})
+		return
+	}
+	if err := checkApprover(s.cfg.Approval, j, caller); err != nil {
+		fmt.Fprintf(os.Stderr, "atomicd: uid %d (pid %d) denied approval of run %s: %v\n", caller.UID, caller.PID, req.RunID, err)
+		_ = writer.WriteEvent(ipc.Event{Type: ipc.EventError, AtomicExitCode: exitcode.Unsupported, Message: err.Error()})
+		return
+	}
+	_, changes, err := engine.DescribeRun(s.cfg.WorkDir, req.RunID, s.cfg.RootPrefix)
+	if err != nil {
+		_ = writer.WriteEvent(ipc.Event{Type: ipc.EventError, AtomicExitCode: exitcode.Unsupported, Message: err.Error()})
+		return
+	}
+	if err := writer.WriteEvent(ipc.Event{Type: ipc.EventReview, RunID: req.RunID, Changes: changes, Message: approval.Reason}); err != nil {
+		return
+	}
+	frame, err := reader.ReadFrame()
+	if err != nil || frame.Type != ipc.FrameReview || !frame.Accept {
+		_ = writer.WriteEvent(ipc.Event{Type: ipc.EventResult, RunID: req.RunID, AtomicExitCode: exitcode.Rejected, Message: fmt.Sprintf("approval declined; run %s is still pending", req.RunID)})
+		return
+	}
+
+	approver := &journal.Approver{User: userName(caller.UID), UID: caller.UID, PID: caller.PID, At: time.Now().UTC()}
+	result := engine.CommitPrepared(engine.PreparedRequest{
+		RunID:      req.RunID,
+		StateDir:   s.cfg.StateDir,
+		JournalDir: s.cfg.JournalDir,
+		RootPrefix: s.cfg.RootPrefix,
+		CommitLock: &s.commitMu,
+		Commits:    &s.commits,
+		Stdout:     &ipc.StreamEventWriter{Kind: ipc.EventStdout, RunID: req.RunID, Sink: writer.WriteEvent},
+		Stderr:     &ipc.StreamEventWriter{Kind: ipc.EventStderr, RunID: req.RunID, Sink: writer.WriteEvent},
+		Approver:   approver,
+	})
+	fmt.Fprintf(os.Stderr, "atomicd: run %s: uid %d (pid %d) approved the changes of uid %d, commit exit %d\n", req.RunID, caller.UID, caller.PID, j.Prepared.Owner, result.AtomicExitCode)
+	if result.AtomicExitCode == exitcode.OK {
+		result.Message = fmt.Sprintf("run %s approved and committed", req.RunID)
+	}
+	_ = writer.WriteEvent(ipc.Event{Type: ipc.EventResult, RunID: req.RunID, AtomicExitCode: result.AtomicExitCode, Message: result.Message})
+}
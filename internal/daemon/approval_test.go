@@ -0,0 +1,40 @@
+package daemon
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ShriKaranHanda/atomic/internal/journal"
+)
+
+func TestApprovalRules(t *testing.T) {
+	rules := []ApprovalRule{{Paths: []string{"/etc", "/usr/local/"}, Approvers: []string{"%27", "1500"}}}
+	if got := approvalReason(rules, []journal.Operation{{Path: "/etcetera/x"}, {Path: "/usr/local/bin/tool"}}); got != "/usr/local/bin/tool is under /usr/local" {
+		t.Fatalf("unexpected approval reason: %q", got)
+	}
+	if got := approvalReason(rules, []journal.Operation{{Path: "/srv/app"}}); got != "" {
+		t.Fatalf("unguarded change needs approval: %q", got)
+	}
+
+	j := &journal.Journal{RunID: "r", Ops: []journal.Operation{{Path: "/etc/hosts"}}, Prepared: &journal.Prepared{Owner: 1000}}
+	if err := checkApprover(rules, j, credentials{UID: 1500}); err != nil {
+		t.Fatalf("listed approver was refused: %v", err)
+	}
+	if err := checkApprover(rules, j, credentials{UID: 1001, GID: 1001, Groups: []uint32{27}}); err != nil {
+		t.Fatalf("approver by group was refused: %v", err)
+	}
+	if err := checkApprover(rules, j, credentials{UID: 1002, GID: 1002}); err == nil || !strings.Contains(err.Error(), "may not approve") {
+		t.Fatalf("unlisted approver was not refused: %v", err)
+	}
+	j.Prepared.Owner = 1500
+	if err := checkApprover(rules, j, credentials{UID: 1500}); err == nil || !strings.Contains(err.Error(), "other than the one who started it") {
+		t.Fatalf("the run's own caller could approve it: %v", err)
+	}
+	j.Prepared.Owner = 1000
+	if err := checkApprover(nil, j, credentials{UID: 1500}); err == nil || !strings.Contains(err.Error(), "no approval rule guards") {
+		t.Fatalf("a run no rule guards could be approved: %v", err)
+	}
+	if err := validateApproval([]ApprovalRule{{Paths: []string{"etc"}, Approvers: []string{"root"}}}); err == nil {
+		t.Fatalf("expected a relative approval path to be rejected")
+	}
+}
@@ -0,0 +1,33 @@
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigFileKeepsUnsetFields(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "atomicd.json")
+	if err := os.WriteFile(path, []byte(`{"work_dir": "/srv/runs", "env": {"allow": ["LANG"]}, "max_timeout": "10m"}`), 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	cfg := Config{SocketPath: "/tmp/a.sock", WorkDir: "/var/lib/atomic/runs"}
+	if err := LoadConfigFile(path, &cfg); err != nil {
+		t.Fatalf("LoadConfigFile returned error: %v", err)
+	}
+	if cfg.SocketPath != "/tmp/a.sock" || cfg.WorkDir != "/srv/runs" || time.Duration(cfg.MaxTimeout) != 10*time.Minute {
+		t.Fatalf("unexpected config: %#v", cfg)
+	}
+	applyDefaults(&cfg)
+	if len(cfg.Env.Allow) != 1 || len(cfg.Env.Deny) == 0 {
+		t.Fatalf("expected file allowlist and default denylist, got %#v", cfg.Env)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"sockt_path": "/tmp/b.sock"}`), 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	if err := LoadConfigFile(path, &cfg); err == nil {
+		t.Fatalf("expected unknown field to be rejected")
+	}
+}
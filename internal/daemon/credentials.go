@@ -0,0 +1,69 @@
+package daemon
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// credentials identify the process that connected to the daemon.
+type credentials struct {
+	UID    uint32
+	GID    uint32
+	PID    int32
+	Groups []uint32
+}
+
+// parseStatusGroups reads the supplementary groups from /proc/<pid>/status
+// after checking that the process still has the effective UID and GID the
+// socket reported, so a reused PID is not trusted.
+func parseStatusGroups(r io.Reader, uid, gid uint32) ([]uint32, error) {
+	ids := map[string][]string{}
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		key, value, ok := strings.Cut(sc.Text(), ":")
+		if ok && (key == "Uid" || key == "Gid" || key == "Groups") {
+			ids[key] = strings.Fields(value)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	// Uid and Gid list the real, effective, saved and filesystem IDs.
+	if len(ids["Uid"]) < 2 || ids["Uid"][1] != strconv.FormatUint(uint64(uid), 10) ||
+		len(ids["Gid"]) < 2 || ids["Gid"][1] != strconv.FormatUint(uint64(gid), 10) {
+		return nil, fmt.Errorf("process credentials changed since it connected")
+	}
+	groups := []uint32{}
+	for _, field := range ids["Groups"] {
+		n, err := strconv.ParseUint(field, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid group %q", field)
+		}
+		groups = append(groups, uint32(n))
+	}
+	return groups, nil
+}
+
+// databaseGroups looks up uid's groups in the group database, for callers
+// whose process could not be inspected.
+func databaseGroups(uid uint32) []uint32 {
+	u, err := user.LookupId(strconv.FormatUint(uint64(uid), 10))
+	if err != nil {
+		return []uint32{}
+	}
+	ids, err := u.GroupIds()
+	if err != nil {
+		return []uint32{}
+	}
+	groups := []uint32{}
+	for _, id := range ids {
+		if n, err := strconv.ParseUint(id, 10, 32); err == nil {
+			groups = append(groups, uint32(n))
+		}
+	}
+	return groups
+}
@@ -0,0 +1,21 @@
+package daemon
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseStatusGroups(t *testing.T) {
+	status := "Name:\tatomic\nUid:\t1000\t1000\t1000\t1000\nGid:\t1000\t1000\t1000\t1000\nGroups:\t27 33 1000 \n"
+
+	groups, err := parseStatusGroups(strings.NewReader(status), 1000, 1000)
+	if err != nil {
+		t.Fatalf("parseStatusGroups returned error: %v", err)
+	}
+	if len(groups) != 3 || groups[0] != 27 || groups[1] != 33 || groups[2] != 1000 {
+		t.Fatalf("unexpected groups: %v", groups)
+	}
+	if _, err := parseStatusGroups(strings.NewReader(status), 0, 0); err == nil {
+		t.Fatalf("expected error when the process runs as another user")
+	}
+}
@@ -0,0 +1,215 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"syscall"
+
+	"github.com/ShriKaranHanda/atomic/internal/changeset"
+	"github.com/ShriKaranHanda/atomic/internal/ipc"
+	"github.com/ShriKaranHanda/atomic/internal/overlay"
+)
+
+var errClientGone = errors.New("client disconnected")
+
+// forwardedSignals are the only signals a client may relay to its script.
+var forwardedSignals = map[int]bool{
+	int(syscall.SIGHUP):  true,
+	int(syscall.SIGINT):  true,
+	int(syscall.SIGTERM): true,
+}
+
+var errStdinOverrun = errors.New("client sent more stdin than atomicd acknowledged")
+
+// clientFrames routes frames the client sends after its request. Stdin data
+// is queued for a separate goroutine that writes it to a pipe, so a script
+// that does not read its input never holds up signals, resizes or review
+// decisions. Each chunk is acknowledged once the script has taken it, and
+// the client keeps at most ipc.StdinWindow bytes unacknowledged, so a slow
+// script still applies backpressure. The engine closes the read side when
+// the script exits, after which further stdin is dropped. The client keeps
+// the connection open until it has the result, so a read error means it is
+// gone and the run is cancelled, as it is for stdin that cannot be decoded.
+type clientFrames struct {
+	stdin   *io.PipeReader
+	reviews chan ipc.Frame
+	resizes chan overlay.WindowSize
+	signals chan int
+	done    chan struct{}
+}
+
+func readClientFrames(reader *ipc.Reader, writer *ipc.Writer, cancel context.CancelCauseFunc) *clientFrames {
+	pr, pw := io.Pipe()
+	cf := &clientFrames{
+		stdin:   pr,
+		reviews: make(chan ipc.Frame, 1),
+		resizes: make(chan overlay.WindowSize, 4),
+		signals: make(chan int, 4),
+		done:    make(chan struct{}),
+	}
+	queue := newStdinQueue()
+	go func() {
+		defer pw.Close()
+		for {
+			data, ok := queue.next()
+			if !ok {
+				return
+			}
+			_, _ = pw.Write(data)
+			_ = writer.WriteEvent(ipc.Event{Type: ipc.EventStdinAck, Acked: len(data)})
+		}
+	}()
+	go func() {
+		defer close(cf.done)
+		defer queue.close()
+		for {
+			frame, err := reader.ReadFrame()
+			if err != nil {
+				cancel(errClientGone)
+				return
+			}
+			switch frame.Type {
+			case ipc.FrameStdin:
+				data, err := ipc.DecodeData(frame.DataB64)
+				if err != nil {
+					err = fmt.Errorf("decode stdin frame: %w", err)
+					_ = pw.CloseWithError(err)
+					cancel(err)
+					return
+				}
+				if !queue.push(data) {
+					cancel(errStdinOverrun)
+					return
+				}
+			case ipc.FrameStdinClose:
+				queue.close()
+			case ipc.FrameResize:
+				select {
+				case cf.resizes <- overlay.WindowSize{Rows: frame.Rows, Cols: frame.Cols}:
+				default:
+				}
+			case ipc.FrameSignal:
+				if !forwardedSignals[frame.Signal] {
+					continue
+				}
+				select {
+				case cf.signals <- frame.Signal:
+				default:
+				}
+			case ipc.FrameReview:
+				select {
+				case cf.reviews <- frame:
+				default:
+				}
+			}
+		}
+	}()
+	return cf
+}
+
+// stdinQueue holds up to ipc.StdinWindow bytes of stdin on their way to the
+// script.
+type stdinQueue struct {
+	mu     sync.Mutex
+	chunks [][]byte
+	size   int
+	closed bool
+	ready  chan struct{}
+}
+
+func newStdinQueue() *stdinQueue {
+	return &stdinQueue{ready: make(chan struct{}, 1)}
+}
+
+// push queues data, or reports false if that would exceed the window.
+func (q *stdinQueue) push(data []byte) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return true
+	}
+	if q.size+len(data) > ipc.StdinWindow {
+		return false
+	}
+	q.chunks = append(q.chunks, data)
+	q.size += len(data)
+	q.wake()
+	return true
+}
+
+// close ends the input once the queued data has been taken.
+func (q *stdinQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.wake()
+}
+
+// next waits for the next chunk; it reports false once the queue is closed
+// and empty.
+func (q *stdinQueue) next() ([]byte, bool) {
+	for {
+		q.mu.Lock()
+		if len(q.chunks) > 0 {
+			data := q.chunks[0]
+			q.chunks[0] = nil
+			q.chunks = q.chunks[1:]
+			q.size -= len(data)
+			q.mu.Unlock()
+			return data, true
+		}
+		closed := q.closed
+		q.mu.Unlock()
+		if closed {
+			return nil, false
+		}
+		<-q.ready
+	}
+}
+
+func (q *stdinQueue) wake() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (cf *clientFrames) review(emit func(ipc.Event) error, runID string, changes []changeset.Change) ([]string, error) {
+	if err := emit(ipc.Event{Type: ipc.EventReview, RunID: runID, Changes: changes}); err != nil {
+		return nil, err
+	}
+	var frame ipc.Frame
+	select {
+	case frame = <-cf.reviews:
+	case <-cf.done:
+		return nil, errClientGone
+	}
+	if !frame.Accept {
+		return nil, nil
+	}
+	if frame.Paths == nil {
+		all := make([]string, 0, len(changes))
+		for _, c := range changes {
+			all = append(all, c.Path)
+		}
+		return all, nil
+	}
+	for _, path := range frame.Paths {
+		if !containsPath(changes, path) {
+			return nil, fmt.Errorf("review selected unknown path %s", path)
+		}
+	}
+	return frame.Paths, nil
+}
+
+func containsPath(changes []changeset.Change, path string) bool {
+	for _, c := range changes {
+		if c.Path == path {
+			return true
+		}
+	}
+	return false
+}
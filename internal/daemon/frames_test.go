@@ -0,0 +1,87 @@
+package daemon
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/ShriKaranHanda/atomic/internal/ipc"
+)
+
+func TestClientFramesSignalPastUnreadStdin(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	// Nothing reads cf.stdin, like a script that ignores its input.
+	cf := readClientFrames(ipc.NewReader(pr), ipc.NewWriter(io.Discard), cancel)
+
+	client := ipc.NewWriter(pw)
+	chunk := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("y", 32*1024)))
+	sent := make(chan error, 1)
+	go func() {
+		for i := 0; i < 16; i++ {
+			if err := client.WriteFrame(ipc.Frame{Type: ipc.FrameStdin, DataB64: chunk}); err != nil {
+				sent <- err
+				return
+			}
+		}
+		sent <- client.WriteFrame(ipc.Frame{Type: ipc.FrameSignal, Signal: int(syscall.SIGINT)})
+	}()
+	select {
+	case sig := <-cf.signals:
+		if sig != int(syscall.SIGINT) {
+			t.Fatalf("unexpected signal %d", sig)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("signal was held up behind unread stdin")
+	}
+	if err := <-sent; err != nil {
+		t.Fatalf("write frames: %v", err)
+	}
+
+	// A client that ignores the window cancels the run instead.
+	go func() {
+		for i := 0; i < 64; i++ {
+			if client.WriteFrame(ipc.Frame{Type: ipc.FrameStdin, DataB64: chunk}) != nil {
+				return
+			}
+		}
+	}()
+	select {
+	case <-ctx.Done():
+		if !errors.Is(context.Cause(ctx), errStdinOverrun) {
+			t.Fatalf("unexpected cause %v", context.Cause(ctx))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("stdin beyond the window did not cancel the run")
+	}
+}
+
+func TestClientFramesMalformedStdin(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	cf := readClientFrames(ipc.NewReader(pr), ipc.NewWriter(io.Discard), cancel)
+
+	go func() {
+		_ = ipc.NewWriter(pw).WriteFrame(ipc.Frame{Type: ipc.FrameStdin, DataB64: "not base64!"})
+	}()
+	select {
+	case <-ctx.Done():
+		if !strings.Contains(context.Cause(ctx).Error(), "decode stdin frame") {
+			t.Fatalf("unexpected cause %v", context.Cause(ctx))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("a malformed stdin frame did not cancel the run")
+	}
+	if _, err := io.ReadAll(cf.stdin); err == nil || !strings.Contains(err.Error(), "decode stdin frame") {
+		t.Fatalf("expected stdin to end with the decode error, got %v", err)
+	}
+}
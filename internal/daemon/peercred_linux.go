@@ -5,37 +5,49 @@ package daemon
 import (
 	"fmt"
 	"net"
+	"os"
 	"syscall"
 	"unsafe"
 )
 
-func peerCredentials(conn net.Conn) (uint32, uint32, error) {
+// peerCredentials returns the UID, GID and PID SO_PEERCRED reports for the
+// other end of conn, plus that process's supplementary groups.
+func peerCredentials(conn net.Conn) (credentials, error) {
 	unixConn, ok := conn.(*net.UnixConn)
 	if !ok {
-		return 0, 0, fmt.Errorf("expected unix connection, got %T", conn)
+		return credentials{}, fmt.Errorf("expected unix connection, got %T", conn)
 	}
 	rawConn, err := unixConn.SyscallConn()
 	if err != nil {
-		return 0, 0, err
+		return credentials{}, err
 	}
-	var uid uint32
-	var gid uint32
+	var cred *syscall.Ucred
 	var controlErr error
 	if err := rawConn.Control(func(fd uintptr) {
-		cred, err := getsockoptUcred(int(fd))
-		if err != nil {
-			controlErr = err
-			return
-		}
-		uid = cred.Uid
-		gid = cred.Gid
+		cred, controlErr = getsockoptUcred(int(fd))
 	}); err != nil {
-		return 0, 0, err
+		return credentials{}, err
 	}
 	if controlErr != nil {
-		return 0, 0, controlErr
+		return credentials{}, controlErr
 	}
-	return uid, gid, nil
+	c := credentials{UID: cred.Uid, GID: cred.Gid, PID: cred.Pid}
+	c.Groups, err = processGroups(cred.Pid, cred.Uid, cred.Gid)
+	if err != nil {
+		// The client may have exited or changed identity; the group
+		// database still gives the user's configured groups.
+		c.Groups = databaseGroups(cred.Uid)
+	}
+	return c, nil
+}
+
+func processGroups(pid int32, uid, gid uint32) ([]uint32, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseStatusGroups(f, uid, gid)
 }
 
 func getsockoptUcred(fd int) (*syscall.Ucred, error) {
@@ -27,13 +27,17 @@ func TestPeerCredentials(t *testing.T) {
 			return
 		}
 		defer conn.Close()
-		uid, gid, err := peerCredentials(conn)
+		cred, err := peerCredentials(conn)
 		if err != nil {
 			t.Errorf("peerCredentials returned error: %v", err)
 			return
 		}
-		if uid != uint32(os.Geteuid()) || gid != uint32(os.Getegid()) {
-			t.Errorf("unexpected peer creds uid=%d gid=%d", uid, gid)
+		if cred.UID != uint32(os.Geteuid()) || cred.GID != uint32(os.Getegid()) || cred.PID != int32(os.Getpid()) {
+			t.Errorf("unexpected peer creds %+v", cred)
+		}
+		groups, _ := os.Getgroups()
+		if len(cred.Groups) != len(groups) {
+			t.Errorf("expected groups %v, got %v", groups, cred.Groups)
 		}
 	}()
 	<-ready
@@ -7,6 +7,6 @@ import (
 	"net"
 )
 
-func peerCredentials(conn net.Conn) (uint32, uint32, error) {
-	return 0, 0, fmt.Errorf("peer credential lookup is only supported on Linux")
+func peerCredentials(conn net.Conn) (credentials, error) {
+	return credentials{}, fmt.Errorf("peer credential lookup is only supported on Linux")
 }
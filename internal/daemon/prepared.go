@@ -0,0 +1,81 @@
+package daemon
+
+import (
+	"fmt"
+
+	"github.com/ShriKaranHanda/atomic/internal/engine"
+	"github.com/ShriKaranHanda/atomic/internal/exitcode"
+	"github.com/ShriKaranHanda/atomic/internal/ipc"
+	"github.com/ShriKaranHanda/atomic/internal/journal"
+)
+
+// finishPrepared commits or aborts a prepared run for its owner or root.
+func (s *Server) finishPrepared(caller credentials, req ipc.Request, writer *ipc.Writer) {
+	j, err := engine.LoadPrepared(s.cfg.JournalDir, req.RunID)
+	if err != nil {
+		_ = writer.WriteEvent(ipc.Event{Type: ipc.EventError, AtomicExitCode: exitcode.Unsupported, Message: err.Error()})
+		return
+	}
+	if caller.UID != 0 && caller.UID != j.Prepared.Owner {
+		_ = writer.WriteEvent(ipc.Event{Type: ipc.EventError, AtomicExitCode: exitcode.Unsupported, Message: fmt.Sprintf("run %s belongs to another user", req.RunID)})
+		return
+	}
+	prepared := engine.PreparedRequest{
+		RunID:      req.RunID,
+		StateDir:   s.cfg.StateDir,
+		JournalDir: s.cfg.JournalDir,
+		RootPrefix: s.cfg.RootPrefix,
+		CommitLock: &s.commitMu,
+		Commits:    &s.commits,
+		Stdout:     &ipc.StreamEventWriter{Kind: ipc.EventStdout, RunID: req.RunID, Sink: writer.WriteEvent},
+		Stderr:     &ipc.StreamEventWriter{Kind: ipc.EventStderr, RunID: req.RunID, Sink: writer.WriteEvent},
+	}
+	var result engine.ExecuteResult
+	if req.Type == ipc.RequestCommit {
+		result = engine.CommitPrepared(prepared)
+	} else {
+		result = engine.AbortPrepared(prepared)
+	}
+	_ = writer.WriteEvent(ipc.Event{Type: ipc.EventResult, RunID: req.RunID, AtomicExitCode: result.AtomicExitCode, Message: result.Message})
+}
+
+// list reports the caller's runs, or every run to root: queued, running and
+// recently finished runs, then prepared ones. Runs waiting for approval are
+// also shown to the users who may approve them.
+func (s *Server) list(caller credentials, req ipc.Request, writer *ipc.Writer) {
+	if req.History {
+		s.listHistory(caller, writer)
+		return
+	}
+	prepared, err := journal.ListPrepared(s.cfg.JournalDir)
+	if err != nil {
+		_ = writer.WriteEvent(ipc.Event{Type: ipc.EventError, AtomicExitCode: exitcode.Unsupported, Message: err.Error()})
+		return
+	}
+	visible := func(owner uint32) bool { return caller.UID == 0 || caller.UID == owner }
+	isPrepared := map[string]bool{}
+	var runs []ipc.RunInfo
+	for _, j := range prepared {
+		isPrepared[j.RunID] = true
+		pending := j.Prepared.Approval != nil && j.Prepared.Approval.Approver == nil
+		if !visible(j.Prepared.Owner) && !(pending && checkApprover(s.cfg.Approval, j, caller) == nil) {
+			continue
+		}
+		info := ipc.RunInfo{RunID: j.RunID, State: journal.StatePrepared, Owner: j.Prepared.Owner, ScriptPath: j.Prepared.ScriptPath, Started: j.TxnStart, Changes: len(j.Ops)}
+		if pending {
+			info.State = "pending"
+		}
+		runs = append(runs, info)
+	}
+	if !req.Prepared {
+		var active []ipc.RunInfo
+		for _, sess := range s.sessions.list() {
+			if visible(sess.owner) && !isPrepared[sess.runID] {
+				active = append(active, sess.info())
+			}
+		}
+		runs = append(active, runs...)
+	}
+	_ = writer.WriteEvent(ipc.Event{Type: ipc.EventRuns, Runs: runs})
+	_ = writer.WriteEvent(ipc.Event{Type: ipc.EventResult, AtomicExitCode: exitcode.OK})
+}
@@ -0,0 +1,210 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Defaults for configs that set no limits.
+const (
+	defaultMaxConcurrent = 4
+	defaultMaxQueue      = 16
+)
+
+var errBusy = errors.New("atomicd is busy")
+
+// runQueue admits up to slots runs at a time, each holding its named locks
+// exclusively. Runs that cannot start wait in arrival order; a waiting run is
+// only overtaken by later runs that need none of its locks, so it cannot be
+// starved of a lock.
+type runQueue struct {
+	slots int
+	max   int
+
+	mu      sync.Mutex
+	running int
+	held    map[string]*queueTicket
+	waiting []*queueTicket
+}
+
+type queueTicket struct {
+	runID  string
+	locks  []string
+	ready  chan struct{}
+	status chan queueStatus
+	last   queueStatus
+}
+
+// queueStatus is a waiting run's place in the queue (1 is next) and what it
+// is waiting for.
+type queueStatus struct {
+	Position int
+	Reason   string
+}
+
+func newRunQueue(slots, max int) *runQueue {
+	return &runQueue{slots: slots, max: max, held: map[string]*queueTicket{}}
+}
+
+// acquire takes a run slot and the locks or, if wait is set, a place in the
+// queue. The caller owns both once the ticket's ready channel is closed.
+func (q *runQueue) acquire(runID string, locks []string, wait bool) (*queueTicket, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	t := &queueTicket{runID: runID, locks: locks, ready: make(chan struct{}), status: make(chan queueStatus, 1)}
+	claimed := map[string]bool{}
+	for _, w := range q.waiting {
+		for _, name := range w.locks {
+			claimed[name] = true
+		}
+	}
+	reason := q.blockedLocked(t, claimed)
+	if reason == "" {
+		q.admitLocked(t)
+		return t, nil
+	}
+	if !wait {
+		return nil, fmt.Errorf("%w: %s", errBusy, reason)
+	}
+	if len(q.waiting) >= q.max {
+		return nil, fmt.Errorf("%w: %s and the queue is full (%d waiting)", errBusy, reason, len(q.waiting))
+	}
+	q.waiting = append(q.waiting, t)
+	t.notify(queueStatus{Position: len(q.waiting), Reason: reason})
+	return t, nil
+}
+
+// wait blocks until t holds a run slot, calling report whenever its place in
+// the queue changes. If ctx ends first, t leaves the queue.
+func (q *runQueue) wait(ctx context.Context, t *queueTicket, report func(queueStatus)) error {
+	for {
+		select {
+		case <-t.ready:
+			return nil
+		case status := <-t.status:
+			report(status)
+		case <-ctx.Done():
+			q.cancel(t)
+			return context.Cause(ctx)
+		}
+	}
+}
+
+// release gives up t's slot and locks.
+func (q *runQueue) release(t *queueTicket) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.releaseLocked(t)
+}
+
+func (q *runQueue) releaseLocked(t *queueTicket) {
+	q.running--
+	for _, name := range t.locks {
+		if q.held[name] == t {
+			delete(q.held, name)
+		}
+	}
+	q.dispatchLocked()
+}
+
+// cancel removes t from the queue. A ticket that was admitted in the
+// meantime gives its slot and locks back.
+func (q *runQueue) cancel(t *queueTicket) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, w := range q.waiting {
+		if w == t {
+			q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+			q.dispatchLocked()
+			return
+		}
+	}
+	q.releaseLocked(t)
+}
+
+// dispatchLocked admits waiting runs in order and tells the others where
+// they stand. Locks wanted by a run that stays queued are claimed for it, so
+// later runs cannot take them first.
+func (q *runQueue) dispatchLocked() {
+	claimed := map[string]bool{}
+	kept := q.waiting[:0]
+	for _, t := range q.waiting {
+		reason := q.blockedLocked(t, claimed)
+		if reason == "" {
+			q.admitLocked(t)
+			continue
+		}
+		for _, name := range t.locks {
+			claimed[name] = true
+		}
+		kept = append(kept, t)
+		t.notify(queueStatus{Position: len(kept), Reason: reason})
+	}
+	q.waiting = kept
+}
+
+// blockedLocked says why t cannot start now, or "" if it can.
+func (q *runQueue) blockedLocked(t *queueTicket, claimed map[string]bool) string {
+	for _, name := range t.locks {
+		if holder, ok := q.held[name]; ok {
+			return fmt.Sprintf("lock %s is held by run %s", name, holder.runID)
+		}
+	}
+	for _, name := range t.locks {
+		if claimed[name] {
+			return fmt.Sprintf("lock %s is wanted by an earlier run", name)
+		}
+	}
+	if q.running >= q.slots {
+		return fmt.Sprintf("%d transactions running", q.running)
+	}
+	return ""
+}
+
+func (q *runQueue) admitLocked(t *queueTicket) {
+	q.running++
+	for _, name := range t.locks {
+		q.held[name] = t
+	}
+	close(t.ready)
+}
+
+// notify replaces any status the waiter has not seen yet.
+func (t *queueTicket) notify(status queueStatus) {
+	if status == t.last {
+		return
+	}
+	t.last = status
+	select {
+	case <-t.status:
+	default:
+	}
+	t.status <- status
+}
+
+// lockNames validates requested lock names. Names starting with "/" are
+// paths and are cleaned, so "/etc/ssl/" and "/etc/ssl" are the same lock.
+func lockNames(names []string) ([]string, error) {
+	seen := map[string]bool{}
+	out := make([]string, 0, len(names))
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			return nil, errors.New("lock names must not be empty")
+		}
+		if strings.HasPrefix(name, "/") {
+			name = filepath.Clean(name)
+		}
+		if !seen[name] {
+			seen[name] = true
+			out = append(out, name)
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
@@ -0,0 +1,135 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRunQueueOrder(t *testing.T) {
+	q := newRunQueue(1, 2)
+	first, err := q.acquire("a", nil, false)
+	if err != nil {
+		t.Fatalf("first acquire: %v", err)
+	}
+	<-first.ready
+	if _, err := q.acquire("x", nil, false); !errors.Is(err, errBusy) {
+		t.Fatalf("expected busy without wait, got %v", err)
+	}
+	second, err := q.acquire("b", nil, true)
+	if err != nil {
+		t.Fatalf("second acquire: %v", err)
+	}
+	third, err := q.acquire("c", nil, true)
+	if err != nil {
+		t.Fatalf("third acquire: %v", err)
+	}
+	if _, err := q.acquire("x", nil, true); !errors.Is(err, errBusy) {
+		t.Fatalf("expected a full queue, got %v", err)
+	}
+	if status := <-third.status; status.Position != 2 {
+		t.Fatalf("third position = %d, want 2", status.Position)
+	}
+
+	q.release(first)
+	if err := q.wait(context.Background(), second, func(queueStatus) {}); err != nil {
+		t.Fatalf("second wait: %v", err)
+	}
+	if status := <-third.status; status.Position != 1 {
+		t.Fatalf("third position after release = %d, want 1", status.Position)
+	}
+	q.release(second)
+	<-third.ready
+	q.release(third)
+	if q.running != 0 {
+		t.Fatalf("queue still has %d running after the last release", q.running)
+	}
+}
+
+func TestRunQueueSlots(t *testing.T) {
+	q := newRunQueue(2, 1)
+	var running []*queueTicket
+	for i := 0; i < 2; i++ {
+		ticket, err := q.acquire("r", nil, false)
+		if err != nil {
+			t.Fatalf("acquire %d: %v", i, err)
+		}
+		running = append(running, ticket)
+	}
+	if _, err := q.acquire("x", nil, false); !errors.Is(err, errBusy) {
+		t.Fatalf("expected busy with both slots taken, got %v", err)
+	}
+	waiter, _ := q.acquire("w", nil, true)
+	q.release(running[0])
+	<-waiter.ready
+	q.release(running[1])
+	q.release(waiter)
+	if q.running != 0 {
+		t.Fatalf("queue still has %d running after the last release", q.running)
+	}
+}
+
+func TestRunQueueLocks(t *testing.T) {
+	q := newRunQueue(4, 4)
+	nginx, err := q.acquire("a", []string{"nginx"}, false)
+	if err != nil {
+		t.Fatalf("acquire nginx: %v", err)
+	}
+	if _, err := q.acquire("x", []string{"nginx"}, false); err == nil || !strings.Contains(err.Error(), "lock nginx is held by run a") {
+		t.Fatalf("expected the held lock to be reported, got %v", err)
+	}
+	waiter, _ := q.acquire("b", []string{"nginx", "ssl"}, true)
+	// ssl is free but claimed by the waiting run, so a later run must queue
+	// behind it; a run needing other locks starts right away.
+	later, _ := q.acquire("c", []string{"ssl"}, true)
+	if status := <-later.status; !strings.Contains(status.Reason, "wanted by an earlier run") {
+		t.Fatalf("unexpected reason for the later run: %q", status.Reason)
+	}
+	other, err := q.acquire("d", []string{"postgres"}, false)
+	if err != nil {
+		t.Fatalf("run with unrelated locks was blocked: %v", err)
+	}
+
+	q.release(nginx)
+	<-waiter.ready
+	q.release(waiter)
+	<-later.ready
+	q.release(later)
+	q.release(other)
+	if len(q.held) != 0 || q.running != 0 {
+		t.Fatalf("locks or slots left after release: %v, %d", q.held, q.running)
+	}
+}
+
+func TestRunQueueCancel(t *testing.T) {
+	q := newRunQueue(1, 4)
+	if _, err := q.acquire("a", nil, false); err != nil {
+		t.Fatalf("first acquire: %v", err)
+	}
+	leaving, _ := q.acquire("b", nil, true)
+	staying, _ := q.acquire("c", nil, true)
+	<-staying.status
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := q.wait(ctx, leaving, func(queueStatus) {}); err == nil {
+		t.Fatalf("expected a cancelled wait to fail")
+	}
+	if status := <-staying.status; status.Position != 1 {
+		t.Fatalf("position after the waiter ahead left = %d, want 1", status.Position)
+	}
+}
+
+func TestLockNames(t *testing.T) {
+	got, err := lockNames([]string{"nginx", "/etc/ssl/", "/etc//ssl", "nginx"})
+	if err != nil {
+		t.Fatalf("lockNames: %v", err)
+	}
+	if strings.Join(got, ",") != "/etc/ssl,nginx" {
+		t.Fatalf("unexpected lock names: %v", got)
+	}
+	if _, err := lockNames([]string{" "}); err == nil {
+		t.Fatalf("expected an empty lock name to be rejected")
+	}
+}
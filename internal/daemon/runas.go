@@ -0,0 +1,124 @@
+package daemon
+
+import (
+	"fmt"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// RunAsRule lets non-root callers run transactions as other accounts. Both
+// lists hold user names or numeric IDs; a caller entry starting with "%"
+// names a group the caller must be a member of.
+type RunAsRule struct {
+	Callers []string `json:"callers"`
+	Users   []string `json:"users"`
+}
+
+// account is a run-as identity requested by a client.
+type account struct {
+	Name   string
+	UID    uint32
+	GID    uint32
+	Groups []uint32
+}
+
+// resolveRunAs looks up the requested account and checks that caller may
+// use it: root always may, anyone else only through a rule.
+func resolveRunAs(rules []RunAsRule, caller credentials, requested string) (account, error) {
+	target, err := lookupAccount(requested)
+	if err != nil {
+		return account{}, err
+	}
+	if caller.UID == 0 {
+		return target, nil
+	}
+	callerName := userName(caller.UID)
+	for _, rule := range rules {
+		if rule.permits(caller, callerName, target) {
+			return target, nil
+		}
+	}
+	return account{}, fmt.Errorf("%s may not run transactions as %s", callerName, target.Name)
+}
+
+func (r RunAsRule) permits(caller credentials, callerName string, target account) bool {
+	if !matchesCaller(r.Callers, caller, callerName) {
+		return false
+	}
+	for _, entry := range r.Users {
+		if entry == target.Name || entry == strconv.FormatUint(uint64(target.UID), 10) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesCaller reports whether caller is one of entries: user names,
+// numeric UIDs, or groups written as "%group".
+func matchesCaller(entries []string, caller credentials, callerName string) bool {
+	for _, entry := range entries {
+		if group, ok := strings.CutPrefix(entry, "%"); ok {
+			if inGroup(caller, group) {
+				return true
+			}
+		} else if entry == callerName || entry == strconv.FormatUint(uint64(caller.UID), 10) {
+			return true
+		}
+	}
+	return false
+}
+
+// userName returns uid's user name, or the number if it has none.
+func userName(uid uint32) string {
+	name := strconv.FormatUint(uint64(uid), 10)
+	if u, err := user.LookupId(name); err == nil {
+		name = u.Username
+	}
+	return name
+}
+
+func inGroup(caller credentials, group string) bool {
+	gid, err := strconv.ParseUint(group, 10, 32)
+	if err != nil {
+		g, lookupErr := user.LookupGroup(group)
+		if lookupErr != nil {
+			return false
+		}
+		if gid, err = strconv.ParseUint(g.Gid, 10, 32); err != nil {
+			return false
+		}
+	}
+	if uint32(gid) == caller.GID {
+		return true
+	}
+	for _, g := range caller.Groups {
+		if g == uint32(gid) {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupAccount resolves a user name or numeric UID with its primary group
+// and group memberships.
+func lookupAccount(name string) (account, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		if _, numErr := strconv.ParseUint(name, 10, 32); numErr != nil {
+			return account{}, fmt.Errorf("unknown user %q", name)
+		}
+		if u, err = user.LookupId(name); err != nil {
+			return account{}, fmt.Errorf("unknown user %q", name)
+		}
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return account{}, fmt.Errorf("user %s has non-numeric uid %q", u.Username, u.Uid)
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return account{}, fmt.Errorf("user %s has non-numeric gid %q", u.Username, u.Gid)
+	}
+	return account{Name: u.Username, UID: uint32(uid), GID: uint32(gid), Groups: databaseGroups(uint32(uid))}, nil
+}
@@ -0,0 +1,26 @@
+package daemon
+
+import "testing"
+
+func TestRunAsRulePermits(t *testing.T) {
+	rule := RunAsRule{Callers: []string{"deploy", "%27"}, Users: []string{"appuser", "1500"}}
+	app := account{Name: "appuser", UID: 1001}
+	cases := []struct {
+		name       string
+		caller     credentials
+		callerName string
+		target     account
+		want       bool
+	}{
+		{"named caller", credentials{UID: 1000, GID: 1000}, "deploy", app, true},
+		{"group member", credentials{UID: 1002, GID: 1002, Groups: []uint32{27}}, "ops", app, true},
+		{"target by uid", credentials{UID: 1000}, "deploy", account{Name: "svc", UID: 1500}, true},
+		{"other caller", credentials{UID: 1003, GID: 1003}, "mallory", app, false},
+		{"other target", credentials{UID: 1000}, "deploy", account{Name: "root", UID: 0}, false},
+	}
+	for _, tc := range cases {
+		if got := rule.permits(tc.caller, tc.callerName, tc.target); got != tc.want {
+			t.Fatalf("%s: got %v want %v", tc.name, got, tc.want)
+		}
+	}
+}
@@ -13,27 +13,72 @@ import (
 	"sync"
 	"time"
 
+	"github.com/ShriKaranHanda/atomic/internal/cgroup"
+	"github.com/ShriKaranHanda/atomic/internal/changeset"
+	"github.com/ShriKaranHanda/atomic/internal/conflict"
 	"github.com/ShriKaranHanda/atomic/internal/engine"
+	"github.com/ShriKaranHanda/atomic/internal/environ"
 	"github.com/ShriKaranHanda/atomic/internal/exitcode"
 	"github.com/ShriKaranHanda/atomic/internal/ipc"
+	"github.com/ShriKaranHanda/atomic/internal/journal"
+	"github.com/ShriKaranHanda/atomic/internal/overlay"
 	"github.com/ShriKaranHanda/atomic/internal/preflight"
 )
 
 const DefaultSocketPath = "/run/atomicd.sock"
 
+// defaultUmask applies to clients that do not send their own.
+const defaultUmask = 0o022
+
+// defaultMaxHealthTimeout caps health windows when the config sets no cap.
+const defaultMaxHealthTimeout = Duration(5 * time.Minute)
+
 type Config struct {
-	SocketPath string
-	StateDir   string
-	WorkDir    string
-	JournalDir string
-	RootPrefix string
+	SocketPath string         `json:"socket_path"`
+	StateDir   string         `json:"state_dir"`
+	WorkDir    string         `json:"work_dir"`
+	JournalDir string         `json:"journal_dir"`
+	RootPrefix string         `json:"root_prefix"`
+	Env        environ.Policy `json:"env"`
+
+	// MaxTimeout and MaxIdleTimeout cap the per-run limits; a run that asks
+	// for none gets the maximum. Zero means unlimited.
+	MaxTimeout     Duration `json:"max_timeout"`
+	MaxIdleTimeout Duration `json:"max_idle_timeout"`
+	// MaxHealthTimeout caps the health window of a run, during which other
+	// commits wait. It is never unlimited.
+	MaxHealthTimeout Duration `json:"max_health_timeout"`
+	// MaxLimits are resource ceilings applied to every run, including runs
+	// that request no limits.
+	MaxLimits cgroup.Limits `json:"max_limits"`
+	// PIDNamespace is the default for runs that do not choose.
+	PIDNamespace bool `json:"pid_namespace"`
+	// RunAs lets non-root callers request another run-as account.
+	RunAs []RunAsRule `json:"run_as"`
+	// Network is the default network mode (host, none or loopback) for runs
+	// that do not choose.
+	Network string `json:"network"`
+	// MaxConcurrent is how many runs may execute at once; MaxQueue is how
+	// many more may wait for a slot.
+	MaxConcurrent int `json:"max_concurrent"`
+	MaxQueue      int `json:"max_queue"`
+	// Approval holds runs that change guarded paths for a second user's
+	// approval.
+	Approval []ApprovalRule `json:"approval"`
+	// Validators must pass before a run that they apply to is committed.
+	Validators []ValidatorRule `json:"validators"`
 }
 
 type Server struct {
-	cfg Config
+	cfg      Config
+	cgroups  *cgroup.Manager
+	runs     *runQueue
+	sessions sessionTable
 
-	runMu   sync.Mutex
-	running bool
+	// commitMu serializes commits and recovery; commits remembers what
+	// concurrent runs committed.
+	commitMu sync.Mutex
+	commits  conflict.Log
 }
 
 func Run(ctx context.Context, cfg Config) error {
@@ -41,19 +86,49 @@ func Run(ctx context.Context, cfg Config) error {
 		return err
 	}
 	applyDefaults(&cfg)
+	if !overlay.ValidNetwork(cfg.Network) {
+		return fmt.Errorf("unsupported network mode %q (want host, none or loopback)", cfg.Network)
+	}
+	if cfg.MaxConcurrent < 1 || cfg.MaxQueue < 1 {
+		return fmt.Errorf("max concurrent runs and max queue must be at least 1, got %d and %d", cfg.MaxConcurrent, cfg.MaxQueue)
+	}
+	if err := validateApproval(cfg.Approval); err != nil {
+		return err
+	}
+	if err := validateValidators(cfg.Validators); err != nil {
+		return err
+	}
 
-	recovery := engine.RecoverOnly(cfg.JournalDir, cfg.RootPrefix)
+	recovery := engine.RecoverOnly(cfg.JournalDir, cfg.StateDir, cfg.RootPrefix)
 	if recovery.AtomicExitCode != exitcode.OK {
 		return fmt.Errorf("startup recovery failed: %s", recovery.Message)
 	}
 
+	cgroups, err := cgroup.Setup()
+	if err != nil {
+		if !cfg.MaxLimits.IsZero() {
+			return fmt.Errorf("resource ceilings are configured but cgroup v2 is unavailable: %w", err)
+		}
+		fmt.Fprintln(os.Stderr, "atomicd: cgroup v2 unavailable, runs will not be resource limited:", err)
+		cgroups = nil
+	}
+
 	ln, cleanup, err := listen(cfg.SocketPath)
 	if err != nil {
 		return err
 	}
 	defer cleanup()
 
-	srv := &Server{cfg: cfg}
+	srv := &Server{cfg: cfg, cgroups: cgroups, runs: newRunQueue(cfg.MaxConcurrent, cfg.MaxQueue)}
+	prepared, err := journal.ListPrepared(cfg.JournalDir)
+	if err != nil {
+		return fmt.Errorf("load prepared runs: %w", err)
+	}
+	for _, j := range prepared {
+		// Commits made before this restart are only caught by the ctime
+		// check.
+		srv.commits.Begin(j.RunID, j.TxnStart)
+	}
 	errCh := make(chan error, 1)
 	go func() {
 		<-ctx.Done()
@@ -109,7 +184,7 @@ func (s *Server) handleConn(ctx context.Context, conn net.Conn) {
 		return
 	}
 
-	runAsUID, runAsGID, err := peerCredentials(conn)
+	caller, err := peerCredentials(conn)
 	if err != nil {
 		_ = writer.WriteEvent(ipc.Event{Type: ipc.EventError, AtomicExitCode: exitcode.Unsupported, Message: fmt.Sprintf("failed to identify caller: %v", err)})
 		return
@@ -117,42 +192,41 @@ func (s *Server) handleConn(ctx context.Context, conn net.Conn) {
 
 	switch req.Type {
 	case ipc.RequestRecover:
-		result := engine.RecoverOnly(s.cfg.JournalDir, s.cfg.RootPrefix)
+		s.commitMu.Lock()
+		result := engine.RecoverOnly(s.cfg.JournalDir, s.cfg.StateDir, s.cfg.RootPrefix)
+		s.commitMu.Unlock()
 		_ = writer.WriteEvent(ipc.Event{Type: ipc.EventResult, AtomicExitCode: result.AtomicExitCode, Message: result.Message})
 		return
-	case ipc.RequestRun:
-		if !s.acquireRun() {
-			_ = writer.WriteEvent(ipc.Event{Type: ipc.EventError, AtomicExitCode: exitcode.Unsupported, Message: "atomicd is busy with another transaction"})
+	case ipc.RequestDiff:
+		owner, changes, err := engine.DescribeRun(s.cfg.WorkDir, req.RunID, s.cfg.RootPrefix)
+		if err != nil {
+			_ = writer.WriteEvent(ipc.Event{Type: ipc.EventError, AtomicExitCode: exitcode.Unsupported, Message: err.Error()})
 			return
 		}
-		defer s.releaseRun()
-		runID := fmt.Sprintf("%d-%d", time.Now().UTC().UnixNano(), os.Getpid())
-		_ = writer.WriteEvent(ipc.Event{Type: ipc.EventStart, RunID: runID})
-
-		stdoutWriter := &ipc.StreamEventWriter{Kind: ipc.EventStdout, RunID: runID, Sink: writer.WriteEvent}
-		stderrWriter := &ipc.StreamEventWriter{Kind: ipc.EventStderr, RunID: runID, Sink: writer.WriteEvent}
-
-		result := engine.Execute(ctx, engine.ExecuteRequest{
-			RunID:         runID,
-			StateDir:      s.cfg.StateDir,
-			WorkDir:       s.cfg.WorkDir,
-			JournalDir:    s.cfg.JournalDir,
-			RootPrefix:    s.cfg.RootPrefix,
-			ScriptPath:    req.ScriptPath,
-			ScriptArgs:    req.ScriptArgs,
-			CWD:           req.CWD,
-			RunAsUID:      runAsUID,
-			RunAsGID:      runAsGID,
-			KeepArtifacts: req.KeepArtifacts,
-			Verbose:       req.Verbose,
-			Stdout:        stdoutWriter,
-			Stderr:        stderrWriter,
-			Stdin:         nil,
-		})
-		if result.RunID == "" {
-			result.RunID = runID
+		if caller.UID != 0 && caller.UID != owner {
+			_ = writer.WriteEvent(ipc.Event{Type: ipc.EventError, AtomicExitCode: exitcode.Unsupported, Message: fmt.Sprintf("run %s belongs to another user", req.RunID)})
+			return
 		}
-		_ = writer.WriteEvent(ipc.Event{Type: ipc.EventResult, RunID: result.RunID, AtomicExitCode: result.AtomicExitCode, ScriptExitCode: result.ScriptExitCode, Message: result.Message})
+		_ = writer.WriteEvent(ipc.Event{Type: ipc.EventPlan, RunID: req.RunID, Changes: changes})
+		_ = writer.WriteEvent(ipc.Event{Type: ipc.EventResult, RunID: req.RunID, AtomicExitCode: exitcode.OK})
+		return
+	case ipc.RequestRun:
+		s.handleRun(ctx, caller, req, reader, writer)
+		return
+	case ipc.RequestAttach, ipc.RequestWait:
+		s.follow(ctx, caller, req, reader, writer)
+		return
+	case ipc.RequestCommit, ipc.RequestAbort:
+		s.finishPrepared(caller, req, writer)
+		return
+	case ipc.RequestApprove:
+		s.approve(caller, req, reader, writer)
+		return
+	case ipc.RequestList:
+		s.list(caller, req, writer)
+		return
+	case ipc.RequestUndo:
+		s.undo(caller, req, writer)
 		return
 	default:
 		_ = writer.WriteEvent(ipc.Event{Type: ipc.EventError, AtomicExitCode: exitcode.Unsupported, Message: fmt.Sprintf("unsupported request type %q", req.Type)})
@@ -160,20 +234,208 @@ func (s *Server) handleConn(ctx context.Context, conn net.Conn) {
 	}
 }
 
-func (s *Server) acquireRun() bool {
-	s.runMu.Lock()
-	defer s.runMu.Unlock()
-	if s.running {
-		return false
+func (s *Server) handleRun(ctx context.Context, caller credentials, req ipc.Request, reader *ipc.Reader, writer *ipc.Writer) {
+	if req.Network != "" && !overlay.ValidNetwork(req.Network) {
+		_ = writer.WriteEvent(ipc.Event{Type: ipc.EventError, AtomicExitCode: exitcode.Unsupported, Message: fmt.Sprintf("unsupported network mode %q (want host, none or loopback)", req.Network)})
+		return
+	}
+	if req.Prepare && req.DryRun {
+		_ = writer.WriteEvent(ipc.Event{Type: ipc.EventError, AtomicExitCode: exitcode.Unsupported, Message: "a run cannot be both prepared and a dry run"})
+		return
+	}
+	if req.DryRun && (len(req.PostCommit) > 0 || req.HealthCheck != "") {
+		_ = writer.WriteEvent(ipc.Event{Type: ipc.EventError, AtomicExitCode: exitcode.Unsupported, Message: "a dry run cannot have post-commit actions or a health check"})
+		return
+	}
+	for _, command := range req.PostCommit {
+		if strings.TrimSpace(command) == "" {
+			_ = writer.WriteEvent(ipc.Event{Type: ipc.EventError, AtomicExitCode: exitcode.Unsupported, Message: "post-commit actions must not be empty"})
+			return
+		}
+	}
+	if req.Review && len(req.Validators) > 0 {
+		_ = writer.WriteEvent(ipc.Event{Type: ipc.EventError, AtomicExitCode: exitcode.Unsupported, Message: "--review cannot be combined with --validate"})
+		return
+	}
+	if req.Detach && (req.TTY || req.Review) {
+		_ = writer.WriteEvent(ipc.Event{Type: ipc.EventError, AtomicExitCode: exitcode.Unsupported, Message: "detached runs cannot use a terminal or --review"})
+		return
+	}
+	runAs := account{UID: caller.UID, GID: caller.GID, Groups: caller.Groups}
+	var audit *ipc.RunAs
+	if req.RunAs != "" {
+		target, err := resolveRunAs(s.cfg.RunAs, caller, req.RunAs)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "atomicd: uid %d (pid %d) denied run as %q: %v\n", caller.UID, caller.PID, req.RunAs, err)
+			_ = writer.WriteEvent(ipc.Event{Type: ipc.EventError, AtomicExitCode: exitcode.Unsupported, Message: err.Error()})
+			return
+		}
+		if target.UID != caller.UID {
+			runAs = target
+			audit = &ipc.RunAs{User: target.Name, UID: target.UID, GID: target.GID, CallerUID: caller.UID, CallerPID: caller.PID}
+		}
+	}
+
+	locks, err := lockNames(req.Locks)
+	if err != nil {
+		_ = writer.WriteEvent(ipc.Event{Type: ipc.EventError, AtomicExitCode: exitcode.Unsupported, Message: err.Error()})
+		return
+	}
+	validators, err := runValidators(s.cfg.Validators, req.Validators)
+	if err != nil {
+		_ = writer.WriteEvent(ipc.Event{Type: ipc.EventError, AtomicExitCode: exitcode.Unsupported, Message: err.Error()})
+		return
+	}
+
+	runID := fmt.Sprintf("%d-%d", time.Now().UTC().UnixNano(), os.Getpid())
+	ticket, err := s.runs.acquire(runID, locks, !req.NoWait)
+	if err != nil {
+		_ = writer.WriteEvent(ipc.Event{Type: ipc.EventError, AtomicExitCode: exitcode.Busy, Message: err.Error()})
+		return
+	}
+	sess := newSession(runID, caller.UID, req.ScriptPath)
+	s.sessions.add(sess)
+
+	if req.Detach {
+		// The run belongs to the daemon from here on; the client only
+		// learns its ID.
+		_ = writer.WriteEvent(ipc.Event{Type: ipc.EventDetached, RunID: runID})
+		go func() {
+			if err := s.runs.wait(ctx, ticket, func(queueStatus) {}); err != nil {
+				sess.finish(ipc.Event{Type: ipc.EventResult, RunID: runID, AtomicExitCode: exitcode.Unsupported, Message: fmt.Sprintf("run abandoned: %v", err)})
+				return
+			}
+			defer s.runs.release(ticket)
+			s.execute(ctx, sess, req, runAs, audit, validators, nil, sess.publish)
+		}()
+		return
+	}
+
+	runCtx, cancelRun := context.WithCancelCause(ctx)
+	defer cancelRun(nil)
+	frames := readClientFrames(reader, writer, cancelRun)
+	err = s.runs.wait(runCtx, ticket, func(status queueStatus) {
+		_ = writer.WriteEvent(ipc.Event{Type: ipc.EventQueued, Position: status.Position, Message: status.Reason})
+	})
+	if err != nil {
+		sess.finish(ipc.Event{Type: ipc.EventResult, RunID: runID, AtomicExitCode: exitcode.ScriptFailed, Message: fmt.Sprintf("run aborted while queued: %v", err)})
+		return
+	}
+	defer s.runs.release(ticket)
+	_ = writer.WriteEvent(ipc.Event{Type: ipc.EventStart, RunID: runID})
+	emit := func(ev ipc.Event) error {
+		_ = sess.publish(ev)
+		return writer.WriteEvent(ev)
+	}
+	for _, ev := range s.execute(runCtx, sess, req, runAs, audit, validators, frames, emit) {
+		_ = writer.WriteEvent(ev)
+	}
+}
+
+// execute runs a request that holds its run slot, sending output to emit,
+// and records the closing events in sess. frames is nil for detached runs,
+// which get no stdin, signals or review.
+func (s *Server) execute(ctx context.Context, sess *session, req ipc.Request, runAs account, audit *ipc.RunAs, validators []engine.Validator, frames *clientFrames, emit func(ipc.Event) error) []ipc.Event {
+	runID := sess.runID
+	sess.start()
+	if audit != nil {
+		fmt.Fprintf(os.Stderr, "atomicd: run %s: uid %d (pid %d) runs %s as %s (uid %d)\n", runID, audit.CallerUID, audit.CallerPID, req.ScriptPath, audit.User, audit.UID)
+	}
+	var reviewFn func([]changeset.Change) ([]string, error)
+	var stdin io.Reader = strings.NewReader("")
+	var resizes <-chan overlay.WindowSize
+	var signals <-chan int
+	if frames != nil {
+		stdin, resizes, signals = frames.stdin, frames.resizes, frames.signals
+		if req.Review {
+			reviewFn = func(changes []changeset.Change) ([]string, error) {
+				return frames.review(emit, runID, changes)
+			}
+		}
+	}
+	pidNamespace := s.cfg.PIDNamespace
+	if req.PIDNamespace != nil {
+		pidNamespace = *req.PIDNamespace
 	}
-	s.running = true
-	return true
+	umask := uint32(defaultUmask)
+	if req.Umask != nil {
+		umask = *req.Umask & 0o777
+	}
+	network := s.cfg.Network
+	if req.Network != "" {
+		network = req.Network
+	}
+	var approval func([]journal.Operation) string
+	if len(s.cfg.Approval) > 0 {
+		approval = func(ops []journal.Operation) string { return approvalReason(s.cfg.Approval, ops) }
+	}
+	// The health window holds the commit lock, so it is always capped.
+	healthTimeout := req.HealthTimeout
+	if healthTimeout <= 0 {
+		healthTimeout = engine.DefaultHealthTimeout
+	}
+
+	result := engine.Execute(ctx, engine.ExecuteRequest{
+		RunID:         runID,
+		StateDir:      s.cfg.StateDir,
+		WorkDir:       s.cfg.WorkDir,
+		JournalDir:    s.cfg.JournalDir,
+		RootPrefix:    s.cfg.RootPrefix,
+		ScriptPath:    req.ScriptPath,
+		ScriptArgs:    req.ScriptArgs,
+		CWD:           req.CWD,
+		Env:           environ.Build(s.cfg.Env, req.Env, runAs.UID),
+		RunAsUID:      runAs.UID,
+		RunAsGID:      runAs.GID,
+		RunAsGroups:   runAs.Groups,
+		Umask:         umask,
+		KeepArtifacts: req.KeepArtifacts,
+		Verbose:       req.Verbose,
+		DryRun:        req.DryRun,
+		Prepare:       req.Prepare,
+		Owner:         sess.owner,
+		Approval:      approval,
+		Timeout:       capLimit(req.Timeout, time.Duration(s.cfg.MaxTimeout)),
+		IdleTimeout:   capLimit(req.IdleTimeout, time.Duration(s.cfg.MaxIdleTimeout)),
+		Cgroups:       s.cgroups,
+		Limits:        req.Limits.Cap(s.cfg.MaxLimits),
+		PIDNamespace:  pidNamespace,
+		Network:       network,
+		Validators:    validators,
+		PostCommit:    req.PostCommit,
+		HealthCheck:   req.HealthCheck,
+		HealthTimeout: capLimit(healthTimeout, time.Duration(s.cfg.MaxHealthTimeout)),
+		Review:        reviewFn,
+		CommitLock:    &s.commitMu,
+		Commits:       &s.commits,
+		Stdout:        &ipc.StreamEventWriter{Kind: ipc.EventStdout, RunID: runID, Sink: emit},
+		Stderr:        &ipc.StreamEventWriter{Kind: ipc.EventStderr, RunID: runID, Sink: emit},
+		Stdin:         stdin,
+		TTY:           req.TTY,
+		WindowSize:    overlay.WindowSize{Rows: req.Rows, Cols: req.Cols},
+		Resize:        resizes,
+		Signals:       signals,
+	})
+	if result.RunID == "" {
+		result.RunID = runID
+	}
+	var final []ipc.Event
+	if req.DryRun && result.AtomicExitCode == exitcode.OK {
+		final = append(final, ipc.Event{Type: ipc.EventPlan, RunID: result.RunID, Ops: result.Ops, Changes: result.Changes})
+	}
+	final = append(final, ipc.Event{Type: ipc.EventResult, RunID: result.RunID, AtomicExitCode: result.AtomicExitCode, ScriptExitCode: result.ScriptExitCode, ScriptSignal: result.ScriptSignal, Message: result.Message, Usage: result.Usage, RunAs: audit})
+	sess.finish(final...)
+	return final
 }
 
-func (s *Server) releaseRun() {
-	s.runMu.Lock()
-	s.running = false
-	s.runMu.Unlock()
+func capLimit(requested, max time.Duration) time.Duration {
+	if max > 0 && (requested <= 0 || requested > max) {
+		return max
+	}
+	if requested < 0 {
+		return 0
+	}
+	return requested
 }
 
 func applyDefaults(cfg *Config) {
@@ -189,6 +451,24 @@ func applyDefaults(cfg *Config) {
 	if cfg.JournalDir == "" {
 		cfg.JournalDir = engine.DefaultJournalDir
 	}
+	if cfg.Env.Allow == nil {
+		cfg.Env.Allow = environ.DefaultAllow
+	}
+	if cfg.Env.Deny == nil {
+		cfg.Env.Deny = environ.DefaultDeny
+	}
+	if cfg.Network == "" {
+		cfg.Network = overlay.NetworkHost
+	}
+	if cfg.MaxConcurrent == 0 {
+		cfg.MaxConcurrent = defaultMaxConcurrent
+	}
+	if cfg.MaxQueue == 0 {
+		cfg.MaxQueue = defaultMaxQueue
+	}
+	if cfg.MaxHealthTimeout <= 0 {
+		cfg.MaxHealthTimeout = defaultMaxHealthTimeout
+	}
 }
 
 func listen(socketPath string) (net.Listener, func(), error) {
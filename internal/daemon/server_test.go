@@ -1,18 +1,34 @@
 package daemon
 
-import "testing"
+import (
+	"testing"
+	"time"
+)
 
-func TestSingleActiveTransactionLock(t *testing.T) {
-	s := &Server{}
-	if !s.acquireRun() {
-		t.Fatalf("expected first acquire to succeed")
+func TestCapLimit(t *testing.T) {
+	cases := []struct{ requested, max, want time.Duration }{
+		{0, 0, 0},
+		{time.Minute, 0, time.Minute},
+		{0, time.Hour, time.Hour},
+		{2 * time.Hour, time.Hour, time.Hour},
+		{time.Minute, time.Hour, time.Minute},
 	}
-	if s.acquireRun() {
-		t.Fatalf("expected second acquire to fail while running")
+	for _, c := range cases {
+		if got := capLimit(c.requested, c.max); got != c.want {
+			t.Fatalf("capLimit(%s, %s) = %s, want %s", c.requested, c.max, got, c.want)
+		}
 	}
-	s.releaseRun()
-	if !s.acquireRun() {
-		t.Fatalf("expected acquire after release to succeed")
+}
+
+func TestApplyDefaultsCapsHealthWindow(t *testing.T) {
+	var cfg Config
+	applyDefaults(&cfg)
+	if cfg.MaxTimeout != 0 || time.Duration(cfg.MaxHealthTimeout) != 5*time.Minute {
+		t.Fatalf("unexpected defaults: max timeout %s, max health timeout %s", time.Duration(cfg.MaxTimeout), time.Duration(cfg.MaxHealthTimeout))
+	}
+	cfg.MaxHealthTimeout = Duration(time.Minute)
+	applyDefaults(&cfg)
+	if time.Duration(cfg.MaxHealthTimeout) != time.Minute {
+		t.Fatalf("configured max health timeout was replaced: %s", time.Duration(cfg.MaxHealthTimeout))
 	}
-	s.releaseRun()
 }
@@ -0,0 +1,224 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ShriKaranHanda/atomic/internal/exitcode"
+	"github.com/ShriKaranHanda/atomic/internal/ipc"
+)
+
+const (
+	// outputBufferSize bounds the output kept per run for attach; older
+	// output is dropped first.
+	outputBufferSize = 1 << 20
+	// Finished runs are kept for attach and wait this long, and at most
+	// this many of them.
+	finishedRunTTL  = 24 * time.Hour
+	maxFinishedRuns = 64
+)
+
+// session tracks a run by ID so it can be attached to and waited for, also
+// after the connection that started it is gone.
+type session struct {
+	runID   string
+	owner   uint32
+	script  string
+	created time.Time
+
+	mu       sync.Mutex
+	running  bool
+	output   []ipc.Event
+	first    int
+	size     int
+	changed  chan struct{}
+	final    []ipc.Event
+	finished time.Time
+}
+
+func newSession(runID string, owner uint32, script string) *session {
+	return &session{runID: runID, owner: owner, script: script, created: time.Now().UTC(), changed: make(chan struct{})}
+}
+
+// start marks the run as out of the queue.
+func (s *session) start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.running = true
+}
+
+func (s *session) info() ipc.RunInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	info := ipc.RunInfo{RunID: s.runID, State: "queued", Owner: s.owner, ScriptPath: s.script, Started: s.created}
+	switch {
+	case s.final != nil:
+		info.State = "finished"
+		info.ExitCode = s.final[len(s.final)-1].AtomicExitCode
+	case s.running:
+		info.State = "running"
+	}
+	return info
+}
+
+// publish buffers a stdout or stderr event; other events are not replayed.
+func (s *session) publish(ev ipc.Event) error {
+	if ev.Type != ipc.EventStdout && ev.Type != ipc.EventStderr {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.output = append(s.output, ev)
+	s.size += len(ev.DataB64)
+	for s.size > outputBufferSize && len(s.output) > 1 {
+		s.size -= len(s.output[0].DataB64)
+		s.output = s.output[1:]
+		s.first++
+	}
+	s.notifyLocked()
+	return nil
+}
+
+// finish records the run's closing events (a plan for dry runs, then the
+// result).
+func (s *session) finish(final ...ipc.Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.final = final
+	s.finished = time.Now()
+	s.notifyLocked()
+}
+
+func (s *session) notifyLocked() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// read returns the buffered output from sequence number from on (or from the
+// oldest output still buffered), the sequence number to read next, the
+// closing events once the run has finished, and a channel that is closed
+// when there is more to read.
+func (s *session) read(from int) ([]ipc.Event, int, []ipc.Event, <-chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if from < s.first {
+		from = s.first
+	}
+	events := append([]ipc.Event(nil), s.output[from-s.first:]...)
+	return events, s.first + len(s.output), s.final, s.changed
+}
+
+// sessionTable holds the daemon's active runs and its recently finished
+// ones.
+type sessionTable struct {
+	mu   sync.Mutex
+	byID map[string]*session
+}
+
+func (t *sessionTable) add(s *session) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.byID == nil {
+		t.byID = map[string]*session{}
+	}
+	t.pruneLocked()
+	t.byID[s.runID] = s
+}
+
+func (t *sessionTable) get(runID string) *session {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pruneLocked()
+	return t.byID[runID]
+}
+
+// list returns the runs in the table, oldest first.
+func (t *sessionTable) list() []*session {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pruneLocked()
+	out := make([]*session, 0, len(t.byID))
+	for _, s := range t.byID {
+		out = append(out, s)
+	}
+	sort.Slice(out, func(a, b int) bool { return out[a].created.Before(out[b].created) })
+	return out
+}
+
+func (t *sessionTable) pruneLocked() {
+	var finished []*session
+	for id, s := range t.byID {
+		s.mu.Lock()
+		done := s.finished
+		s.mu.Unlock()
+		if done.IsZero() {
+			continue
+		}
+		if time.Since(done) > finishedRunTTL {
+			delete(t.byID, id)
+			continue
+		}
+		finished = append(finished, s)
+	}
+	for len(finished) > maxFinishedRuns {
+		oldest := 0
+		for i, s := range finished {
+			if s.finished.Before(finished[oldest].finished) {
+				oldest = i
+			}
+		}
+		delete(t.byID, finished[oldest].runID)
+		finished = append(finished[:oldest], finished[oldest+1:]...)
+	}
+}
+
+// follow serves attach and wait requests: attach replays the buffered output
+// and streams the rest, wait only sends the closing events.
+func (s *Server) follow(ctx context.Context, caller credentials, req ipc.Request, reader *ipc.Reader, writer *ipc.Writer) {
+	sess := s.sessions.get(req.RunID)
+	if sess == nil {
+		_ = writer.WriteEvent(ipc.Event{Type: ipc.EventError, AtomicExitCode: exitcode.Unsupported, Message: fmt.Sprintf("run %s not found (atomicd keeps up to %d finished runs for %d hours)", req.RunID, maxFinishedRuns, int(finishedRunTTL.Hours()))})
+		return
+	}
+	if caller.UID != 0 && caller.UID != sess.owner {
+		_ = writer.WriteEvent(ipc.Event{Type: ipc.EventError, AtomicExitCode: exitcode.Unsupported, Message: fmt.Sprintf("run %s belongs to another user", req.RunID)})
+		return
+	}
+	// Leaving only ends the follower; the run goes on.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		for {
+			if _, err := reader.ReadFrame(); err != nil {
+				cancel()
+				return
+			}
+		}
+	}()
+	next := 0
+	for {
+		output, n, final, changed := sess.read(next)
+		next = n
+		if req.Type == ipc.RequestAttach {
+			for _, ev := range output {
+				if err := writer.WriteEvent(ev); err != nil {
+					return
+				}
+			}
+		}
+		if final != nil {
+			for _, ev := range final {
+				_ = writer.WriteEvent(ev)
+			}
+			return
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package daemon
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ShriKaranHanda/atomic/internal/ipc"
+)
+
+func TestSessionOutputBuffer(t *testing.T) {
+	s := newSession("run", 0, "/bin/true")
+	chunk := strings.Repeat("x", outputBufferSize/4)
+	for i := 0; i < 6; i++ {
+		_ = s.publish(ipc.Event{Type: ipc.EventStdout, DataB64: chunk})
+	}
+	_ = s.publish(ipc.Event{Type: ipc.EventReview})
+
+	output, next, final, changed := s.read(0)
+	if len(output) != 4 || next != 6 {
+		t.Fatalf("expected the newest 4 of 6 events and next 6, got %d and %d", len(output), next)
+	}
+	if final != nil {
+		t.Fatalf("unfinished session returned closing events")
+	}
+	if more, _, _, _ := s.read(next); len(more) != 0 {
+		t.Fatalf("read past the end returned %d events", len(more))
+	}
+
+	s.finish(ipc.Event{Type: ipc.EventResult, AtomicExitCode: 10})
+	select {
+	case <-changed:
+	default:
+		t.Fatalf("finish did not wake readers")
+	}
+	if _, _, final, _ := s.read(next); len(final) != 1 || final[0].AtomicExitCode != 10 {
+		t.Fatalf("unexpected closing events: %v", final)
+	}
+}
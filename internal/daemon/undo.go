@@ -0,0 +1,71 @@
+package daemon
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/ShriKaranHanda/atomic/internal/engine"
+	"github.com/ShriKaranHanda/atomic/internal/exitcode"
+	"github.com/ShriKaranHanda/atomic/internal/ipc"
+	"github.com/ShriKaranHanda/atomic/internal/journal"
+)
+
+// undo reverts a committed run for its owner or root. Only root may undo a
+// run that changed paths guarded by the approval policy, since the undo is
+// not held for approval.
+func (s *Server) undo(caller credentials, req ipc.Request, writer *ipc.Writer) {
+	j, err := engine.LoadCommitted(s.cfg.StateDir, req.RunID)
+	if err != nil {
+		_ = writer.WriteEvent(ipc.Event{Type: ipc.EventError, AtomicExitCode: exitcode.Unsupported, Message: err.Error()})
+		return
+	}
+	if caller.UID != 0 {
+		if caller.UID != j.Owner {
+			_ = writer.WriteEvent(ipc.Event{Type: ipc.EventError, AtomicExitCode: exitcode.Unsupported, Message: fmt.Sprintf("run %s belongs to another user", req.RunID)})
+			return
+		}
+		if reason := approvalReason(s.cfg.Approval, j.Ops); reason != "" {
+			_ = writer.WriteEvent(ipc.Event{Type: ipc.EventError, AtomicExitCode: exitcode.Unsupported, Message: fmt.Sprintf("only root can undo run %s: %s", req.RunID, reason)})
+			return
+		}
+	}
+	result := engine.Undo(engine.UndoRequest{
+		RunID:      req.RunID,
+		StateDir:   s.cfg.StateDir,
+		JournalDir: s.cfg.JournalDir,
+		RootPrefix: s.cfg.RootPrefix,
+		Owner:      caller.UID,
+		CommitLock: &s.commitMu,
+		Commits:    &s.commits,
+		Stdout:     &ipc.StreamEventWriter{Kind: ipc.EventStdout, RunID: req.RunID, Sink: writer.WriteEvent},
+		Stderr:     &ipc.StreamEventWriter{Kind: ipc.EventStderr, RunID: req.RunID, Sink: writer.WriteEvent},
+	})
+	fmt.Fprintf(os.Stderr, "atomicd: uid %d (pid %d) undid run %s of uid %d as run %s, exit %d\n", caller.UID, caller.PID, req.RunID, j.Owner, result.RunID, result.AtomicExitCode)
+	_ = writer.WriteEvent(ipc.Event{Type: ipc.EventResult, RunID: result.RunID, AtomicExitCode: result.AtomicExitCode, Message: result.Message})
+}
+
+// listHistory reports the committed runs the caller can undo, newest first;
+// root sees all of them.
+func (s *Server) listHistory(caller credentials, writer *ipc.Writer) {
+	committed, err := engine.ListCommitted(s.cfg.StateDir)
+	if err != nil {
+		_ = writer.WriteEvent(ipc.Event{Type: ipc.EventError, AtomicExitCode: exitcode.Unsupported, Message: err.Error()})
+		return
+	}
+	var runs []ipc.RunInfo
+	for _, j := range committed {
+		if caller.UID != 0 && caller.UID != j.Owner {
+			continue
+		}
+		runs = append(runs, ipc.RunInfo{RunID: j.RunID, State: journal.StateCommitted, Owner: j.Owner, ScriptPath: describeCommitted(j), Started: j.TxnStart, Changes: len(j.Ops)})
+	}
+	_ = writer.WriteEvent(ipc.Event{Type: ipc.EventRuns, Runs: runs})
+	_ = writer.WriteEvent(ipc.Event{Type: ipc.EventResult, AtomicExitCode: exitcode.OK})
+}
+
+func describeCommitted(j *journal.Journal) string {
+	if j.Undoes != "" {
+		return "atomic undo " + j.Undoes
+	}
+	return j.ScriptPath
+}
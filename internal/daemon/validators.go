@@ -0,0 +1,48 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/ShriKaranHanda/atomic/internal/engine"
+)
+
+// ValidatorRule is a command line (e.g. "nginx -t") that every run changing
+// something at or under one of Paths must pass before it is committed; with
+// no paths it applies to every run. It runs as root.
+type ValidatorRule struct {
+	Command string   `json:"command"`
+	Paths   []string `json:"paths,omitempty"`
+}
+
+func validateValidators(rules []ValidatorRule) error {
+	for _, rule := range rules {
+		if strings.TrimSpace(rule.Command) == "" {
+			return errors.New("validators need a command")
+		}
+		for _, path := range rule.Paths {
+			if !filepath.IsAbs(path) {
+				return fmt.Errorf("validator path %q is not absolute", path)
+			}
+		}
+	}
+	return nil
+}
+
+// runValidators lists the daemon's validators, which run as root, then the
+// request's, which run as the run-as user.
+func runValidators(rules []ValidatorRule, requested []string) ([]engine.Validator, error) {
+	out := make([]engine.Validator, 0, len(rules)+len(requested))
+	for _, rule := range rules {
+		out = append(out, engine.Validator{Command: rule.Command, Paths: rule.Paths, Root: true})
+	}
+	for _, command := range requested {
+		if strings.TrimSpace(command) == "" {
+			return nil, errors.New("validator commands must not be empty")
+		}
+		out = append(out, engine.Validator{Command: command})
+	}
+	return out, nil
+}
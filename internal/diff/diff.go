@@ -95,6 +95,30 @@ func ScanUpperDir(upperDir, mountPoint string) ([]journal.Operation, error) {
 	return ops, nil
 }
 
+// Exclude drops operations on roots or anything below them.
+func Exclude(ops []journal.Operation, roots []string) []journal.Operation {
+	out := make([]journal.Operation, 0, len(ops))
+	for _, op := range ops {
+		if !underAny(op.Path, roots) {
+			out = append(out, op)
+		}
+	}
+	return out
+}
+
+func underAny(path string, roots []string) bool {
+	for _, root := range roots {
+		root = filepath.Clean(root)
+		if root == "/" {
+			continue
+		}
+		if path == root || strings.HasPrefix(path, root+"/") {
+			return true
+		}
+	}
+	return false
+}
+
 func Plan(ops []journal.Operation) []journal.Operation {
 	ordered := make([]journal.Operation, len(ops))
 	copy(ordered, ops)
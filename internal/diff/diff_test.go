@@ -64,3 +64,17 @@ func TestPlanDeterministicOrder(t *testing.T) {
 		t.Fatalf("expected shallowest delete last, got %s", ordered[3].Path)
 	}
 }
+
+func TestExclude(t *testing.T) {
+	ops := []journal.Operation{
+		{Kind: journal.OperationUpsert, Path: "/dev/null", NodeType: journal.NodeFile},
+		{Kind: journal.OperationUpsert, Path: "/devices.txt", NodeType: journal.NodeFile},
+		{Kind: journal.OperationUpsert, Path: "/tmp", NodeType: journal.NodeDirectory},
+		{Kind: journal.OperationDelete, Path: "/etc/old.conf", NodeType: journal.NodeUnknown},
+	}
+
+	got := Exclude(ops, []string{"/dev", "/tmp/", "/"})
+	if len(got) != 2 || got[0].Path != "/devices.txt" || got[1].Path != "/etc/old.conf" {
+		t.Fatalf("unexpected ops after exclude: %#v", got)
+	}
+}
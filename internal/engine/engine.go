@@ -6,12 +6,17 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"sync"
+	"syscall"
 	"time"
 
+	"github.com/ShriKaranHanda/atomic/internal/cgroup"
+	"github.com/ShriKaranHanda/atomic/internal/changeset"
 	"github.com/ShriKaranHanda/atomic/internal/commit"
 	"github.com/ShriKaranHanda/atomic/internal/conflict"
 	"github.com/ShriKaranHanda/atomic/internal/diff"
 	"github.com/ShriKaranHanda/atomic/internal/exitcode"
+	"github.com/ShriKaranHanda/atomic/internal/history"
 	"github.com/ShriKaranHanda/atomic/internal/journal"
 	"github.com/ShriKaranHanda/atomic/internal/overlay"
 	"github.com/ShriKaranHanda/atomic/internal/preflight"
@@ -22,6 +27,8 @@ const (
 	DefaultStateDir   = "/var/lib/atomic"
 	DefaultWorkDir    = "/var/lib/atomic/runs"
 	DefaultJournalDir = "/var/lib/atomic/journal"
+	// MaxHistory is how many committed runs are kept for undo.
+	MaxHistory = 64
 )
 
 type ExecuteRequest struct {
@@ -34,44 +41,116 @@ type ExecuteRequest struct {
 	ScriptPath string
 	ScriptArgs []string
 	CWD        string
+	// Env is the complete script environment as KEY=VALUE pairs.
+	Env []string
 
-	RunAsUID uint32
-	RunAsGID uint32
+	RunAsUID    uint32
+	RunAsGID    uint32
+	RunAsGroups []uint32
+	// Umask is applied to the script as is; zero means no mask.
+	Umask uint32
 
 	KeepArtifacts bool
 	Verbose       bool
+	DryRun        bool
+	// Prepare stops before the commit and saves the planned changes as a
+	// prepared journal for CommitPrepared or AbortPrepared. Owner is the UID
+	// recorded as having started the run, and allowed to inspect or finish it.
+	Prepare bool
+	Owner   uint32
+	// Approval, when set, says why the planned changes need a second user's
+	// approval, or returns "". Such runs are saved like prepared runs and
+	// end with exitcode.Pending.
+	Approval func([]journal.Operation) string
+
+	// Timeout bounds the script's run time and IdleTimeout the time it may
+	// go without writing output. Zero disables either limit.
+	Timeout     time.Duration
+	IdleTimeout time.Duration
+
+	// Cgroups, when set, runs the script in its own cgroup with Limits
+	// applied. Without it any non-zero limit is refused.
+	Cgroups *cgroup.Manager
+	Limits  cgroup.Limits
+
+	PIDNamespace bool
+	// Network is an overlay network mode; empty shares the host network.
+	Network string
+
+	// Validators run after the script succeeds, over a read-only view of its
+	// changes; the first to fail ends the run with exitcode.ValidationFailed.
+	Validators []Validator
+
+	// PostCommit actions and then HealthCheck run on the host after the
+	// commit, as the run-as user, within HealthTimeout (DefaultHealthTimeout
+	// if zero). If one fails the commit is reverted and the run ends with
+	// exitcode.Reverted. The commit lock is held meanwhile.
+	PostCommit    []string
+	HealthCheck   string
+	HealthTimeout time.Duration
+
+	// Review, when set, is called with the planned changes before anything is
+	// committed and returns the paths to commit. An empty selection rejects
+	// the whole run.
+	Review func([]changeset.Change) ([]string, error)
+
+	// CommitLock, when set, is held around recovery and from the conflict
+	// checks to the end of the commit, so concurrent runs commit one at a
+	// time. Commits records each commit and is checked by later ones.
+	CommitLock sync.Locker
+	Commits    *conflict.Log
 
 	Stdout io.Writer
 	Stderr io.Writer
-	Stdin  io.Reader
+	// Stdin is closed once the script exits if it implements io.Closer.
+	Stdin io.Reader
+
+	TTY        bool
+	WindowSize overlay.WindowSize
+	Resize     <-chan overlay.WindowSize
+	Signals    <-chan int
 }
 
 type ExecuteResult struct {
 	RunID          string
 	AtomicExitCode int
 	ScriptExitCode int
+	ScriptSignal   int
 	Message        string
+	Usage          *cgroup.Usage
+	Ops            []journal.Operation
+	Changes        []changeset.Change
 }
 
-func RecoverOnly(journalDir string, rootPrefix string) ExecuteResult {
+func RecoverOnly(journalDir, stateDir, rootPrefix string) ExecuteResult {
 	if journalDir == "" {
 		journalDir = DefaultJournalDir
 	}
+	if stateDir == "" {
+		stateDir = DefaultStateDir
+	}
 	if err := preflight.CheckDaemon(); err != nil {
 		return ExecuteResult{AtomicExitCode: exitcode.Unsupported, Message: fmt.Sprintf("preflight failed: %v", err)}
 	}
-	if err := recover.Run(journalDir, rootPrefix); err != nil {
+	if err := recover.Run(journalDir, rootPrefix, settleRecovered(stateDir, rootPrefix)); err != nil {
 		return ExecuteResult{AtomicExitCode: exitcode.RecoveryFailure, Message: fmt.Sprintf("recovery failed: %v", err)}
 	}
 	return ExecuteResult{AtomicExitCode: exitcode.OK}
 }
 
-func Execute(ctx context.Context, req ExecuteRequest) ExecuteResult {
+func Execute(ctx context.Context, req ExecuteRequest) (result ExecuteResult) {
+	held := false
 	applyDefaults(&req)
 	if err := preflight.CheckDaemon(); err != nil {
 		return ExecuteResult{RunID: req.RunID, AtomicExitCode: exitcode.Unsupported, Message: fmt.Sprintf("preflight failed: %v", err)}
 	}
-	if err := recover.Run(req.JournalDir, req.RootPrefix); err != nil {
+	if req.CommitLock == nil {
+		req.CommitLock = noLock{}
+	}
+	req.CommitLock.Lock()
+	err := recover.Run(req.JournalDir, req.RootPrefix, settleRecovered(req.StateDir, req.RootPrefix))
+	req.CommitLock.Unlock()
+	if err != nil {
 		return ExecuteResult{RunID: req.RunID, AtomicExitCode: exitcode.RecoveryFailure, Message: fmt.Sprintf("recovery failed: %v", err)}
 	}
 	if req.ScriptPath == "" {
@@ -81,20 +160,81 @@ func Execute(ctx context.Context, req ExecuteRequest) ExecuteResult {
 		req.CWD = filepath.Dir(req.ScriptPath)
 	}
 
+	var group *cgroup.Group
+	if req.Cgroups != nil {
+		var err error
+		group, err = req.Cgroups.Create(req.RunID, req.Limits)
+		if err != nil {
+			return ExecuteResult{RunID: req.RunID, AtomicExitCode: exitcode.Unsupported, Message: fmt.Sprintf("resource limits: %v", err)}
+		}
+		defer group.Remove()
+	} else if !req.Limits.IsZero() {
+		return ExecuteResult{RunID: req.RunID, AtomicExitCode: exitcode.Unsupported, Message: "resource limits require cgroup v2, which atomicd could not set up"}
+	}
+
 	txnStart := time.Now().UTC()
-	res, err := overlay.RunScript(ctx, overlay.RunConfig{
-		RunID:      req.RunID,
-		WorkRoot:   req.WorkDir,
-		ScriptPath: req.ScriptPath,
-		ScriptArgs: req.ScriptArgs,
-		CWD:        req.CWD,
-		RunAsUID:   req.RunAsUID,
-		RunAsGID:   req.RunAsGID,
-		Verbose:    req.Verbose,
-		Stdout:     req.Stdout,
-		Stderr:     req.Stderr,
-		Stdin:      req.Stdin,
+	if req.Commits != nil {
+		// A prepared run stays registered until it is committed or aborted.
+		req.Commits.Begin(req.RunID, txnStart)
+		defer func() {
+			if !held {
+				req.Commits.End(req.RunID)
+			}
+		}()
+	}
+	scriptCtx, stdout, stderr, stopTimeouts := withTimeouts(ctx, req.Timeout, req.IdleTimeout, req.Stdout, req.Stderr)
+	res, err := overlay.RunScript(scriptCtx, overlay.RunConfig{
+		RunID:        req.RunID,
+		WorkRoot:     req.WorkDir,
+		ScriptPath:   req.ScriptPath,
+		ScriptArgs:   req.ScriptArgs,
+		CWD:          req.CWD,
+		Env:          req.Env,
+		RunAsUID:     req.RunAsUID,
+		RunAsGID:     req.RunAsGID,
+		RunAsGroups:  req.RunAsGroups,
+		Owner:        req.Owner,
+		Umask:        req.Umask,
+		Verbose:      req.Verbose,
+		Stdout:       stdout,
+		Stderr:       stderr,
+		Stdin:        req.Stdin,
+		TTY:          req.TTY,
+		WindowSize:   req.WindowSize,
+		Resize:       req.Resize,
+		Signals:      req.Signals,
+		Cgroup:       group,
+		PIDNamespace: req.PIDNamespace,
+		Network:      req.Network,
 	})
+	timedOut := scriptCtx.Err() != nil && ctx.Err() == nil
+	stopTimeouts()
+	if group != nil {
+		// Nothing the script left behind may keep writing to the upperdirs
+		// while they are scanned.
+		if killErr := group.Kill(); killErr != nil && err == nil {
+			err = killErr
+		}
+		usage := group.Usage()
+		defer func() { result.Usage = &usage }()
+	}
+	if closer, ok := req.Stdin.(io.Closer); ok {
+		_ = closer.Close()
+	}
+	if timedOut {
+		if res != nil {
+			_ = os.RemoveAll(res.RunDir)
+		}
+		return ExecuteResult{RunID: req.RunID, AtomicExitCode: exitcode.Timeout, Message: fmt.Sprintf("%v; the script was killed and nothing was committed", context.Cause(scriptCtx))}
+	}
+	if ctx.Err() != nil {
+		// A cancelled run never commits and leaves nothing behind, even
+		// with KeepArtifacts.
+		if res != nil {
+			_ = os.RemoveAll(res.RunDir)
+		}
+		return aborted(ctx, req.RunID)
+	}
 	if err != nil {
 		return ExecuteResult{RunID: req.RunID, AtomicExitCode: exitcode.Unsupported, Message: fmt.Sprintf("overlay run failed: %v", err)}
 	}
@@ -102,48 +242,235 @@ func Execute(ctx context.Context, req ExecuteRequest) ExecuteResult {
 		if !req.KeepArtifacts {
 			_ = os.RemoveAll(res.RunDir)
 		}
-		return ExecuteResult{RunID: req.RunID, AtomicExitCode: exitcode.ScriptFailed, ScriptExitCode: res.ExitCode, Message: "script failed"}
+		msg := "script failed"
+		if res.Signal != 0 {
+			msg = fmt.Sprintf("script terminated by signal %d", res.Signal)
+		}
+		return ExecuteResult{RunID: req.RunID, AtomicExitCode: exitcode.ScriptFailed, ScriptExitCode: res.ExitCode, ScriptSignal: res.Signal, Message: msg}
 	}
 
-	ops := make([]journal.Operation, 0)
-	for _, mount := range res.UpperDirs {
-		scanned, err := diff.ScanUpperDir(mount.UpperDir, mount.MountPoint)
-		if err != nil {
-			return ExecuteResult{RunID: req.RunID, AtomicExitCode: exitcode.Unsupported, Message: fmt.Sprintf("scan diff failed: %v", err)}
+	ops, err := planChanges(res.UpperDirs, res.Excluded)
+	if err != nil {
+		return ExecuteResult{RunID: req.RunID, AtomicExitCode: exitcode.Unsupported, Message: fmt.Sprintf("scan diff failed: %v", err)}
+	}
+	if req.Review != nil && len(applicableValidators(req.Validators, ops)) > 0 {
+		// Validators see every change in the run, so a review could commit
+		// a selection that was never validated.
+		if !req.KeepArtifacts {
+			_ = os.RemoveAll(res.RunDir)
 		}
-		ops = append(ops, scanned...)
+		return ExecuteResult{RunID: req.RunID, AtomicExitCode: exitcode.Unsupported, Message: "validators apply to these changes, so they cannot be reviewed; nothing committed, run again without --review"}
 	}
-	ops = diff.Plan(ops)
-	ops, err = conflict.AttachBaselines(ops)
-	if err != nil {
-		return ExecuteResult{RunID: req.RunID, AtomicExitCode: exitcode.Unsupported, Message: fmt.Sprintf("baseline collection failed: %v", err)}
+	if failed := validate(ctx, req, res.RunDir, group, ops); failed != nil {
+		if !req.KeepArtifacts || ctx.Err() != nil || failed.AtomicExitCode == exitcode.Timeout {
+			_ = os.RemoveAll(res.RunDir)
+		}
+		return *failed
+	}
+	// The changes are shown to the run's user, so files it may not read are
+	// only described.
+	reader := &syscall.Credential{Uid: req.RunAsUID, Gid: req.RunAsGID, Groups: req.RunAsGroups}
+	if req.DryRun {
+		changes, err := changeset.Describe(ops, req.RootPrefix, reader)
+		if !req.KeepArtifacts {
+			_ = os.RemoveAll(res.RunDir)
+		}
+		if err != nil {
+			return ExecuteResult{RunID: req.RunID, AtomicExitCode: exitcode.Unsupported, Message: fmt.Sprintf("describe changes failed: %v", err)}
+		}
+		return ExecuteResult{RunID: req.RunID, AtomicExitCode: exitcode.OK, Message: fmt.Sprintf("dry run: %d planned operations, nothing committed", len(ops)), Ops: ops, Changes: changes}
 	}
-	if err := conflict.Check(ops, txnStart, nil); err != nil {
-		return ExecuteResult{RunID: req.RunID, AtomicExitCode: exitcode.Conflict, Message: fmt.Sprintf("conflict detected: %v", err)}
+	if req.Review != nil && len(ops) > 0 {
+		ops, err = review(req.Review, ops, req.RootPrefix, reader)
+		if ctx.Err() != nil {
+			_ = os.RemoveAll(res.RunDir)
+			return aborted(ctx, req.RunID)
+		}
+		if err != nil || len(ops) == 0 {
+			if !req.KeepArtifacts {
+				_ = os.RemoveAll(res.RunDir)
+			}
+			if err != nil {
+				return ExecuteResult{RunID: req.RunID, AtomicExitCode: exitcode.Rejected, Message: fmt.Sprintf("review aborted: %v", err)}
+			}
+			return ExecuteResult{RunID: req.RunID, AtomicExitCode: exitcode.Rejected, Message: "changes rejected during review, nothing committed"}
+		}
 	}
-
-	backupDir := filepath.Join(req.StateDir, "backups", req.RunID)
 	j := &journal.Journal{
 		RunID:         req.RunID,
-		State:         journal.StateCommitting,
 		Ops:           ops,
 		AppliedIndex:  -1,
-		BackupRefs:    map[string]journal.BackupRef{},
 		StartedAt:     txnStart,
 		TxnStart:      txnStart,
 		RunDir:        res.RunDir,
-		BackupDir:     backupDir,
 		KeepArtifacts: req.KeepArtifacts,
+		Owner:         req.Owner,
+		ScriptPath:    req.ScriptPath,
+	}
+	if len(req.PostCommit) > 0 || req.HealthCheck != "" {
+		j.Hooks = &journal.Hooks{
+			PostCommit:    req.PostCommit,
+			HealthCheck:   req.HealthCheck,
+			HealthTimeout: req.HealthTimeout,
+			UID:           req.RunAsUID,
+			GID:           req.RunAsGID,
+			Groups:        req.RunAsGroups,
+			Env:           req.Env,
+			CWD:           req.CWD,
+		}
+		if j.Hooks.HealthTimeout <= 0 {
+			j.Hooks.HealthTimeout = DefaultHealthTimeout
+		}
 	}
 	journalPath := filepath.Join(req.JournalDir, req.RunID+".json")
-	eng := commit.Engine{RootPrefix: req.RootPrefix}
-	if err := eng.Apply(journalPath, j); err != nil {
-		return ExecuteResult{RunID: req.RunID, AtomicExitCode: exitcode.RecoveryFailure, Message: fmt.Sprintf("commit failed: %v", err)}
+	approval := ""
+	if req.Approval != nil {
+		approval = req.Approval(ops)
+	}
+	if req.Prepare || approval != "" {
+		j.State = journal.StatePrepared
+		j.Prepared = &journal.Prepared{Owner: req.Owner, ScriptPath: req.ScriptPath, ScriptArgs: req.ScriptArgs, At: time.Now().UTC()}
+		if approval != "" {
+			j.Prepared.Approval = &journal.Approval{Reason: approval}
+		}
+		if err := journal.Save(journalPath, j); err != nil {
+			_ = os.RemoveAll(res.RunDir)
+			return ExecuteResult{RunID: req.RunID, AtomicExitCode: exitcode.Unsupported, Message: fmt.Sprintf("save prepared run: %v", err)}
+		}
+		held = true
+		if approval != "" {
+			return ExecuteResult{RunID: req.RunID, AtomicExitCode: exitcode.Pending, Message: fmt.Sprintf("%d operations need approval (%s); another user can approve them with atomic approve %s", len(ops), approval, req.RunID), Ops: ops}
+		}
+		return ExecuteResult{RunID: req.RunID, AtomicExitCode: exitcode.OK, Message: fmt.Sprintf("prepared %d operations; commit them with atomic commit %s or discard them with atomic abort %s", len(ops), req.RunID, req.RunID), Ops: ops}
+	}
+
+	req.CommitLock.Lock()
+	defer req.CommitLock.Unlock()
+	if ctx.Err() != nil {
+		_ = os.RemoveAll(res.RunDir)
+		return aborted(ctx, req.RunID)
+	}
+	return commitJournal(journalPath, j, req.StateDir, req.RootPrefix, req.Commits, req.Stdout, req.Stderr)
+}
+
+// commitJournal checks j's operations for conflicts, applies them and runs
+// its hooks, which write to stdout and stderr. The caller holds the commit
+// lock. Nothing is written if a check fails.
+func commitJournal(journalPath string, j *journal.Journal, stateDir, rootPrefix string, commits *conflict.Log, stdout, stderr io.Writer) ExecuteResult {
+	if commits != nil {
+		if err := conflict.CheckCommitted(j.Ops, j.TxnStart, commits.Since(j.TxnStart)); err != nil {
+			return ExecuteResult{RunID: j.RunID, AtomicExitCode: exitcode.Conflict, Message: fmt.Sprintf("conflict detected: %v", err)}
+		}
+	}
+	ops, err := conflict.AttachBaselines(j.Ops)
+	if err != nil {
+		return ExecuteResult{RunID: j.RunID, AtomicExitCode: exitcode.Unsupported, Message: fmt.Sprintf("baseline collection failed: %v", err)}
+	}
+	if err := conflict.Check(ops, j.TxnStart, nil); err != nil {
+		return ExecuteResult{RunID: j.RunID, AtomicExitCode: exitcode.Conflict, Message: fmt.Sprintf("conflict detected: %v", err)}
+	}
+
+	j.State = journal.StateCommitting
+	j.Ops = ops
+	j.AppliedIndex = -1
+	j.BackupRefs = map[string]journal.BackupRef{}
+	j.BackupDir = filepath.Join(stateDir, "backups", j.RunID)
+	eng := commit.Engine{RootPrefix: rootPrefix}
+	applyErr := eng.Apply(journalPath, j)
+	if commits != nil {
+		// A failed commit may have changed paths before it was rolled back.
+		commits.Add(conflict.Committed{RunID: j.RunID, At: time.Now().UTC(), Paths: opPaths(ops)})
+	}
+	if applyErr != nil {
+		return ExecuteResult{RunID: j.RunID, AtomicExitCode: exitcode.RecoveryFailure, Message: fmt.Sprintf("commit failed: %v", applyErr)}
+	}
+	if j.Hooks != nil {
+		if failed := verifyCommit(journalPath, j, eng, commits, stdout, stderr); failed != nil {
+			return *failed
+		}
+	}
+	if err := record(journalPath, j, stateDir); err != nil {
+		return ExecuteResult{RunID: j.RunID, AtomicExitCode: exitcode.RecoveryFailure, Message: fmt.Sprintf("cleanup failed: %v", err)}
+	}
+	return ExecuteResult{RunID: j.RunID, AtomicExitCode: exitcode.OK}
+}
+
+// DescribeRun renders the changeset of a run whose workspace was kept with
+// KeepArtifacts. It also returns the UID recorded as having started the run
+// so callers can restrict access to its owner.
+func DescribeRun(workDir, runID, rootPrefix string) (uint32, []changeset.Change, error) {
+	if workDir == "" {
+		workDir = DefaultWorkDir
+	}
+	if err := checkRunID(runID); err != nil {
+		return 0, nil, err
+	}
+	spec, err := overlay.LoadSpec(filepath.Join(workDir, runID, overlay.SpecFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil, fmt.Errorf("run %s not found (only prepared runs and runs kept with --keep-artifacts can be inspected)", runID)
+		}
+		return 0, nil, fmt.Errorf("load run %s: %w", runID, err)
+	}
+	upperDirs := []overlay.MountSpec{{MountPoint: "/", LowerDir: spec.RootLowerDir, UpperDir: spec.RootUpperDir, WorkDir: spec.RootWorkDir}}
+	upperDirs = append(upperDirs, spec.ExtraMounts...)
+	ops, err := planChanges(upperDirs, spec.Excluded)
+	if err != nil {
+		return 0, nil, fmt.Errorf("scan diff failed: %w", err)
+	}
+	reader := &syscall.Credential{Uid: spec.RunAsUID, Gid: spec.RunAsGID, Groups: spec.RunAsGroups}
+	changes, err := changeset.Describe(ops, rootPrefix, reader)
+	if err != nil {
+		return 0, nil, err
+	}
+	return spec.Owner, changes, nil
+}
+
+func checkRunID(runID string) error {
+	if runID == "" || runID == "." || runID == ".." || filepath.Base(runID) != runID {
+		return fmt.Errorf("invalid run id %q", runID)
+	}
+	return nil
+}
+
+func aborted(ctx context.Context, runID string) ExecuteResult {
+	return ExecuteResult{RunID: runID, AtomicExitCode: exitcode.ScriptFailed, Message: fmt.Sprintf("run aborted, nothing committed: %v", context.Cause(ctx))}
+}
+
+func review(fn func([]changeset.Change) ([]string, error), ops []journal.Operation, rootPrefix string, reader *syscall.Credential) ([]journal.Operation, error) {
+	changes, err := changeset.Describe(ops, rootPrefix, reader)
+	if err != nil {
+		return nil, fmt.Errorf("describe changes: %w", err)
+	}
+	selected, err := fn(changes)
+	if err != nil {
+		return nil, err
 	}
-	if err := finalize(journalPath, j); err != nil {
-		return ExecuteResult{RunID: req.RunID, AtomicExitCode: exitcode.RecoveryFailure, Message: fmt.Sprintf("cleanup failed: %v", err)}
+	keep := make(map[string]bool, len(selected))
+	for _, path := range selected {
+		keep[path] = true
 	}
-	return ExecuteResult{RunID: req.RunID, AtomicExitCode: exitcode.OK}
+	out := make([]journal.Operation, 0, len(selected))
+	for _, op := range ops {
+		if keep[op.Path] {
+			out = append(out, op)
+		}
+	}
+	return out, nil
+}
+
+// planChanges scans the upperdirs, dropping anything under the run's
+// excluded paths (sandbox and host pseudo-filesystem mount points).
+func planChanges(upperDirs []overlay.MountSpec, excluded []string) ([]journal.Operation, error) {
+	ops := make([]journal.Operation, 0)
+	for _, mount := range upperDirs {
+		scanned, err := diff.ScanUpperDir(mount.UpperDir, mount.MountPoint)
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, scanned...)
+	}
+	return diff.Plan(diff.Exclude(ops, excluded)), nil
 }
 
 func applyDefaults(req *ExecuteRequest) {
@@ -168,6 +495,46 @@ func applyDefaults(req *ExecuteRequest) {
 	}
 }
 
+func opPaths(ops []journal.Operation) []string {
+	paths := make([]string, 0, len(ops))
+	for _, op := range ops {
+		paths = append(paths, op.Path)
+	}
+	return paths
+}
+
+type noLock struct{}
+
+func (noLock) Lock()   {}
+func (noLock) Unlock() {}
+
+// record keeps a committed journal and its backups in the history store
+// for undo, along with the state the commit left each path in.
+func record(journalPath string, j *journal.Journal, stateDir string) error {
+	j.State = journal.StateCommitted
+	j.CommittedAt = time.Now().UTC()
+	j.Committed = make(map[string]journal.Baseline, len(j.Ops))
+	for _, op := range j.Ops {
+		baseline, err := conflict.BaselineForPath(op.Path)
+		if err != nil {
+			return err
+		}
+		j.Committed[op.Path] = baseline
+	}
+	if !j.KeepArtifacts && j.RunDir != "" {
+		if err := os.RemoveAll(j.RunDir); err != nil {
+			return err
+		}
+	}
+	if err := history.Add(filepath.Join(stateDir, "history"), j, MaxHistory); err != nil {
+		return err
+	}
+	if err := os.Remove(journalPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
 func finalize(journalPath string, j *journal.Journal) error {
 	if !j.KeepArtifacts {
 		if err := os.RemoveAll(j.RunDir); err != nil {
@@ -0,0 +1,156 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/ShriKaranHanda/atomic/internal/commit"
+	"github.com/ShriKaranHanda/atomic/internal/conflict"
+	"github.com/ShriKaranHanda/atomic/internal/exitcode"
+	"github.com/ShriKaranHanda/atomic/internal/journal"
+)
+
+const (
+	// DefaultHealthTimeout bounds the post-commit actions and health check
+	// of a run that does not set its own deadline.
+	DefaultHealthTimeout = time.Minute
+	healthCheckInterval  = time.Second
+	bashPath             = "/bin/bash"
+)
+
+// verifyCommit runs the hooks of j, whose operations were just applied, and
+// reverts the commit from its backups if a post-commit action or the health
+// check fails. It returns nil if the commit stands.
+func verifyCommit(journalPath string, j *journal.Journal, eng commit.Engine, commits *conflict.Log, stdout, stderr io.Writer) *ExecuteResult {
+	j.State = journal.StateVerifying
+	if err := journal.Save(journalPath, j); err != nil {
+		return &ExecuteResult{RunID: j.RunID, AtomicExitCode: exitcode.RecoveryFailure, Message: fmt.Sprintf("committed, but the health window could not be recorded: %v", err)}
+	}
+	failure := runHooks(j.Hooks, stdout, stderr)
+	if failure == "" {
+		return nil
+	}
+	revertErr := eng.Rollback(journalPath, j)
+	if commits != nil {
+		commits.Add(conflict.Committed{RunID: j.RunID, At: time.Now().UTC(), Paths: opPaths(j.Ops)})
+	}
+	if revertErr != nil {
+		return &ExecuteResult{RunID: j.RunID, AtomicExitCode: exitcode.RecoveryFailure, Message: fmt.Sprintf("%s, and reverting the commit failed: %v; atomic recover retries the revert", failure, revertErr)}
+	}
+	msg := failure + "; the changes were reverted"
+	// The post-commit actions run again so that services pick up the
+	// restored files.
+	if len(j.Hooks.PostCommit) > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), j.Hooks.HealthTimeout)
+		again := runPostCommit(ctx, j.Hooks, stdout, stderr)
+		cancel()
+		if again != "" {
+			msg += "; after the revert " + again
+		}
+	}
+	if err := finalize(journalPath, j); err != nil {
+		return &ExecuteResult{RunID: j.RunID, AtomicExitCode: exitcode.RecoveryFailure, Message: fmt.Sprintf("%s; cleanup failed: %v", msg, err)}
+	}
+	return &ExecuteResult{RunID: j.RunID, AtomicExitCode: exitcode.Reverted, Message: msg}
+}
+
+// settleRecovered returns the recovery step for a commit whose operations
+// were all applied before the daemon stopped: its hooks run again, with
+// their output in the daemon's log, and it is recorded for undo if they
+// pass or reverted if not.
+func settleRecovered(stateDir, rootPrefix string) func(string, *journal.Journal) error {
+	return func(journalPath string, j *journal.Journal) error {
+		if j.Hooks != nil {
+			failed := verifyCommit(journalPath, j, commit.Engine{RootPrefix: rootPrefix}, nil, os.Stderr, os.Stderr)
+			switch {
+			case failed == nil:
+			case failed.AtomicExitCode == exitcode.Reverted:
+				fmt.Fprintf(os.Stderr, "recovered run %s: %s\n", j.RunID, failed.Message)
+				return nil
+			default:
+				return fmt.Errorf("run %s: %s", j.RunID, failed.Message)
+			}
+		}
+		if err := record(journalPath, j, stateDir); err != nil {
+			return fmt.Errorf("record commit of run %s: %w", j.RunID, err)
+		}
+		return nil
+	}
+}
+
+// runHooks runs the post-commit actions, then the health check until it
+// passes, within h.HealthTimeout. It describes the first failure, or
+// returns "".
+func runHooks(h *journal.Hooks, stdout, stderr io.Writer) string {
+	ctx, cancel := context.WithTimeout(context.Background(), h.HealthTimeout)
+	defer cancel()
+	if failure := runPostCommit(ctx, h, stdout, stderr); failure != "" {
+		return failure
+	}
+	if h.HealthCheck == "" {
+		return ""
+	}
+	for {
+		if status, err := runHook(ctx, h, h.HealthCheck, stdout, stderr); err == nil && status == 0 {
+			return ""
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Sprintf("health check %q did not pass within %v", h.HealthCheck, h.HealthTimeout)
+		case <-time.After(healthCheckInterval):
+		}
+	}
+}
+
+func runPostCommit(ctx context.Context, h *journal.Hooks, stdout, stderr io.Writer) string {
+	for _, command := range h.PostCommit {
+		status, err := runHook(ctx, h, command, stdout, stderr)
+		switch {
+		case ctx.Err() != nil:
+			return fmt.Sprintf("post-commit action %q did not finish within %v", command, h.HealthTimeout)
+		case err != nil:
+			return fmt.Sprintf("post-commit action %q failed to run: %v", command, err)
+		case status != 0:
+			return fmt.Sprintf("post-commit action %q failed with exit status %d", command, status)
+		}
+	}
+	return ""
+}
+
+// runHook runs command with bash -c on the host as the run's user. It gets
+// its own process group, which is killed if ctx ends first; processes it
+// leaves running on success (a restarted service) are left alone.
+func runHook(ctx context.Context, h *journal.Hooks, command string, stdout, stderr io.Writer) (int, error) {
+	cmd := exec.CommandContext(ctx, bashPath, "-c", command)
+	cmd.Env = h.Env
+	cmd.Dir = h.CWD
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setpgid:    true,
+		Credential: &syscall.Credential{Uid: h.UID, Gid: h.GID, Groups: h.Groups},
+	}
+	cmd.Cancel = func() error { return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL) }
+	// A background process it started may keep the output open.
+	cmd.WaitDelay = time.Second
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return 128 + int(status.Signal()), nil
+		}
+		return exitErr.ExitCode(), nil
+	case errors.Is(err, exec.ErrWaitDelay):
+		return 0, nil
+	case err != nil:
+		return 0, err
+	}
+	return 0, nil
+}
@@ -0,0 +1,125 @@
+package engine
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ShriKaranHanda/atomic/internal/commit"
+	"github.com/ShriKaranHanda/atomic/internal/exitcode"
+	"github.com/ShriKaranHanda/atomic/internal/journal"
+)
+
+// hookedRun sets up a run under tmp that changes host/app.conf and creates
+// host/extra.conf, with hooks that run healthCheck.
+func hookedRun(t *testing.T, tmp, healthCheck string) *journal.Journal {
+	t.Helper()
+	if os.Geteuid() != 0 {
+		t.Skip("hooks run with the run's credentials, which needs root")
+	}
+	host := filepath.Join(tmp, "host")
+	upper := filepath.Join(tmp, "upper")
+	for path, content := range map[string]string{
+		filepath.Join(host, "app.conf"):    "old",
+		filepath.Join(upper, "app.conf"):   "new",
+		filepath.Join(upper, "extra.conf"): "new",
+	} {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("write %s: %v", path, err)
+		}
+	}
+	return &journal.Journal{
+		RunID:        "1-1",
+		AppliedIndex: -1,
+		TxnStart:     time.Now().UTC(),
+		Ops: []journal.Operation{
+			{Kind: journal.OperationUpsert, Path: filepath.Join(host, "app.conf"), SourcePath: filepath.Join(upper, "app.conf"), NodeType: journal.NodeFile},
+			{Kind: journal.OperationUpsert, Path: filepath.Join(host, "extra.conf"), SourcePath: filepath.Join(upper, "extra.conf"), NodeType: journal.NodeFile},
+		},
+		Hooks: &journal.Hooks{
+			PostCommit:    []string{"cat app.conf >> " + filepath.Join(tmp, "seen")},
+			HealthCheck:   healthCheck,
+			HealthTimeout: 200 * time.Millisecond,
+			UID:           uint32(os.Getuid()),
+			GID:           uint32(os.Getgid()),
+			CWD:           host,
+		},
+	}
+}
+
+func TestCommitRevertedByHealthCheck(t *testing.T) {
+	tmp := t.TempDir()
+	j := hookedRun(t, tmp, "exit 1")
+	journalPath := filepath.Join(tmp, "1-1.json")
+	result := commitJournal(journalPath, j, filepath.Join(tmp, "state"), "", nil, io.Discard, io.Discard)
+	if result.AtomicExitCode != exitcode.Reverted || !strings.Contains(result.Message, "did not pass") {
+		t.Fatalf("expected the commit to be reverted: %#v", result)
+	}
+	if got, err := os.ReadFile(filepath.Join(tmp, "host", "app.conf")); err != nil || string(got) != "old" {
+		t.Fatalf("expected the original content after the revert, got %q (%v)", got, err)
+	}
+	if _, err := os.Lstat(filepath.Join(tmp, "host", "extra.conf")); !os.IsNotExist(err) {
+		t.Fatalf("created file survived the revert: %v", err)
+	}
+	// The post-commit action ran against the commit, then the restored file.
+	if got, err := os.ReadFile(filepath.Join(tmp, "seen")); err != nil || string(got) != "newold" {
+		t.Fatalf("unexpected post-commit runs %q (%v)", got, err)
+	}
+	if _, err := os.Stat(journalPath); !os.IsNotExist(err) {
+		t.Fatalf("journal was not removed: %v", err)
+	}
+	if _, err := LoadCommitted(filepath.Join(tmp, "state"), "1-1"); err == nil {
+		t.Fatalf("a reverted commit was recorded for undo")
+	}
+}
+
+func TestSettleRecoveredVerifyingJournal(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		healthCheck string
+		want        string
+	}{
+		{name: "healthy", healthCheck: "true", want: "new"},
+		{name: "unhealthy", healthCheck: "exit 1", want: "old"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tmp := t.TempDir()
+			stateDir := filepath.Join(tmp, "state")
+			j := hookedRun(t, tmp, tc.healthCheck)
+			j.BackupDir = filepath.Join(stateDir, "backups", j.RunID)
+			journalPath := filepath.Join(tmp, "1-1.json")
+			// The daemon applied the run and stopped during its health window.
+			if err := (commit.Engine{}).Apply(journalPath, j); err != nil {
+				t.Fatalf("Apply returned error: %v", err)
+			}
+			j.State = journal.StateVerifying
+			if err := journal.Save(journalPath, j); err != nil {
+				t.Fatalf("save journal: %v", err)
+			}
+			j, err := journal.Load(journalPath)
+			if err != nil {
+				t.Fatalf("load journal: %v", err)
+			}
+
+			if err := settleRecovered(stateDir, "")(journalPath, j); err != nil {
+				t.Fatalf("settleRecovered returned error: %v", err)
+			}
+			if got, err := os.ReadFile(filepath.Join(tmp, "host", "app.conf")); err != nil || string(got) != tc.want {
+				t.Fatalf("expected %q after recovery, got %q (%v)", tc.want, got, err)
+			}
+			if _, err := os.Stat(journalPath); !os.IsNotExist(err) {
+				t.Fatalf("journal was not removed: %v", err)
+			}
+			_, err = LoadCommitted(stateDir, "1-1")
+			if recorded := err == nil; recorded != (tc.want == "new") {
+				t.Fatalf("recorded for undo: %v, want %v", recorded, tc.want == "new")
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+type timeoutError struct {
+	idle  bool
+	limit time.Duration
+}
+
+func (e *timeoutError) Error() string {
+	if e.idle {
+		return fmt.Sprintf("script produced no output for %s", e.limit)
+	}
+	return fmt.Sprintf("script exceeded the %s timeout", e.limit)
+}
+
+// withTimeouts returns a context that is cancelled when the script runs
+// longer than timeout or writes nothing to stdout or stderr for idle. Zero
+// disables a limit. The returned writers record output activity.
+func withTimeouts(ctx context.Context, timeout, idle time.Duration, stdout, stderr io.Writer) (context.Context, io.Writer, io.Writer, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	stops := []func(){}
+	if timeout > 0 {
+		t := time.AfterFunc(timeout, func() { cancel(&timeoutError{limit: timeout}) })
+		stops = append(stops, func() { t.Stop() })
+	}
+	if idle > 0 {
+		var last atomic.Int64
+		last.Store(time.Now().UnixNano())
+		stdout = &activityWriter{w: orStdio(stdout, os.Stdout), last: &last}
+		stderr = &activityWriter{w: orStdio(stderr, os.Stderr), last: &last}
+		done := make(chan struct{})
+		go func() {
+			t := time.NewTimer(idle)
+			defer t.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-t.C:
+					quiet := time.Since(time.Unix(0, last.Load()))
+					if quiet >= idle {
+						cancel(&timeoutError{idle: true, limit: idle})
+						return
+					}
+					t.Reset(idle - quiet)
+				}
+			}
+		}()
+		stops = append(stops, func() { close(done) })
+	}
+	return ctx, stdout, stderr, func() {
+		for _, stop := range stops {
+			stop()
+		}
+		cancel(nil)
+	}
+}
+
+type activityWriter struct {
+	w    io.Writer
+	last *atomic.Int64
+}
+
+func (a *activityWriter) Write(p []byte) (int, error) {
+	a.last.Store(time.Now().UnixNano())
+	return a.w.Write(p)
+}
+
+func orStdio(w io.Writer, fallback *os.File) io.Writer {
+	if w == nil {
+		return fallback
+	}
+	return w
+}
@@ -0,0 +1,137 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/ShriKaranHanda/atomic/internal/conflict"
+	"github.com/ShriKaranHanda/atomic/internal/exitcode"
+	"github.com/ShriKaranHanda/atomic/internal/journal"
+	"github.com/ShriKaranHanda/atomic/internal/preflight"
+)
+
+// PreparedRequest names a prepared run to commit or abort.
+type PreparedRequest struct {
+	RunID      string
+	StateDir   string
+	JournalDir string
+	RootPrefix string
+
+	CommitLock sync.Locker
+	Commits    *conflict.Log
+
+	// Approver approves a run held for approval; such runs are not
+	// committed without one.
+	Approver *journal.Approver
+
+	// Stdout and Stderr receive the output of the run's hooks.
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// LoadPrepared returns the journal of prepared run runID.
+func LoadPrepared(journalDir, runID string) (*journal.Journal, error) {
+	if journalDir == "" {
+		journalDir = DefaultJournalDir
+	}
+	if err := checkRunID(runID); err != nil {
+		return nil, err
+	}
+	j, err := journal.Load(filepath.Join(journalDir, runID+".json"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("prepared run %s not found", runID)
+		}
+		return nil, err
+	}
+	if j.State != journal.StatePrepared {
+		return nil, fmt.Errorf("run %s is not prepared (state %s)", runID, j.State)
+	}
+	return j, nil
+}
+
+// CommitPrepared re-checks a prepared run for conflicts and commits it. A run
+// that fails the checks stays prepared so it can be inspected and aborted.
+func CommitPrepared(req PreparedRequest) ExecuteResult {
+	applyPreparedDefaults(&req)
+	if err := preflight.CheckDaemon(); err != nil {
+		return ExecuteResult{RunID: req.RunID, AtomicExitCode: exitcode.Unsupported, Message: fmt.Sprintf("preflight failed: %v", err)}
+	}
+	req.CommitLock.Lock()
+	defer req.CommitLock.Unlock()
+	j, err := LoadPrepared(req.JournalDir, req.RunID)
+	if err != nil {
+		return ExecuteResult{RunID: req.RunID, AtomicExitCode: exitcode.Unsupported, Message: err.Error()}
+	}
+	if approval := j.Prepared.Approval; approval != nil && approval.Approver == nil {
+		if req.Approver == nil {
+			return ExecuteResult{RunID: req.RunID, AtomicExitCode: exitcode.Pending, Message: fmt.Sprintf("run %s needs approval (%s); another user can approve it with atomic approve %s", req.RunID, approval.Reason, req.RunID)}
+		}
+		// The approval is only saved with the commit, so a run that fails
+		// its conflict checks still needs approval.
+		approval.Approver = req.Approver
+	}
+	result := commitJournal(filepath.Join(req.JournalDir, req.RunID+".json"), j, req.StateDir, req.RootPrefix, req.Commits, req.Stdout, req.Stderr)
+	return finishPrepared(req, result)
+}
+
+// finishPrepared stops tracking req.RunID in req.Commits once its journal has
+// left the prepared state. A commit that stopped before applying anything,
+// whatever the reason, can still be committed or aborted later, so the run
+// stays checked against the commits made meanwhile.
+func finishPrepared(req PreparedRequest, result ExecuteResult) ExecuteResult {
+	if _, err := LoadPrepared(req.JournalDir, req.RunID); err == nil {
+		result.Message += fmt.Sprintf("; the run is still prepared, discard it with atomic abort %s", req.RunID)
+		return result
+	}
+	if req.Commits != nil {
+		req.Commits.End(req.RunID)
+	}
+	return result
+}
+
+// AbortPrepared discards a prepared run. Its workspace is kept if the run
+// was prepared with KeepArtifacts.
+func AbortPrepared(req PreparedRequest) ExecuteResult {
+	applyPreparedDefaults(&req)
+	req.CommitLock.Lock()
+	defer req.CommitLock.Unlock()
+	j, err := LoadPrepared(req.JournalDir, req.RunID)
+	if err != nil {
+		return ExecuteResult{RunID: req.RunID, AtomicExitCode: exitcode.Unsupported, Message: err.Error()}
+	}
+	if !j.KeepArtifacts {
+		if err := os.RemoveAll(j.RunDir); err != nil {
+			return ExecuteResult{RunID: req.RunID, AtomicExitCode: exitcode.Unsupported, Message: fmt.Sprintf("remove run %s: %v", req.RunID, err)}
+		}
+	}
+	if err := os.Remove(filepath.Join(req.JournalDir, req.RunID+".json")); err != nil && !os.IsNotExist(err) {
+		return ExecuteResult{RunID: req.RunID, AtomicExitCode: exitcode.Unsupported, Message: fmt.Sprintf("remove journal of run %s: %v", req.RunID, err)}
+	}
+	if req.Commits != nil {
+		req.Commits.End(req.RunID)
+	}
+	return ExecuteResult{RunID: req.RunID, AtomicExitCode: exitcode.OK, Message: fmt.Sprintf("run %s aborted, nothing committed", req.RunID)}
+}
+
+func applyPreparedDefaults(req *PreparedRequest) {
+	if req.StateDir == "" {
+		req.StateDir = DefaultStateDir
+	}
+	if req.JournalDir == "" {
+		req.JournalDir = DefaultJournalDir
+	}
+	if req.CommitLock == nil {
+		req.CommitLock = noLock{}
+	}
+	if req.Stdout == nil {
+		req.Stdout = io.Discard
+	}
+	if req.Stderr == nil {
+		req.Stderr = io.Discard
+	}
+}
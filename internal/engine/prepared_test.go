@@ -0,0 +1,59 @@
+package engine
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ShriKaranHanda/atomic/internal/conflict"
+	"github.com/ShriKaranHanda/atomic/internal/exitcode"
+	"github.com/ShriKaranHanda/atomic/internal/journal"
+)
+
+func TestFinishPreparedKeepsTrackingPreparedRuns(t *testing.T) {
+	tmp := t.TempDir()
+	journalDir := filepath.Join(tmp, "journal")
+	if err := os.MkdirAll(journalDir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	file := filepath.Join(tmp, "file")
+	if err := os.WriteFile(file, []byte("x"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	start := time.Now().UTC()
+	j := &journal.Journal{
+		RunID:        "1-1",
+		State:        journal.StatePrepared,
+		Prepared:     &journal.Prepared{At: start},
+		AppliedIndex: -1,
+		TxnStart:     start,
+		// Collecting its baseline fails, before anything is applied.
+		Ops: []journal.Operation{{Kind: journal.OperationUpsert, Path: filepath.Join(file, "child"), SourcePath: file, NodeType: journal.NodeFile}},
+	}
+	journalPath := filepath.Join(journalDir, "1-1.json")
+	if err := journal.Save(journalPath, j); err != nil {
+		t.Fatalf("save journal: %v", err)
+	}
+	commits := &conflict.Log{}
+	commits.Begin("1-1", start)
+	commits.Add(conflict.Committed{RunID: "2-1", At: start.Add(time.Second), Paths: []string{"/elsewhere"}})
+
+	req := PreparedRequest{RunID: "1-1", JournalDir: journalDir, StateDir: filepath.Join(tmp, "state"), Commits: commits}
+	result := finishPrepared(req, commitJournal(journalPath, j, req.StateDir, "", commits, io.Discard, io.Discard))
+	if result.AtomicExitCode == exitcode.OK || result.AtomicExitCode == exitcode.Conflict {
+		t.Fatalf("expected the commit to fail before applying: %#v", result)
+	}
+	if len(commits.Since(start)) != 1 {
+		t.Fatalf("a run that is still prepared was no longer tracked")
+	}
+
+	if err := os.Remove(journalPath); err != nil {
+		t.Fatalf("remove journal: %v", err)
+	}
+	finishPrepared(req, ExecuteResult{RunID: "1-1"})
+	if len(commits.Since(start)) != 0 {
+		t.Fatalf("a run that left the prepared state was still tracked")
+	}
+}
@@ -0,0 +1,170 @@
+package engine
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ShriKaranHanda/atomic/internal/commit"
+	"github.com/ShriKaranHanda/atomic/internal/conflict"
+	"github.com/ShriKaranHanda/atomic/internal/diff"
+	"github.com/ShriKaranHanda/atomic/internal/exitcode"
+	"github.com/ShriKaranHanda/atomic/internal/history"
+	"github.com/ShriKaranHanda/atomic/internal/journal"
+	"github.com/ShriKaranHanda/atomic/internal/preflight"
+	"github.com/ShriKaranHanda/atomic/internal/recover"
+)
+
+// UndoRequest names a committed run to undo.
+type UndoRequest struct {
+	RunID      string
+	StateDir   string
+	JournalDir string
+	RootPrefix string
+	// Owner is the UID recorded as having started the undo.
+	Owner uint32
+
+	CommitLock sync.Locker
+	Commits    *conflict.Log
+
+	// Stdout and Stderr receive the output of the original run's hooks,
+	// which run again after the undo.
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// LoadCommitted returns the journal of run runID from the history store.
+func LoadCommitted(stateDir, runID string) (*journal.Journal, error) {
+	if stateDir == "" {
+		stateDir = DefaultStateDir
+	}
+	if err := checkRunID(runID); err != nil {
+		return nil, err
+	}
+	return history.Load(filepath.Join(stateDir, "history"), runID)
+}
+
+// ListCommitted returns the runs that can be undone, newest first.
+func ListCommitted(stateDir string) ([]*journal.Journal, error) {
+	if stateDir == "" {
+		stateDir = DefaultStateDir
+	}
+	return history.List(filepath.Join(stateDir, "history"))
+}
+
+// Undo restores the paths a committed run changed from its backups, as a
+// new transaction that is itself kept for undo. It refuses if any of them
+// changed after the commit.
+func Undo(req UndoRequest) ExecuteResult {
+	if req.StateDir == "" {
+		req.StateDir = DefaultStateDir
+	}
+	if req.JournalDir == "" {
+		req.JournalDir = DefaultJournalDir
+	}
+	if req.CommitLock == nil {
+		req.CommitLock = noLock{}
+	}
+	if err := preflight.CheckDaemon(); err != nil {
+		return ExecuteResult{RunID: req.RunID, AtomicExitCode: exitcode.Unsupported, Message: fmt.Sprintf("preflight failed: %v", err)}
+	}
+	req.CommitLock.Lock()
+	defer req.CommitLock.Unlock()
+	if err := recover.Run(req.JournalDir, req.RootPrefix, settleRecovered(req.StateDir, req.RootPrefix)); err != nil {
+		return ExecuteResult{RunID: req.RunID, AtomicExitCode: exitcode.RecoveryFailure, Message: fmt.Sprintf("recovery failed: %v", err)}
+	}
+	committed, err := LoadCommitted(req.StateDir, req.RunID)
+	if err != nil {
+		return ExecuteResult{RunID: req.RunID, AtomicExitCode: exitcode.Unsupported, Message: err.Error()}
+	}
+	ops, err := reverseOps(committed)
+	if err != nil {
+		return ExecuteResult{RunID: req.RunID, AtomicExitCode: exitcode.Unsupported, Message: fmt.Sprintf("build undo of run %s: %v", req.RunID, err)}
+	}
+	if err := checkUnchanged(committed); err != nil {
+		return ExecuteResult{RunID: req.RunID, AtomicExitCode: exitcode.Conflict, Message: fmt.Sprintf("conflict detected: %v; run %s was not undone", err, req.RunID)}
+	}
+
+	now := time.Now().UTC()
+	undo := &journal.Journal{
+		RunID:        fmt.Sprintf("%d-%d", now.UnixNano(), os.Getpid()),
+		Ops:          ops,
+		AppliedIndex: -1,
+		StartedAt:    now,
+		TxnStart:     now,
+		Owner:        req.Owner,
+		Undoes:       req.RunID,
+		Hooks:        committed.Hooks,
+	}
+	if req.Commits != nil {
+		req.Commits.Begin(undo.RunID, now)
+		defer req.Commits.End(undo.RunID)
+	}
+	stdout, stderr := req.Stdout, req.Stderr
+	if stdout == nil {
+		stdout = io.Discard
+	}
+	if stderr == nil {
+		stderr = io.Discard
+	}
+	result := commitJournal(filepath.Join(req.JournalDir, undo.RunID+".json"), undo, req.StateDir, req.RootPrefix, req.Commits, stdout, stderr)
+	if result.AtomicExitCode != exitcode.OK {
+		return result
+	}
+	if err := history.Remove(filepath.Join(req.StateDir, "history"), req.RunID); err != nil {
+		return ExecuteResult{RunID: undo.RunID, AtomicExitCode: exitcode.RecoveryFailure, Message: fmt.Sprintf("run %s was undone, but %v", req.RunID, err)}
+	}
+	return ExecuteResult{RunID: undo.RunID, AtomicExitCode: exitcode.OK, Message: fmt.Sprintf("run %s undone by run %s (%d operations); undo that to redo it", req.RunID, undo.RunID, len(ops))}
+}
+
+// reverseOps turns the backups of j into operations that restore each path
+// it changed: backed up paths are written back whole, the others deleted.
+// Paths under a backed up directory are restored with it.
+func reverseOps(j *journal.Journal) ([]journal.Operation, error) {
+	ops := make([]journal.Operation, 0, len(j.BackupRefs))
+	for path, ref := range j.BackupRefs {
+		if commit.BackedUpAbove(j.BackupRefs, path) {
+			continue
+		}
+		if !ref.Exists {
+			ops = append(ops, journal.Operation{Kind: journal.OperationDelete, Path: path, NodeType: journal.NodeUnknown})
+			continue
+		}
+		info, err := os.Lstat(ref.Path)
+		if err != nil {
+			return nil, fmt.Errorf("backup of %s: %w", path, err)
+		}
+		op := journal.Operation{Kind: journal.OperationUpsert, Path: path, SourcePath: ref.Path}
+		switch {
+		case info.IsDir():
+			// The backup holds the whole directory as it was.
+			op.NodeType, op.Opaque = journal.NodeDirectory, true
+		case info.Mode()&os.ModeSymlink != 0:
+			op.NodeType = journal.NodeSymlink
+		case info.Mode().IsRegular():
+			op.NodeType = journal.NodeFile
+		default:
+			return nil, fmt.Errorf("backup of %s has unsupported type %v", path, info.Mode().Type())
+		}
+		ops = append(ops, op)
+	}
+	return diff.Plan(ops), nil
+}
+
+// checkUnchanged reports a path j changed that is no longer as j's commit
+// left it.
+func checkUnchanged(j *journal.Journal) error {
+	for path, after := range j.Committed {
+		now, err := conflict.BaselineForPath(path)
+		if err != nil {
+			return err
+		}
+		if now.Exists != after.Exists || now.Exists && (now.Inode != after.Inode || now.Dev != after.Dev || now.CTimeNs != after.CTimeNs) {
+			return fmt.Errorf("%s changed after run %s committed it", path, j.RunID)
+		}
+	}
+	return nil
+}
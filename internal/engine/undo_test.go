@@ -0,0 +1,104 @@
+package engine
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ShriKaranHanda/atomic/internal/diff"
+	"github.com/ShriKaranHanda/atomic/internal/exitcode"
+	"github.com/ShriKaranHanda/atomic/internal/journal"
+)
+
+func TestReverseOps(t *testing.T) {
+	backups := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(backups, "etc", "app.d"), 0o755); err != nil {
+		t.Fatalf("create backup dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(backups, "etc", "app.conf"), []byte("old"), 0o644); err != nil {
+		t.Fatalf("write backup: %v", err)
+	}
+	j := &journal.Journal{BackupRefs: map[string]journal.BackupRef{
+		"/etc/app.conf": {Exists: true, Path: filepath.Join(backups, "etc", "app.conf")},
+		"/etc/app.d":    {Exists: true, Path: filepath.Join(backups, "etc", "app.d")},
+		"/etc/new.conf": {},
+	}}
+	ops, err := reverseOps(j)
+	if err != nil {
+		t.Fatalf("reverseOps returned error: %v", err)
+	}
+	if len(ops) != 3 {
+		t.Fatalf("unexpected ops: %#v", ops)
+	}
+	if ops[0].Path != "/etc/app.d" || ops[0].NodeType != journal.NodeDirectory || !ops[0].Opaque {
+		t.Fatalf("expected the directory to be restored whole first: %#v", ops[0])
+	}
+	if ops[1].Path != "/etc/app.conf" || ops[1].Kind != journal.OperationUpsert || ops[1].NodeType != journal.NodeFile {
+		t.Fatalf("unexpected file restore: %#v", ops[1])
+	}
+	if ops[2].Path != "/etc/new.conf" || ops[2].Kind != journal.OperationDelete {
+		t.Fatalf("expected the created file to be deleted: %#v", ops[2])
+	}
+}
+
+func TestUndoOpaqueDirectory(t *testing.T) {
+	tmp := t.TempDir()
+	host := filepath.Join(tmp, "host")
+	upper := filepath.Join(tmp, "upper")
+	stateDir := filepath.Join(tmp, "state")
+	for path, content := range map[string]string{
+		filepath.Join(host, "d", "f"):  "old",
+		filepath.Join(host, "d", "g"):  "kept",
+		filepath.Join(upper, "d", "f"): "new",
+	} {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("write %s: %v", path, err)
+		}
+	}
+
+	// The run did rm -rf d; mkdir d; echo new > d/f.
+	now := time.Now().UTC()
+	j := &journal.Journal{
+		RunID:        "1-1",
+		AppliedIndex: -1,
+		TxnStart:     now,
+		Ops: diff.Plan([]journal.Operation{
+			{Kind: journal.OperationUpsert, Path: filepath.Join(host, "d", "f"), SourcePath: filepath.Join(upper, "d", "f"), NodeType: journal.NodeFile},
+			{Kind: journal.OperationUpsert, Path: filepath.Join(host, "d"), SourcePath: filepath.Join(upper, "d"), NodeType: journal.NodeDirectory, Opaque: true},
+		}),
+	}
+	result := commitJournal(filepath.Join(tmp, "1-1.json"), j, stateDir, "", nil, io.Discard, io.Discard)
+	if result.AtomicExitCode != exitcode.OK {
+		t.Fatalf("commit failed: %#v", result)
+	}
+	if _, err := os.Lstat(filepath.Join(host, "d", "g")); !os.IsNotExist(err) {
+		t.Fatalf("expected the directory to be replaced, stat returned %v", err)
+	}
+
+	committed, err := LoadCommitted(stateDir, "1-1")
+	if err != nil {
+		t.Fatalf("LoadCommitted returned error: %v", err)
+	}
+	if err := checkUnchanged(committed); err != nil {
+		t.Fatalf("checkUnchanged returned error: %v", err)
+	}
+	ops, err := reverseOps(committed)
+	if err != nil {
+		t.Fatalf("reverseOps returned error: %v", err)
+	}
+	undo := &journal.Journal{RunID: "2-1", AppliedIndex: -1, TxnStart: time.Now().UTC(), Ops: ops}
+	if result := commitJournal(filepath.Join(tmp, "2-1.json"), undo, stateDir, "", nil, io.Discard, io.Discard); result.AtomicExitCode != exitcode.OK {
+		t.Fatalf("undo failed: %#v", result)
+	}
+	for name, want := range map[string]string{"f": "old", "g": "kept"} {
+		got, err := os.ReadFile(filepath.Join(host, "d", name))
+		if err != nil || string(got) != want {
+			t.Fatalf("expected d/%s to be restored to %q, got %q (%v)", name, want, got, err)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/ShriKaranHanda/atomic/internal/cgroup"
+	"github.com/ShriKaranHanda/atomic/internal/exitcode"
+	"github.com/ShriKaranHanda/atomic/internal/journal"
+	"github.com/ShriKaranHanda/atomic/internal/overlay"
+)
+
+// Validator is a bash command line that must succeed against a run's changes
+// before they are committed. Paths, when set, limit it to runs that change
+// something at or under one of them; Root runs it as root instead of the
+// run-as user.
+type Validator struct {
+	Command string
+	Paths   []string
+	Root    bool
+}
+
+// applicableValidators returns the validators that apply to ops, in order.
+func applicableValidators(validators []Validator, ops []journal.Operation) []overlay.Validator {
+	if len(ops) == 0 {
+		return nil
+	}
+	var out []overlay.Validator
+	for _, v := range validators {
+		if len(v.Paths) == 0 || touchesAny(ops, v.Paths) {
+			out = append(out, overlay.Validator{Command: v.Command, Root: v.Root})
+		}
+	}
+	return out
+}
+
+func touchesAny(ops []journal.Operation, paths []string) bool {
+	for _, op := range ops {
+		for _, path := range paths {
+			path = filepath.Clean(path)
+			if path == "/" || op.Path == path || strings.HasPrefix(op.Path, path+"/") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// validate runs the validators that apply to ops over the run in runDir,
+// under the run's time limits. It returns nil if they all pass.
+func validate(ctx context.Context, req ExecuteRequest, runDir string, group *cgroup.Group, ops []journal.Operation) *ExecuteResult {
+	validators := applicableValidators(req.Validators, ops)
+	if len(validators) == 0 {
+		return nil
+	}
+	valCtx, stdout, stderr, stopTimeouts := withTimeouts(ctx, req.Timeout, req.IdleTimeout, req.Stdout, req.Stderr)
+	failure, err := overlay.RunValidators(valCtx, overlay.ValidateConfig{RunDir: runDir, Validators: validators, Stdout: stdout, Stderr: stderr, Cgroup: group})
+	timedOut := valCtx.Err() != nil && ctx.Err() == nil
+	stopTimeouts()
+	if group != nil {
+		_ = group.Kill()
+	}
+	switch {
+	case timedOut:
+		return &ExecuteResult{RunID: req.RunID, AtomicExitCode: exitcode.Timeout, Message: fmt.Sprintf("%v while validating; nothing was committed", context.Cause(valCtx))}
+	case ctx.Err() != nil:
+		result := aborted(ctx, req.RunID)
+		return &result
+	case err != nil:
+		return &ExecuteResult{RunID: req.RunID, AtomicExitCode: exitcode.Unsupported, Message: fmt.Sprintf("validation failed to run: %v", err)}
+	case failure != nil && failure.Signal != 0:
+		return &ExecuteResult{RunID: req.RunID, AtomicExitCode: exitcode.ValidationFailed, Message: fmt.Sprintf("validator %q terminated by signal %d; nothing committed", failure.Command, failure.Signal)}
+	case failure != nil:
+		return &ExecuteResult{RunID: req.RunID, AtomicExitCode: exitcode.ValidationFailed, Message: fmt.Sprintf("validator %q failed with exit status %d; nothing committed", failure.Command, failure.ExitCode)}
+	}
+	return nil
+}
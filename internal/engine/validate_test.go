@@ -0,0 +1,24 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/ShriKaranHanda/atomic/internal/journal"
+	"github.com/ShriKaranHanda/atomic/internal/overlay"
+)
+
+func TestApplicableValidators(t *testing.T) {
+	validators := []Validator{
+		{Command: "nginx -t", Paths: []string{"/etc/nginx/"}, Root: true},
+		{Command: "visudo -c", Paths: []string{"/etc/sudoers", "/etc/sudoers.d"}, Root: true},
+		{Command: "make check"},
+	}
+	got := applicableValidators(validators, []journal.Operation{{Path: "/etc/nginx/sites/app.conf"}, {Path: "/etc/sudoers.dpkg"}})
+	want := []overlay.Validator{{Command: "nginx -t", Root: true}, {Command: "make check"}}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("unexpected validators: %#v", got)
+	}
+	if got := applicableValidators(validators, nil); len(got) != 0 {
+		t.Fatalf("validators ran for a run without changes: %#v", got)
+	}
+}
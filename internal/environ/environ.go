@@ -0,0 +1,120 @@
+package environ
+
+import (
+	"os/user"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	RootPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+	UserPath = "/usr/local/bin:/usr/bin:/bin"
+)
+
+var (
+	DefaultAllow = []string{
+		"LANG", "LANGUAGE", "LC_*", "TZ",
+		"TERM", "COLORTERM", "NO_COLOR",
+		"http_proxy", "https_proxy", "ftp_proxy", "all_proxy", "no_proxy",
+		"HTTP_PROXY", "HTTPS_PROXY", "FTP_PROXY", "ALL_PROXY", "NO_PROXY",
+	}
+	// DefaultDeny covers variables that change how the loader or bash
+	// behave before the script gets a chance to run.
+	DefaultDeny = []string{
+		"LD_*", "BASH_ENV", "ENV", "BASH_FUNC_*", "SHELLOPTS", "BASHOPTS",
+		"PS4", "IFS", "CDPATH", "GLOBIGNORE", "GCONV_PATH", "NLSPATH",
+		"HOSTALIASES", "LOCALDOMAIN", "RES_OPTIONS", "MALLOC_*", "GLIBC_TUNABLES",
+	}
+)
+
+// reserved variables are always derived from the run-as identity.
+var reserved = map[string]bool{"HOME": true, "USER": true, "LOGNAME": true, "PATH": true}
+
+// Policy selects which caller variables reach the script. Patterns use
+// path.Match syntax and a deny match wins over an allow match.
+type Policy struct {
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+}
+
+func DefaultPolicy() Policy {
+	return Policy{Allow: DefaultAllow, Deny: DefaultDeny}
+}
+
+func (p Policy) Permits(name string) bool {
+	if name == "" || reserved[name] {
+		return false
+	}
+	return matchAny(p.Allow, name) && !matchAny(p.Deny, name)
+}
+
+func (p Policy) Filter(env map[string]string) map[string]string {
+	out := make(map[string]string, len(env))
+	for name, value := range env {
+		if p.Permits(name) {
+			out[name] = value
+		}
+	}
+	return out
+}
+
+// Build returns the script environment as KEY=VALUE pairs: the permitted
+// caller variables plus HOME, USER, LOGNAME and PATH for uid.
+func Build(p Policy, callerEnv map[string]string, uid uint32) []string {
+	env := p.Filter(callerEnv)
+	for name, value := range identity(uid) {
+		env[name] = value
+	}
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	out := make([]string, 0, len(names))
+	for _, name := range names {
+		out = append(out, name+"="+env[name])
+	}
+	return out
+}
+
+// ForRoot returns env, a KEY=VALUE list built for another user, with HOME,
+// USER, LOGNAME and PATH replaced by root's.
+func ForRoot(env []string) []string {
+	out := make([]string, 0, len(env)+len(reserved))
+	for _, kv := range env {
+		name, _, _ := strings.Cut(kv, "=")
+		if !reserved[name] {
+			out = append(out, kv)
+		}
+	}
+	out = append(out, "HOME=/root", "USER=root", "LOGNAME=root", "PATH="+RootPath)
+	sort.Strings(out)
+	return out
+}
+
+func identity(uid uint32) map[string]string {
+	name := strconv.FormatUint(uint64(uid), 10)
+	home := "/"
+	if u, err := user.LookupId(name); err == nil {
+		name = u.Username
+		if u.HomeDir != "" {
+			home = u.HomeDir
+		}
+	}
+	pathValue := UserPath
+	if uid == 0 {
+		pathValue = RootPath
+	}
+	return map[string]string{"HOME": home, "USER": name, "LOGNAME": name, "PATH": pathValue}
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, err := path.Match(pattern, name); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,53 @@
+package environ
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPolicyFilter(t *testing.T) {
+	p := Policy{Allow: []string{"LANG", "LC_*", "*_proxy", "LD_PRELOAD", "HOME"}, Deny: []string{"LD_*"}}
+	got := p.Filter(map[string]string{
+		"LANG":        "C.UTF-8",
+		"LC_ALL":      "C",
+		"https_proxy": "http://proxy:3128",
+		"LD_PRELOAD":  "/tmp/evil.so",
+		"HOME":        "/home/caller",
+		"SECRET":      "x",
+	})
+	want := map[string]string{"LANG": "C.UTF-8", "LC_ALL": "C", "https_proxy": "http://proxy:3128"}
+	if len(got) != len(want) {
+		t.Fatalf("unexpected filtered env: %v", got)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Fatalf("expected %s=%s, got %v", k, v, got)
+		}
+	}
+}
+
+func TestBuildSetsIdentity(t *testing.T) {
+	env := Build(DefaultPolicy(), map[string]string{"PATH": "/caller/bin", "TZ": "UTC", "BASH_ENV": "/tmp/x"}, 0)
+	joined := strings.Join(env, "\n")
+	for _, want := range []string{"PATH=" + RootPath, "TZ=UTC", "HOME=", "USER=", "LOGNAME="} {
+		if !strings.Contains(joined, want) {
+			t.Fatalf("expected %q in env:\n%s", want, joined)
+		}
+	}
+	if strings.Contains(joined, "BASH_ENV") || strings.Contains(joined, "/caller/bin") {
+		t.Fatalf("caller variables leaked into env:\n%s", joined)
+	}
+}
+
+func TestForRoot(t *testing.T) {
+	env := ForRoot([]string{"HOME=/home/alice", "PATH=" + UserPath, "TZ=UTC", "USER=alice"})
+	joined := strings.Join(env, "\n")
+	for _, want := range []string{"HOME=/root", "PATH=" + RootPath, "TZ=UTC", "USER=root", "LOGNAME=root"} {
+		if !strings.Contains(joined, want) {
+			t.Fatalf("expected %q in env:\n%s", want, joined)
+		}
+	}
+	if strings.Contains(joined, "alice") || len(env) != 5 {
+		t.Fatalf("caller identity left in env:\n%s", joined)
+	}
+}
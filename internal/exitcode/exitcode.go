@@ -1,9 +1,15 @@
 package exitcode
 
 const (
-	OK              = 0
-	ScriptFailed    = 10
-	Unsupported     = 20
-	Conflict        = 21
-	RecoveryFailure = 30
+	OK               = 0
+	ScriptFailed     = 10
+	Rejected         = 11
+	Timeout          = 12
+	Pending          = 13
+	ValidationFailed = 14
+	Reverted         = 15
+	Unsupported      = 20
+	Conflict         = 21
+	Busy             = 22
+	RecoveryFailure  = 30
 )
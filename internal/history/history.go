@@ -0,0 +1,134 @@
+package history
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ShriKaranHanda/atomic/internal/journal"
+)
+
+// The history store keeps each committed transaction in a directory named
+// after its run ID, holding its journal and the backups of the paths it
+// changed, so that it can be undone.
+const (
+	journalFileName = "journal.json"
+	backupDirName   = "backups"
+)
+
+// Add moves committed journal j and its backups into the store at dir and
+// drops the oldest entries beyond keep. j's backup references are updated to
+// the new location.
+func Add(dir string, j *journal.Journal, keep int) error {
+	entry := filepath.Join(dir, j.RunID)
+	if err := os.MkdirAll(entry, 0o700); err != nil {
+		return fmt.Errorf("create history entry: %w", err)
+	}
+	if j.BackupDir != "" {
+		backups := filepath.Join(entry, backupDirName)
+		if err := os.Rename(j.BackupDir, backups); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("move backups into history: %w", err)
+		}
+		for path, ref := range j.BackupRefs {
+			if ref.Exists {
+				ref.Path = filepath.Join(backups, strings.TrimPrefix(ref.Path, j.BackupDir))
+				j.BackupRefs[path] = ref
+			}
+		}
+		j.BackupDir = backups
+	}
+	if err := journal.Save(filepath.Join(entry, journalFileName), j); err != nil {
+		return err
+	}
+	return prune(dir, keep)
+}
+
+// Load returns the journal of run runID from the store at dir.
+func Load(dir, runID string) (*journal.Journal, error) {
+	j, err := journal.Load(filepath.Join(dir, runID, journalFileName))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("run %s is not in the history (only the last committed runs can be undone)", runID)
+		}
+		return nil, err
+	}
+	return j, nil
+}
+
+// List returns the journals in the store at dir, newest first.
+func List(dir string) ([]*journal.Journal, error) {
+	names, err := entries(dir)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*journal.Journal, 0, len(names))
+	for i := len(names) - 1; i >= 0; i-- {
+		j, err := journal.Load(filepath.Join(dir, names[i], journalFileName))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		out = append(out, j)
+	}
+	return out, nil
+}
+
+// Remove deletes run runID and its backups from the store at dir.
+func Remove(dir, runID string) error {
+	if err := os.RemoveAll(filepath.Join(dir, runID)); err != nil {
+		return fmt.Errorf("remove run %s from history: %w", runID, err)
+	}
+	return nil
+}
+
+// entries returns the run IDs in the store, oldest first. Run IDs start
+// with the run's start time in nanoseconds.
+func entries(dir string) ([]string, error) {
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read history directory: %w", err)
+	}
+	var names []string
+	for _, entry := range dirEntries {
+		if entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Slice(names, func(a, b int) bool {
+		ta, tb := startTime(names[a]), startTime(names[b])
+		if ta != tb {
+			return ta < tb
+		}
+		return names[a] < names[b]
+	})
+	return names, nil
+}
+
+func startTime(runID string) int64 {
+	prefix, _, _ := strings.Cut(runID, "-")
+	n, _ := strconv.ParseInt(prefix, 10, 64)
+	return n
+}
+
+func prune(dir string, keep int) error {
+	names, err := entries(dir)
+	if err != nil {
+		return err
+	}
+	for len(names) > keep {
+		if err := Remove(dir, names[0]); err != nil {
+			return err
+		}
+		names = names[1:]
+	}
+	return nil
+}
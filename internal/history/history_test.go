@@ -0,0 +1,52 @@
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ShriKaranHanda/atomic/internal/journal"
+)
+
+func TestAddKeepsNewestRuns(t *testing.T) {
+	tmp := t.TempDir()
+	dir := filepath.Join(tmp, "history")
+	for _, runID := range []string{"900-1", "1000-22", "1100-3"} {
+		backups := filepath.Join(tmp, "backups", runID)
+		backup := filepath.Join(backups, "etc", "app.conf")
+		if err := os.MkdirAll(filepath.Dir(backup), 0o755); err != nil {
+			t.Fatalf("create backup dir: %v", err)
+		}
+		if err := os.WriteFile(backup, []byte(runID), 0o644); err != nil {
+			t.Fatalf("write backup: %v", err)
+		}
+		j := &journal.Journal{
+			RunID:      runID,
+			State:      journal.StateCommitted,
+			BackupDir:  backups,
+			BackupRefs: map[string]journal.BackupRef{"/etc/app.conf": {Exists: true, Path: backup}, "/etc/new.conf": {}},
+		}
+		if err := Add(dir, j, 2); err != nil {
+			t.Fatalf("Add(%s) returned error: %v", runID, err)
+		}
+	}
+
+	runs, err := List(dir)
+	if err != nil {
+		t.Fatalf("List returned error: %v", err)
+	}
+	if len(runs) != 2 || runs[0].RunID != "1100-3" || runs[1].RunID != "1000-22" {
+		t.Fatalf("unexpected history: %#v", runs)
+	}
+	if _, err := Load(dir, "900-1"); err == nil {
+		t.Fatalf("expected the oldest run to be pruned")
+	}
+	ref := runs[0].BackupRefs["/etc/app.conf"]
+	got, err := os.ReadFile(ref.Path)
+	if err != nil || string(got) != "1100-3" {
+		t.Fatalf("backup not moved into history: %q, %v", got, err)
+	}
+	if runs[0].BackupRefs["/etc/new.conf"].Exists {
+		t.Fatalf("missing backup became an existing one")
+	}
+}
@@ -6,6 +6,11 @@ import (
 	"fmt"
 	"io"
 	"sync"
+	"time"
+
+	"github.com/ShriKaranHanda/atomic/internal/cgroup"
+	"github.com/ShriKaranHanda/atomic/internal/changeset"
+	"github.com/ShriKaranHanda/atomic/internal/journal"
 )
 
 const (
@@ -13,14 +18,40 @@ const (
 
 	RequestRun     = "run"
 	RequestRecover = "recover"
+	RequestDiff    = "diff"
+	RequestAttach  = "attach"
+	RequestWait    = "wait"
+	RequestCommit  = "commit"
+	RequestAbort   = "abort"
+	RequestList    = "list"
+	RequestApprove = "approve"
+	RequestUndo    = "undo"
+
+	EventQueued   = "queued"
+	EventDetached = "detached"
+	EventStart    = "start"
+	EventStdout   = "stdout"
+	EventStderr   = "stderr"
+	EventPlan     = "plan"
+	EventRuns     = "runs"
+	EventReview   = "review"
+	EventResult   = "result"
+	EventError    = "error"
+	// EventStdinAck confirms that Acked bytes of stdin reached the script.
+	EventStdinAck = "stdin_ack"
 
-	EventStart  = "start"
-	EventStdout = "stdout"
-	EventStderr = "stderr"
-	EventResult = "result"
-	EventError  = "error"
+	FrameReview     = "review"
+	FrameStdin      = "stdin"
+	FrameStdinClose = "stdin_close"
+	FrameResize     = "resize"
+	FrameSignal     = "signal"
 )
 
+// StdinWindow is how many stdin bytes a client may send before atomicd
+// acknowledges them, and how many atomicd holds for a script that is not
+// reading its input.
+const StdinWindow = 1 << 20
+
 type Request struct {
 	Type          string            `json:"type"`
 	Version       int               `json:"version"`
@@ -30,15 +61,104 @@ type Request struct {
 	Env           map[string]string `json:"env,omitempty"`
 	KeepArtifacts bool              `json:"keep_artifacts,omitempty"`
 	Verbose       bool              `json:"verbose,omitempty"`
+	DryRun        bool              `json:"dry_run,omitempty"`
+	RunID         string            `json:"run_id,omitempty"`
+	Review        bool              `json:"review,omitempty"`
+	TTY           bool              `json:"tty,omitempty"`
+	Rows          uint16            `json:"rows,omitempty"`
+	Cols          uint16            `json:"cols,omitempty"`
+	Timeout       time.Duration     `json:"timeout,omitempty"`
+	IdleTimeout   time.Duration     `json:"idle_timeout,omitempty"`
+	Limits        cgroup.Limits     `json:"limits"`
+	// PIDNamespace overrides the daemon default when set.
+	PIDNamespace *bool `json:"pid_namespace,omitempty"`
+	// Network (host, none or loopback) overrides the daemon default when set.
+	Network string `json:"network,omitempty"`
+	// Umask is the caller's file mode creation mask.
+	Umask *uint32 `json:"umask,omitempty"`
+	// RunAs names the account (user name or UID) to run as instead of the
+	// caller's. The daemon allows it for root and per its run_as policy.
+	RunAs string `json:"run_as,omitempty"`
+	// NoWait fails the run with exitcode.Busy instead of queueing it behind
+	// the active transaction.
+	NoWait bool `json:"no_wait,omitempty"`
+	// Locks are named advisory locks held exclusively from the start of the
+	// script through its commit.
+	Locks []string `json:"locks,omitempty"`
+	// Detach hands the run to the daemon: it is acknowledged with a
+	// detached event and continues after the client disconnects.
+	Detach bool `json:"detach,omitempty"`
+	// Prepare stops the run before its commit; the changes are kept for a
+	// later commit or abort request naming RunID.
+	Prepare bool `json:"prepare,omitempty"`
+	// Validators are command lines that must succeed against the run's
+	// changes, run as the run-as user, before they are committed.
+	Validators []string `json:"validators,omitempty"`
+	// PostCommit actions and then HealthCheck run on the host after the
+	// commit, within HealthTimeout; if one fails the commit is reverted.
+	PostCommit    []string      `json:"post_commit,omitempty"`
+	HealthCheck   string        `json:"health_check,omitempty"`
+	HealthTimeout time.Duration `json:"health_timeout,omitempty"`
+	// Prepared limits a list request to prepared runs.
+	Prepared bool `json:"prepared,omitempty"`
+	// History makes a list request return the committed runs that can be
+	// undone instead.
+	History bool `json:"history,omitempty"`
 }
 
 type Event struct {
-	Type           string `json:"type"`
-	RunID          string `json:"run_id,omitempty"`
-	AtomicExitCode int    `json:"atomic_exit_code,omitempty"`
-	ScriptExitCode int    `json:"script_exit_code,omitempty"`
-	Message        string `json:"message,omitempty"`
-	DataB64        string `json:"data_b64,omitempty"`
+	Type           string              `json:"type"`
+	RunID          string              `json:"run_id,omitempty"`
+	AtomicExitCode int                 `json:"atomic_exit_code,omitempty"`
+	ScriptExitCode int                 `json:"script_exit_code,omitempty"`
+	ScriptSignal   int                 `json:"script_signal,omitempty"`
+	Message        string              `json:"message,omitempty"`
+	DataB64        string              `json:"data_b64,omitempty"`
+	Ops            []journal.Operation `json:"ops,omitempty"`
+	Changes        []changeset.Change  `json:"changes,omitempty"`
+	Usage          *cgroup.Usage       `json:"usage,omitempty"`
+	RunAs          *RunAs              `json:"run_as,omitempty"`
+	// Position is the run's place in the queue (1 is next) in queued events;
+	// Message says what it is waiting for.
+	Position int `json:"position,omitempty"`
+	// Runs answers a list request.
+	Runs []RunInfo `json:"runs,omitempty"`
+	// Acked is the size of the stdin delivered in a stdin_ack event.
+	Acked int `json:"acked,omitempty"`
+}
+
+// RunInfo describes a run known to the daemon. State is queued, running,
+// finished, prepared or pending (waiting for approval); Changes is set for
+// prepared runs and ExitCode for finished ones.
+type RunInfo struct {
+	RunID      string    `json:"run_id"`
+	State      string    `json:"state"`
+	Owner      uint32    `json:"owner"`
+	ScriptPath string    `json:"script_path"`
+	Started    time.Time `json:"started"`
+	Changes    int       `json:"changes,omitempty"`
+	ExitCode   int       `json:"exit_code,omitempty"`
+}
+
+// RunAs audits a run that executed as an account other than the caller's.
+type RunAs struct {
+	User      string `json:"user"`
+	UID       uint32 `json:"uid"`
+	GID       uint32 `json:"gid"`
+	CallerUID uint32 `json:"caller_uid"`
+	CallerPID int32  `json:"caller_pid"`
+}
+
+// Frame is sent from the client to the daemon after the initial Request,
+// while a run is in progress.
+type Frame struct {
+	Type    string   `json:"type"`
+	Accept  bool     `json:"accept,omitempty"`
+	Paths   []string `json:"paths,omitempty"`
+	DataB64 string   `json:"data_b64,omitempty"`
+	Rows    uint16   `json:"rows,omitempty"`
+	Cols    uint16   `json:"cols,omitempty"`
+	Signal  int      `json:"signal,omitempty"`
 }
 
 type Writer struct {
@@ -62,6 +182,12 @@ func (w *Writer) WriteEvent(ev Event) error {
 	return w.enc.Encode(ev)
 }
 
+func (w *Writer) WriteFrame(f Frame) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.enc.Encode(f)
+}
+
 type Reader struct {
 	dec *json.Decoder
 }
@@ -86,6 +212,14 @@ func (r *Reader) ReadEvent() (Event, error) {
 	return ev, nil
 }
 
+func (r *Reader) ReadFrame() (Frame, error) {
+	var f Frame
+	if err := r.dec.Decode(&f); err != nil {
+		return Frame{}, err
+	}
+	return f, nil
+}
+
 type StreamEventWriter struct {
 	Kind  string
 	RunID string
@@ -106,6 +240,32 @@ func (w *StreamEventWriter) Write(p []byte) (int, error) {
 	return len(p), nil
 }
 
+// StreamFrameWriter turns writes into stdin frames, the client-side
+// counterpart of StreamEventWriter.
+type StreamFrameWriter struct {
+	Sink func(Frame) error
+}
+
+func (w *StreamFrameWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if w.Sink == nil {
+		return 0, fmt.Errorf("stream sink is nil")
+	}
+	if err := w.Sink(Frame{Type: FrameStdin, DataB64: base64.StdEncoding.EncodeToString(p)}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (w *StreamFrameWriter) Close() error {
+	if w.Sink == nil {
+		return fmt.Errorf("stream sink is nil")
+	}
+	return w.Sink(Frame{Type: FrameStdinClose})
+}
+
 func DecodeData(dataB64 string) ([]byte, error) {
 	return base64.StdEncoding.DecodeString(dataB64)
 }
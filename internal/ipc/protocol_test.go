@@ -65,3 +65,47 @@ func TestStreamEventWriter(t *testing.T) {
 		t.Fatalf("unexpected decoded data %q", data)
 	}
 }
+
+func TestFrameFollowsRequest(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	r := NewReader(buf)
+
+	if err := w.WriteRequest(Request{Type: RequestRun, Version: Version, Review: true}); err != nil {
+		t.Fatalf("WriteRequest returned error: %v", err)
+	}
+	if err := w.WriteFrame(Frame{Type: FrameReview, Accept: true, Paths: []string{"/etc/a"}}); err != nil {
+		t.Fatalf("WriteFrame returned error: %v", err)
+	}
+	if _, err := r.ReadRequest(); err != nil {
+		t.Fatalf("ReadRequest returned error: %v", err)
+	}
+	f, err := r.ReadFrame()
+	if err != nil {
+		t.Fatalf("ReadFrame returned error: %v", err)
+	}
+	if f.Type != FrameReview || !f.Accept || len(f.Paths) != 1 {
+		t.Fatalf("frame round trip mismatch: %#v", f)
+	}
+}
+
+func TestStreamFrameWriter(t *testing.T) {
+	var frames []Frame
+	writer := &StreamFrameWriter{Sink: func(f Frame) error {
+		frames = append(frames, f)
+		return nil
+	}}
+	if _, err := writer.Write([]byte("input")); err != nil {
+		t.Fatalf("Write returned error: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Close returned error: %v", err)
+	}
+	if len(frames) != 2 || frames[0].Type != FrameStdin || frames[1].Type != FrameStdinClose {
+		t.Fatalf("unexpected frames: %#v", frames)
+	}
+	data, err := DecodeData(frames[0].DataB64)
+	if err != nil || string(data) != "input" {
+		t.Fatalf("unexpected stdin payload %q (err=%v)", data, err)
+	}
+}
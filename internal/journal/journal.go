@@ -65,11 +65,72 @@ type Journal struct {
 	UpperDirs     []string             `json:"upper_dirs"`
 	BackupDir     string               `json:"backup_dir"`
 	KeepArtifacts bool                 `json:"keep_artifacts"`
+	// Prepared describes a prepared transaction; it is set while State is
+	// StatePrepared and kept through the commit.
+	Prepared *Prepared `json:"prepared,omitempty"`
+	// Hooks run once the operations are applied; the backups are kept until
+	// they pass so a failure can revert the commit.
+	Hooks *Hooks `json:"hooks,omitempty"`
+	// Owner is the UID that started the run and ScriptPath what it ran;
+	// Undoes names the run an undo reverts.
+	Owner      uint32 `json:"owner"`
+	ScriptPath string `json:"script_path,omitempty"`
+	Undoes     string `json:"undoes,omitempty"`
+	// CommittedAt and Committed record when the commit finished and the
+	// state it left each path in, so an undo can tell if a path changed
+	// since.
+	CommittedAt time.Time           `json:"committed_at,omitempty"`
+	Committed   map[string]Baseline `json:"committed,omitempty"`
+}
+
+// Prepared records who prepared a transaction and what ran.
+type Prepared struct {
+	Owner      uint32    `json:"owner"`
+	ScriptPath string    `json:"script_path"`
+	ScriptArgs []string  `json:"script_args,omitempty"`
+	At         time.Time `json:"at"`
+	// Approval is set when policy holds the run for a second user's
+	// approval.
+	Approval *Approval `json:"approval,omitempty"`
+}
+
+// Approval records why a run needs approval and, once given, who approved
+// it.
+type Approval struct {
+	Reason   string    `json:"reason"`
+	Approver *Approver `json:"approver,omitempty"`
+}
+
+type Approver struct {
+	User string    `json:"user"`
+	UID  uint32    `json:"uid"`
+	PID  int32     `json:"pid"`
+	At   time.Time `json:"at"`
+}
+
+// Hooks are command lines run on the host after a commit, as the run's
+// user: the post-commit actions in order, then the health check until it
+// passes. HealthTimeout bounds them together.
+type Hooks struct {
+	PostCommit    []string      `json:"post_commit,omitempty"`
+	HealthCheck   string        `json:"health_check,omitempty"`
+	HealthTimeout time.Duration `json:"health_timeout"`
+	UID           uint32        `json:"uid"`
+	GID           uint32        `json:"gid"`
+	Groups        []uint32      `json:"groups,omitempty"`
+	Env           []string      `json:"env,omitempty"`
+	CWD           string        `json:"cwd"`
 }
 
 const (
-	StateCommitting  = "committing"
-	StateCommitted   = "committed"
+	// StatePrepared journals wait for an explicit commit or abort and are
+	// not touched by recovery.
+	StatePrepared   = "prepared"
+	StateCommitting = "committing"
+	StateCommitted  = "committed"
+	// StateVerifying journals are applied and waiting for their hooks;
+	// recovery runs the hooks again and reverts the commit if they fail.
+	StateVerifying   = "verifying"
 	StateRollingBack = "rolling_back"
 	StateRolledBack  = "rolled_back"
 )
@@ -131,7 +192,7 @@ func ListPending(dir string) ([]string, error) {
 		if err != nil {
 			return nil, err
 		}
-		if j.State == StateCommitted || j.State == StateRolledBack {
+		if j.State == StateCommitted || j.State == StateRolledBack || j.State == StatePrepared {
 			continue
 		}
 		out = append(out, path)
@@ -140,6 +201,32 @@ func ListPending(dir string) ([]string, error) {
 	return out, nil
 }
 
+// ListPrepared returns the prepared journals in dir, oldest run ID first.
+func ListPrepared(dir string) ([]*Journal, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read journal directory: %w", err)
+	}
+	var out []*Journal
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		j, err := Load(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if j.State == StatePrepared {
+			out = append(out, j)
+		}
+	}
+	sort.Slice(out, func(a, b int) bool { return out[a].RunID < out[b].RunID })
+	return out, nil
+}
+
 func fsyncFile(path string) error {
 	f, err := os.Open(path)
 	if err != nil {
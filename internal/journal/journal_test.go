@@ -47,3 +47,28 @@ func TestListPending(t *testing.T) {
 		t.Fatalf("expected 1 pending journal, got %d", len(pending))
 	}
 }
+
+func TestListPreparedSkippedByRecovery(t *testing.T) {
+	tmp := t.TempDir()
+	if err := Save(filepath.Join(tmp, "one.json"), &Journal{RunID: "one", State: StatePrepared, Prepared: &Prepared{Owner: 1000}}); err != nil {
+		t.Fatalf("save one: %v", err)
+	}
+	if err := Save(filepath.Join(tmp, "two.json"), &Journal{RunID: "two", State: StateCommitting}); err != nil {
+		t.Fatalf("save two: %v", err)
+	}
+
+	pending, err := ListPending(tmp)
+	if err != nil {
+		t.Fatalf("ListPending returned error: %v", err)
+	}
+	if len(pending) != 1 || filepath.Base(pending[0]) != "two.json" {
+		t.Fatalf("expected only the committing journal to be pending, got %v", pending)
+	}
+	prepared, err := ListPrepared(tmp)
+	if err != nil {
+		t.Fatalf("ListPrepared returned error: %v", err)
+	}
+	if len(prepared) != 1 || prepared[0].RunID != "one" || prepared[0].Prepared.Owner != 1000 {
+		t.Fatalf("unexpected prepared journals: %#v", prepared)
+	}
+}
@@ -76,6 +76,19 @@ func WritableRealMounts(mounts []Mount) []Mount {
 	return out
 }
 
+// PseudoMountPoints returns where pseudo and memory filesystems are mounted,
+// excluding a tmpfs root.
+func PseudoMountPoints(mounts []Mount) []string {
+	var out []string
+	for _, m := range mounts {
+		if IsPseudoFS(m.FSType) && m.MountPoint != "/" {
+			out = append(out, m.MountPoint)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
 func IsPseudoFS(fsType string) bool {
 	switch fsType {
 	case "proc", "sysfs", "devtmpfs", "devpts", "cgroup", "cgroup2", "tmpfs", "securityfs", "mqueue", "pstore", "tracefs", "debugfs", "autofs", "efivarfs", "hugetlbfs", "fusectl", "configfs", "binfmt_misc", "nsfs", "ramfs", "selinuxfs", "bpf":
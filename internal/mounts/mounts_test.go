@@ -42,3 +42,20 @@ func TestWritableRealMounts(t *testing.T) {
 		t.Fatalf("unexpected real mount order: %#v", real)
 	}
 }
+
+func TestPseudoMountPoints(t *testing.T) {
+	input := strings.NewReader(`34 2 253:1 / / rw,relatime shared:1 - ext4 /dev/vda1 rw
+46 34 0:37 / /tmp rw,nosuid,nodev shared:19 - tmpfs tmpfs rw
+29 34 0:26 / /proc rw,nosuid,nodev,noexec,relatime shared:12 - proc proc rw
+56 34 253:13 / /boot rw,relatime shared:96 - ext4 /dev/vda13 rw
+`)
+
+	mounts, err := ParseMountInfo(input)
+	if err != nil {
+		t.Fatalf("ParseMountInfo returned error: %v", err)
+	}
+	got := PseudoMountPoints(mounts)
+	if len(got) != 2 || got[0] != "/proc" || got[1] != "/tmp" {
+		t.Fatalf("unexpected pseudo mount points: %v", got)
+	}
+}
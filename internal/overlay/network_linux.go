@@ -0,0 +1,32 @@
+//go:build linux
+
+package overlay
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// ifreqFlags is struct ifreq with the ifr_flags member of its union.
+type ifreqFlags struct {
+	Name  [syscall.IFNAMSIZ]byte
+	Flags uint16
+	_     [22]byte
+}
+
+// bringUpLoopback sets lo up in the runner's new network namespace, where it
+// starts out down.
+func bringUpLoopback() error {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+	var req ifreqFlags
+	copy(req.Name[:], "lo")
+	if err := ioctl(uintptr(fd), syscall.SIOCGIFFLAGS, uintptr(unsafe.Pointer(&req))); err != nil {
+		return err
+	}
+	req.Flags |= syscall.IFF_UP
+	return ioctl(uintptr(fd), syscall.SIOCSIFFLAGS, uintptr(unsafe.Pointer(&req)))
+}
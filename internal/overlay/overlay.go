@@ -13,9 +13,16 @@ import (
 	"strings"
 	"time"
 
+	"github.com/ShriKaranHanda/atomic/internal/cgroup"
 	"github.com/ShriKaranHanda/atomic/internal/mounts"
 )
 
+const SpecFileName = "runner-spec.json"
+
+// SandboxMountPoints are given fresh pseudo-filesystems or private tmpfs
+// inside every run, so nothing written there reaches the upperdirs.
+var SandboxMountPoints = []string{"/proc", "/sys", "/dev", "/tmp", "/run"}
+
 type MountSpec struct {
 	MountPoint string `json:"mount_point"`
 	LowerDir   string `json:"lower_dir"`
@@ -32,40 +39,128 @@ type RunnerSpec struct {
 	CWD          string      `json:"cwd"`
 	ScriptPath   string      `json:"script_path"`
 	ScriptArgs   []string    `json:"script_args"`
+	Env          []string    `json:"env"`
 	RunAsUID     uint32      `json:"run_as_uid"`
 	RunAsGID     uint32      `json:"run_as_gid"`
+	RunAsGroups  []uint32    `json:"run_as_groups"`
+	Owner        uint32      `json:"owner"`
+	Umask        uint32      `json:"umask"`
+	PIDNamespace bool        `json:"pid_namespace,omitempty"`
+	Network      string      `json:"network,omitempty"`
+	// Excluded lists the paths whose changes are never committed.
+	Excluded   []string   `json:"excluded,omitempty"`
+	TTY        bool       `json:"tty,omitempty"`
+	WindowSize WindowSize `json:"window_size,omitempty"`
+	// Validators, when set, are run instead of the script over a read-only
+	// view of the finished run's changes.
+	Validators []Validator `json:"validators,omitempty"`
+}
+
+// Validator is a bash command line run against a run's changes before they
+// are committed, as the run-as user or, with Root, as root.
+type Validator struct {
+	Command string `json:"command"`
+	Root    bool   `json:"root,omitempty"`
+}
+
+// ValidateSpecFileName is the runner spec of a run's validation pass.
+const ValidateSpecFileName = "validate-spec.json"
+
+// Network modes. Host shares the daemon's network; none and loopback run the
+// script in a new network namespace, with the loopback interface down or up.
+const (
+	NetworkHost     = "host"
+	NetworkNone     = "none"
+	NetworkLoopback = "loopback"
+)
+
+// ValidNetwork reports whether mode is a known network mode.
+func ValidNetwork(mode string) bool {
+	switch mode {
+	case NetworkHost, NetworkNone, NetworkLoopback:
+		return true
+	}
+	return false
+}
+
+type WindowSize struct {
+	Rows uint16 `json:"rows"`
+	Cols uint16 `json:"cols"`
+}
+
+// controlMessage is sent from the daemon to the runner over the control pipe
+// (fd 3 in the runner) while the script runs. The runner kills the script
+// when the pipe is closed early, which is how a cancelled run is aborted.
+type controlMessage struct {
+	Type       string     `json:"type"`
+	WindowSize WindowSize `json:"window_size,omitempty"`
+	Signal     int        `json:"signal,omitempty"`
 }
 
+const (
+	controlResize = "resize"
+	controlSignal = "signal"
+)
+
+// runnerStatus is written by the runner to the status pipe (fd 4) before it
+// exits.
+type runnerStatus struct {
+	ExitCode int `json:"exit_code"`
+	Signal   int `json:"signal,omitempty"`
+	// Validator is the command of the validator that failed.
+	Validator string `json:"validator,omitempty"`
+}
+
+// cancelGrace is how long a cancelled runner gets to kill the script before
+// it is killed itself.
+const cancelGrace = 10 * time.Second
+
 type RunConfig struct {
-	RunID      string
-	WorkRoot   string
-	ScriptPath string
-	ScriptArgs []string
-	CWD        string
-	RunAsUID   uint32
-	RunAsGID   uint32
-	Verbose    bool
-	Stdout     io.Writer
-	Stderr     io.Writer
-	Stdin      io.Reader
+	RunID       string
+	WorkRoot    string
+	ScriptPath  string
+	ScriptArgs  []string
+	CWD         string
+	Env         []string
+	RunAsUID    uint32
+	RunAsGID    uint32
+	RunAsGroups []uint32
+	Owner       uint32
+	Umask       uint32
+	Verbose     bool
+	Stdout      io.Writer
+	Stderr      io.Writer
+	Stdin       io.Reader
+
+	// TTY runs the script on a pseudo-terminal allocated by the runner.
+	// Stdout carries the terminal output and Stderr is unused.
+	TTY        bool
+	WindowSize WindowSize
+	Resize     <-chan WindowSize
+	// Signals are relayed to the script's process group.
+	Signals <-chan int
+	// Cgroup, when set, is the cgroup the runner and script start in.
+	Cgroup *cgroup.Group
+	// PIDNamespace runs the script in a new PID namespace with the runner as
+	// its init, so nothing the script starts survives it.
+	PIDNamespace bool
+	// Network is one of the network modes; empty means NetworkHost.
+	Network string
 }
 
 type RunResult struct {
 	ExitCode   int
+	Signal     int
 	RunDir     string
 	UpperDirs  []MountSpec
+	Excluded   []string
 	MergedDir  string
 	StartedAt  time.Time
 	FinishedAt time.Time
 }
 
 func DiscoverMounts() ([]MountSpec, error) {
-	f, err := os.Open("/proc/self/mountinfo")
-	if err != nil {
-		return nil, fmt.Errorf("open /proc/self/mountinfo: %w", err)
-	}
-	defer f.Close()
-	parsed, err := mounts.ParseMountInfo(f)
+	parsed, err := readMountInfo()
 	if err != nil {
 		return nil, err
 	}
@@ -89,6 +184,26 @@ func DiscoverMounts() ([]MountSpec, error) {
 	return result, nil
 }
 
+// ExcludedPaths returns the sandbox mount points plus every pseudo-filesystem
+// mounted on the host. Anything a run leaves below them is not committed.
+func ExcludedPaths() ([]string, error) {
+	parsed, err := readMountInfo()
+	if err != nil {
+		return nil, err
+	}
+	out := append([]string{}, SandboxMountPoints...)
+	return append(out, mounts.PseudoMountPoints(parsed)...), nil
+}
+
+func readMountInfo() ([]mounts.Mount, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, fmt.Errorf("open /proc/self/mountinfo: %w", err)
+	}
+	defer f.Close()
+	return mounts.ParseMountInfo(f)
+}
+
 func overlayLowerSupported(fsType string) bool {
 	switch fsType {
 	case "ext2", "ext3", "ext4", "xfs", "btrfs", "f2fs":
@@ -108,10 +223,17 @@ func RunScript(ctx context.Context, cfg RunConfig) (*RunResult, error) {
 	if cfg.CWD == "" {
 		cfg.CWD = "/"
 	}
+	if cfg.Network != "" && !ValidNetwork(cfg.Network) {
+		return nil, fmt.Errorf("unsupported network mode %q", cfg.Network)
+	}
 	mountSpecs, err := DiscoverMounts()
 	if err != nil {
 		return nil, err
 	}
+	excluded, err := ExcludedPaths()
+	if err != nil {
+		return nil, err
+	}
 	startedAt := time.Now().UTC()
 	runDir := filepath.Join(cfg.WorkRoot, cfg.RunID)
 	if err := os.MkdirAll(runDir, 0o700); err != nil {
@@ -157,19 +279,101 @@ func RunScript(ctx context.Context, cfg RunConfig) (*RunResult, error) {
 		CWD:          cfg.CWD,
 		ScriptPath:   cfg.ScriptPath,
 		ScriptArgs:   cfg.ScriptArgs,
+		Env:          cfg.Env,
+		PIDNamespace: cfg.PIDNamespace,
+		Network:      cfg.Network,
+		Excluded:     excluded,
 		RunAsUID:     cfg.RunAsUID,
 		RunAsGID:     cfg.RunAsGID,
+		RunAsGroups:  cfg.RunAsGroups,
+		Owner:        cfg.Owner,
+		Umask:        cfg.Umask,
+		TTY:          cfg.TTY,
+		WindowSize:   cfg.WindowSize,
 	}
-	specPath := filepath.Join(runDir, "runner-spec.json")
+	specPath := filepath.Join(runDir, SpecFileName)
 	if err := writeSpec(specPath, spec); err != nil {
 		return nil, err
 	}
 
-	exe, err := os.Executable()
+	exitCode, status, err := startRunner(ctx, cfg, specPath)
 	if err != nil {
 		return nil, err
 	}
-	cmd := exec.CommandContext(ctx, "unshare", "--mount", "--propagation", "private", "--fork", "--", exe, "__runner", "--spec", specPath)
+	allUpper := []MountSpec{{MountPoint: "/", LowerDir: "/", UpperDir: rootUpper, WorkDir: rootWork}}
+	allUpper = append(allUpper, extraMounts...)
+	return &RunResult{
+		ExitCode:   exitCode,
+		Signal:     status.Signal,
+		RunDir:     runDir,
+		UpperDirs:  allUpper,
+		Excluded:   excluded,
+		MergedDir:  mergedDir,
+		StartedAt:  startedAt,
+		FinishedAt: time.Now().UTC(),
+	}, nil
+}
+
+// ValidateConfig runs validators over the changes of the run in RunDir.
+type ValidateConfig struct {
+	RunDir     string
+	Validators []Validator
+	Stdout     io.Writer
+	Stderr     io.Writer
+	Cgroup     *cgroup.Group
+}
+
+// ValidationFailure names the validator that failed and how.
+type ValidationFailure struct {
+	Command  string
+	ExitCode int
+	Signal   int
+}
+
+// RunValidators runs the validators in order, as the script ran (same
+// namespaces, identity and environment), over a read-only merged view of the
+// run's changes, and stops at the first one that fails. Writes to /tmp and
+// /run are allowed and discarded.
+func RunValidators(ctx context.Context, cfg ValidateConfig) (*ValidationFailure, error) {
+	spec, err := LoadSpec(filepath.Join(cfg.RunDir, SpecFileName))
+	if err != nil {
+		return nil, fmt.Errorf("load run spec: %w", err)
+	}
+	spec.Validators = cfg.Validators
+	spec.TTY = false
+	specPath := filepath.Join(cfg.RunDir, ValidateSpecFileName)
+	if err := writeSpec(specPath, *spec); err != nil {
+		return nil, err
+	}
+	exitCode, status, err := startRunner(ctx, RunConfig{
+		Stdout:       cfg.Stdout,
+		Stderr:       cfg.Stderr,
+		Stdin:        strings.NewReader(""),
+		Cgroup:       cfg.Cgroup,
+		PIDNamespace: spec.PIDNamespace,
+		Network:      spec.Network,
+	}, specPath)
+	if err != nil {
+		return nil, err
+	}
+	if status.Validator != "" {
+		return &ValidationFailure{Command: status.Validator, ExitCode: status.ExitCode, Signal: status.Signal}, nil
+	}
+	if exitCode != 0 && ctx.Err() == nil {
+		return nil, fmt.Errorf("validation runner exited with status %d", exitCode)
+	}
+	return nil, nil
+}
+
+// startRunner runs the runner on specPath with cfg's stdio, control
+// channels and cgroup, and returns its exit code and reported status.
+func startRunner(ctx context.Context, cfg RunConfig, specPath string) (int, runnerStatus, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, runnerStatus{}, err
+	}
+	cmd := exec.CommandContext(ctx, exe, "__runner", "--spec", specPath)
+	cmd.SysProcAttr = runnerAttr(cfg)
 	if cfg.Stdout != nil {
 		cmd.Stdout = cfg.Stdout
 	} else {
@@ -180,31 +384,105 @@ func RunScript(ctx context.Context, cfg RunConfig) (*RunResult, error) {
 	} else {
 		cmd.Stderr = os.Stderr
 	}
-	if cfg.Stdin != nil {
-		cmd.Stdin = cfg.Stdin
-	} else {
+	if cfg.Stdin == nil {
 		cmd.Stdin = os.Stdin
 	}
-	err = cmd.Run()
+	controlR, controlW, err := os.Pipe()
+	if err != nil {
+		return 0, runnerStatus{}, fmt.Errorf("create control pipe: %w", err)
+	}
+	statusR, statusW, err := os.Pipe()
+	if err != nil {
+		controlR.Close()
+		controlW.Close()
+		return 0, runnerStatus{}, fmt.Errorf("create status pipe: %w", err)
+	}
+	defer statusR.Close()
+	cmd.ExtraFiles = []*os.File{controlR, statusW}
+	if cfg.Cgroup != nil {
+		cfg.Cgroup.Attach(cmd)
+	}
+	cmd.Cancel = controlW.Close
+	cmd.WaitDelay = cancelGrace
+	stopControl := make(chan struct{})
+	go func() {
+		enc := json.NewEncoder(controlW)
+		resize, signals := cfg.Resize, cfg.Signals
+		for {
+			var msg controlMessage
+			select {
+			case size, ok := <-resize:
+				if !ok {
+					resize = nil
+					continue
+				}
+				msg = controlMessage{Type: controlResize, WindowSize: size}
+			case sig, ok := <-signals:
+				if !ok {
+					signals = nil
+					continue
+				}
+				msg = controlMessage{Type: controlSignal, Signal: sig}
+			case <-stopControl:
+				return
+			}
+			if err := enc.Encode(msg); err != nil {
+				return
+			}
+		}
+	}()
+	err = runWithStdin(cmd, cfg.Stdin)
+	close(stopControl)
+	_ = controlR.Close()
+	_ = controlW.Close()
+	_ = statusW.Close()
 	exitCode := 0
 	if err != nil {
 		var exitErr *exec.ExitError
 		if errors.As(err, &exitErr) {
 			exitCode = exitErr.ExitCode()
-		} else {
-			return nil, fmt.Errorf("run unshare: %w", err)
+		} else if ctx.Err() == nil {
+			return 0, runnerStatus{}, fmt.Errorf("runner failed: %w", err)
 		}
 	}
-	allUpper := []MountSpec{{MountPoint: "/", LowerDir: "/", UpperDir: rootUpper, WorkDir: rootWork}}
-	allUpper = append(allUpper, extraMounts...)
-	return &RunResult{
-		ExitCode:   exitCode,
-		RunDir:     runDir,
-		UpperDirs:  allUpper,
-		MergedDir:  mergedDir,
-		StartedAt:  startedAt,
-		FinishedAt: time.Now().UTC(),
-	}, nil
+	var status runnerStatus
+	if err := json.NewDecoder(statusR).Decode(&status); err == nil {
+		exitCode = status.ExitCode
+	}
+	return exitCode, status, nil
+}
+
+// runWithStdin runs cmd feeding it from stdin without letting Wait block on
+// the copy: a script may exit without draining an input stream that never
+// reaches EOF.
+func runWithStdin(cmd *exec.Cmd, stdin io.Reader) error {
+	if stdin == nil {
+		return cmd.Run()
+	}
+	pipe, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go func() {
+		_, _ = io.Copy(pipe, stdin)
+		_ = pipe.Close()
+	}()
+	return cmd.Wait()
+}
+
+func LoadSpec(path string) (*RunnerSpec, error) {
+	blob, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var spec RunnerSpec
+	if err := json.Unmarshal(blob, &spec); err != nil {
+		return nil, err
+	}
+	return &spec, nil
 }
 
 func writeSpec(path string, spec RunnerSpec) error {
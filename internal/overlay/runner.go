@@ -3,24 +3,34 @@
 package overlay
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
+
+	"github.com/ShriKaranHanda/atomic/internal/environ"
 )
 
+const bashPath = "/bin/bash"
+
 func RunRunnerMode(args []string) int {
 	specPath, err := parseRunnerArgs(args)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "runner argument error:", err)
 		return 2
 	}
-	spec, err := loadSpec(specPath)
+	spec, err := LoadSpec(specPath)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "runner spec error:", err)
 		return 2
@@ -37,24 +47,17 @@ func parseRunnerArgs(args []string) (string, error) {
 	return "", errors.New("missing --spec")
 }
 
-func loadSpec(path string) (*RunnerSpec, error) {
-	blob, err := os.ReadFile(path)
-	if err != nil {
-		return nil, err
-	}
-	var spec RunnerSpec
-	if err := json.Unmarshal(blob, &spec); err != nil {
-		return nil, err
-	}
-	return &spec, nil
-}
-
 func runInNamespace(spec *RunnerSpec) int {
+	// Mounts made below must not propagate back to the host namespace.
+	if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		fmt.Fprintln(os.Stderr, "make mounts private:", err)
+		return 2
+	}
 	if err := os.MkdirAll(spec.MergedDir, 0o755); err != nil {
 		fmt.Fprintln(os.Stderr, "create merged dir:", err)
 		return 2
 	}
-	mounted := []string{}
+	validating := len(spec.Validators) > 0
 	mountOne := func(target, lower, upper, work string) error {
 		if err := os.MkdirAll(target, 0o755); err != nil {
 			return err
@@ -69,15 +72,14 @@ func runInNamespace(spec *RunnerSpec) int {
 		if err := syscall.Mount("overlay", target, "overlay", 0, data); err != nil {
 			return fmt.Errorf("mount overlay at %s: %w", target, err)
 		}
-		mounted = append(mounted, target)
-		return nil
-	}
-	cleanup := func() {
-		for i := len(mounted) - 1; i >= 0; i-- {
-			_ = syscall.Unmount(mounted[i], syscall.MNT_DETACH)
+		if validating {
+			// Validators see the run's changes but cannot add to them.
+			if err := syscall.Mount("", target, "", syscall.MS_REMOUNT|syscall.MS_BIND|syscall.MS_RDONLY, ""); err != nil {
+				return fmt.Errorf("make overlay at %s read-only: %w", target, err)
+			}
 		}
+		return nil
 	}
-	defer cleanup()
 
 	if err := mountOne(spec.MergedDir, spec.RootLowerDir, spec.RootUpperDir, spec.RootWorkDir); err != nil {
 		fmt.Fprintln(os.Stderr, err)
@@ -95,32 +97,398 @@ func runInNamespace(spec *RunnerSpec) int {
 		}
 	}
 
-	cmdArgs := []string{
-		spec.MergedDir,
-		"/usr/bin/setpriv",
-		fmt.Sprintf("--reuid=%d", spec.RunAsUID),
-		fmt.Sprintf("--regid=%d", spec.RunAsGID),
-		"--clear-groups",
-		"/bin/bash",
-		"-ceu",
-		`cd "$1"; shift; exec /bin/bash "$@"`,
-		"atomic",
-		spec.CWD,
-		spec.ScriptPath,
-	}
-	cmdArgs = append(cmdArgs, spec.ScriptArgs...)
-	cmd := exec.Command("chroot", cmdArgs...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Stdin = os.Stdin
-	err := cmd.Run()
-	if err == nil {
-		return 0
-	}
-	var exitErr *exec.ExitError
-	if errors.As(err, &exitErr) {
-		return exitErr.ExitCode()
-	}
-	fmt.Fprintln(os.Stderr, "failed to execute script:", err)
-	return 2
+	// In a PID namespace the fresh procfs shows the script only its own
+	// processes.
+	if err := mountSandbox(spec.MergedDir); err != nil {
+		fmt.Fprintln(os.Stderr, "prepare sandbox:", err)
+		return 2
+	}
+	// The mounts go away with the namespace once the runner and script
+	// have exited.
+	if err := pivotRoot(spec.MergedDir); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	if spec.Network == NetworkLoopback {
+		if err := bringUpLoopback(); err != nil {
+			fmt.Fprintln(os.Stderr, "bring up loopback:", err)
+			return 2
+		}
+	}
+
+	if validating {
+		return runValidators(spec)
+	}
+
+	cmd := exec.Command(spec.ScriptPath, spec.ScriptArgs...)
+	cmd.Env = spec.Env
+	// The working directory is entered after credentials are dropped, so
+	// the caller must be able to reach it.
+	cmd.Dir = spec.CWD
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Credential: &syscall.Credential{Uid: spec.RunAsUID, Gid: spec.RunAsGID, Groups: append([]uint32{}, spec.RunAsGroups...)},
+	}
+	// The script inherits the caller's umask; everything the runner itself
+	// created is already in place.
+	syscall.Umask(int(spec.Umask))
+	syscall.CloseOnExec(3)
+	syscall.CloseOnExec(4)
+	script := &scriptProcess{cmd: cmd, init: spec.PIDNamespace && os.Getpid() == 1}
+	control := os.NewFile(3, "control")
+	status := os.NewFile(4, "status")
+	var ws syscall.WaitStatus
+	var err error
+	if spec.TTY {
+		ws, err = runOnPTY(script, spec, control)
+	} else {
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		cmd.Stdin = os.Stdin
+		cmd.SysProcAttr.Setpgid = true
+		if err = script.start(); err == nil {
+			go drainControl(control, script, nil)
+			ws, err = script.wait()
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to execute %s in %s: %v\n", spec.ScriptPath, spec.CWD, err)
+		return 2
+	}
+	st := runnerStatus{ExitCode: ws.ExitStatus()}
+	if ws.Signaled() {
+		st = runnerStatus{ExitCode: 128 + int(ws.Signal()), Signal: int(ws.Signal())}
+	}
+	reportStatus(status, st)
+	return st.ExitCode
+}
+
+// runValidators runs each validator with bash -c in turn and reports the
+// first that fails. Only cancellation is read from the control pipe.
+func runValidators(spec *RunnerSpec) int {
+	syscall.Umask(int(spec.Umask))
+	syscall.CloseOnExec(3)
+	syscall.CloseOnExec(4)
+	control := os.NewFile(3, "control")
+	status := os.NewFile(4, "status")
+	var mu sync.Mutex
+	var current *scriptProcess
+	cancelled := false
+	go func() {
+		_, _ = io.Copy(io.Discard, control)
+		mu.Lock()
+		defer mu.Unlock()
+		cancelled = true
+		if current != nil {
+			current.signal(syscall.SIGKILL)
+		}
+	}()
+	for _, v := range spec.Validators {
+		cmd := exec.Command(bashPath, "-c", v.Command)
+		cmd.Env = spec.Env
+		cmd.Dir = spec.CWD
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		cred := &syscall.Credential{Uid: spec.RunAsUID, Gid: spec.RunAsGID, Groups: append([]uint32{}, spec.RunAsGroups...)}
+		if v.Root {
+			// The daemon's validators run as root would from a login
+			// shell, not in the caller's environment.
+			cred = &syscall.Credential{}
+			cmd.Env = environ.ForRoot(spec.Env)
+			cmd.Dir = "/"
+		}
+		cmd.SysProcAttr = &syscall.SysProcAttr{Credential: cred, Setpgid: true}
+		p := &scriptProcess{cmd: cmd, init: spec.PIDNamespace && os.Getpid() == 1}
+		mu.Lock()
+		if cancelled {
+			mu.Unlock()
+			return 2
+		}
+		err := cmd.Start()
+		current = p
+		mu.Unlock()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to run validator %q: %v\n", v.Command, err)
+			reportStatus(status, runnerStatus{ExitCode: 127, Validator: v.Command})
+			return 127
+		}
+		ws, err := p.wait()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to wait for validator %q: %v\n", v.Command, err)
+			return 2
+		}
+		st := runnerStatus{ExitCode: ws.ExitStatus(), Validator: v.Command}
+		if ws.Signaled() {
+			st = runnerStatus{ExitCode: 128 + int(ws.Signal()), Signal: int(ws.Signal()), Validator: v.Command}
+		}
+		if st.ExitCode != 0 {
+			reportStatus(status, st)
+			return st.ExitCode
+		}
+	}
+	reportStatus(status, runnerStatus{})
+	return 0
+}
+
+func reportStatus(status *os.File, st runnerStatus) {
+	if status == nil {
+		return
+	}
+	_ = json.NewEncoder(status).Encode(st)
+	_ = status.Close()
+}
+
+// scriptProcess tracks whether the script has been reaped so signals are never
+// sent to a process group whose ID may have been reused. With init set the
+// runner is PID 1 of its own PID namespace and must reap every orphan.
+type scriptProcess struct {
+	cmd    *exec.Cmd
+	init   bool
+	mu     sync.Mutex
+	reaped bool
+}
+
+// start execs the script directly, so the kernel runs it through its shebang
+// interpreter or as a binary. A script the kernel will not run, because it
+// has no shebang or the run's user lacks the execute bit, is started through
+// its shebang interpreter, or bash when it has none. Other failures, such as
+// an unreachable working directory, are returned as they are.
+func (p *scriptProcess) start() error {
+	err := p.cmd.Start()
+	notExecutable := errors.Is(err, fs.ErrPermission) && !mayExecute(p.cmd.Path, p.cmd.SysProcAttr.Credential)
+	if !errors.Is(err, syscall.ENOEXEC) && !notExecutable {
+		return err
+	}
+	interp, err := interpreter(p.cmd.Path)
+	if err != nil {
+		return err
+	}
+	fallback := &exec.Cmd{
+		Path:        interp[0],
+		Args:        append(append(interp, p.cmd.Path), p.cmd.Args[1:]...),
+		Env:         p.cmd.Env,
+		Dir:         p.cmd.Dir,
+		Stdin:       p.cmd.Stdin,
+		Stdout:      p.cmd.Stdout,
+		Stderr:      p.cmd.Stderr,
+		SysProcAttr: p.cmd.SysProcAttr,
+	}
+	p.cmd = fallback
+	return fallback.Start()
+}
+
+// stNoexec is ST_NOEXEC, set in the flags of a filesystem mounted noexec.
+const stNoexec = 0x8
+
+// mayExecute reports whether the mode bits of path and its mount let cred
+// execute it. ACLs are not consulted.
+func mayExecute(path string, cred *syscall.Credential) bool {
+	var st syscall.Stat_t
+	var fsStat syscall.Statfs_t
+	if syscall.Stat(path, &st) != nil || syscall.Statfs(path, &fsStat) != nil {
+		return false
+	}
+	if fsStat.Flags&stNoexec != 0 {
+		return false
+	}
+	switch {
+	case cred == nil || cred.Uid == 0:
+		return st.Mode&0o111 != 0
+	case st.Uid == cred.Uid:
+		return st.Mode&0o100 != 0
+	case st.Gid == cred.Gid || slices.Contains(cred.Groups, st.Gid):
+		return st.Mode&0o010 != 0
+	}
+	return st.Mode&0o001 != 0
+}
+
+// interpreter returns the command line of the shebang of the script at path,
+// as the kernel splits it (the interpreter and at most one argument), or
+// bash when it has none.
+func interpreter(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	head := make([]byte, 256)
+	n, err := io.ReadFull(f, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	line, ok := bytes.CutPrefix(head[:n], []byte("#!"))
+	if !ok {
+		return []string{bashPath}, nil
+	}
+	line, _, _ = bytes.Cut(line, []byte("\n"))
+	fields := strings.TrimSpace(string(line))
+	if fields == "" {
+		return []string{bashPath}, nil
+	}
+	i := strings.IndexAny(fields, " \t")
+	if i < 0 {
+		return []string{fields}, nil
+	}
+	return []string{fields[:i], strings.TrimSpace(fields[i+1:])}, nil
+}
+
+// wait also kills whatever the script left running: its process group, or
+// the whole PID namespace when the runner is init.
+func (p *scriptProcess) wait() (syscall.WaitStatus, error) {
+	var ws syscall.WaitStatus
+	var err error
+	if p.init {
+		ws, err = p.reapUntilExit()
+	} else if err = p.cmd.Wait(); p.cmd.ProcessState != nil {
+		ws, _ = p.cmd.ProcessState.Sys().(syscall.WaitStatus)
+		err = nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.reaped = true
+	if p.init {
+		_ = syscall.Kill(-1, syscall.SIGKILL)
+		for {
+			if _, werr := syscall.Wait4(-1, nil, 0, nil); werr != nil && werr != syscall.EINTR {
+				break
+			}
+		}
+	} else {
+		_ = syscall.Kill(-p.cmd.Process.Pid, syscall.SIGKILL)
+	}
+	return ws, err
+}
+
+func (p *scriptProcess) reapUntilExit() (syscall.WaitStatus, error) {
+	for {
+		var ws syscall.WaitStatus
+		pid, err := syscall.Wait4(-1, &ws, 0, nil)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if pid == p.cmd.Process.Pid {
+			return ws, nil
+		}
+	}
+}
+
+func (p *scriptProcess) signal(sig syscall.Signal) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.reaped || p.cmd.Process == nil {
+		return
+	}
+	_ = syscall.Kill(-p.cmd.Process.Pid, sig)
+}
+
+// runOnPTY runs cmd as a session leader with a fresh pseudo-terminal as its
+// controlling terminal and relays the runner's stdio through the master side.
+func runOnPTY(script *scriptProcess, spec *RunnerSpec, control *os.File) (syscall.WaitStatus, error) {
+	cmd := script.cmd
+	master, slave, err := openPTY()
+	if err != nil {
+		return 0, err
+	}
+	defer master.Close()
+	if err := slave.Chown(int(spec.RunAsUID), int(spec.RunAsGID)); err != nil {
+		slave.Close()
+		return 0, fmt.Errorf("chown pty: %w", err)
+	}
+	_ = setWindowSize(master, spec.WindowSize)
+	cmd.Stdin = slave
+	cmd.Stdout = slave
+	cmd.Stderr = slave
+	cmd.SysProcAttr.Setsid = true
+	cmd.SysProcAttr.Setctty = true
+	cmd.SysProcAttr.Ctty = 0
+	err = script.start()
+	slave.Close()
+	if err != nil {
+		return 0, err
+	}
+	go drainControl(control, script, master)
+	go func() {
+		copyInputToPTY(master, os.Stdin)
+	}()
+	outputDone := make(chan struct{})
+	go func() {
+		defer close(outputDone)
+		_, _ = io.Copy(os.Stdout, master)
+	}()
+	ws, err := script.wait()
+	// The copy ends with EIO once every slave descriptor is closed; a
+	// background process may still hold one, so only wait briefly.
+	select {
+	case <-outputDone:
+	case <-time.After(time.Second):
+	}
+	return ws, err
+}
+
+// copyInputToPTY relays input to the terminal and signals end of input with
+// the EOF character, sent twice when needed to flush a partial line first.
+func copyInputToPTY(master *os.File, in io.Reader) {
+	eof := []byte{4}
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := in.Read(buf)
+		if n > 0 {
+			if _, werr := master.Write(buf[:n]); werr != nil {
+				return
+			}
+			if buf[n-1] != '\n' {
+				eof = []byte{4, 4}
+			} else {
+				eof = []byte{4}
+			}
+		}
+		if err != nil {
+			_, _ = master.Write(eof)
+			return
+		}
+	}
+}
+
+func drainControl(control *os.File, script *scriptProcess, master *os.File) {
+	if control == nil {
+		return
+	}
+	dec := json.NewDecoder(control)
+	for {
+		var msg controlMessage
+		if err := dec.Decode(&msg); err != nil {
+			// The daemon only closes the control pipe before the runner
+			// exits when the run was cancelled.
+			script.signal(syscall.SIGKILL)
+			return
+		}
+		switch msg.Type {
+		case controlResize:
+			if master != nil {
+				_ = setWindowSize(master, msg.WindowSize)
+			}
+		case controlSignal:
+			script.signal(syscall.Signal(msg.Signal))
+		}
+	}
+}
+
+// pivotRoot makes newRoot, which must be a mount point, the root of the
+// mount namespace and detaches the old root, so unlike chroot nothing of the
+// host tree stays reachable.
+func pivotRoot(newRoot string) error {
+	if err := syscall.Chdir(newRoot); err != nil {
+		return fmt.Errorf("enter new root: %w", err)
+	}
+	// Stack the old root on top of the new one, then lazily unmount it.
+	if err := syscall.PivotRoot(".", "."); err != nil {
+		return fmt.Errorf("pivot_root: %w", err)
+	}
+	if err := syscall.Unmount(".", syscall.MNT_DETACH); err != nil {
+		return fmt.Errorf("detach old root: %w", err)
+	}
+	return syscall.Chdir("/")
 }
@@ -5,9 +5,14 @@ package overlay
 import (
 	"fmt"
 	"os"
+	"syscall"
 )
 
 func RunRunnerMode(args []string) int {
 	fmt.Fprintln(os.Stderr, "runner mode is only supported on Linux")
 	return 2
 }
+
+func runnerAttr(RunConfig) *syscall.SysProcAttr {
+	return nil
+}
@@ -0,0 +1,135 @@
+//go:build linux
+
+package overlay
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// devNodes are created in the run's private /dev.
+var devNodes = []struct {
+	name         string
+	major, minor uint32
+}{
+	{"null", 1, 3},
+	{"zero", 1, 5},
+	{"full", 1, 7},
+	{"random", 1, 8},
+	{"urandom", 1, 9},
+	{"tty", 5, 0},
+}
+
+var devLinks = map[string]string{
+	"fd":     "/proc/self/fd",
+	"stdin":  "/proc/self/fd/0",
+	"stdout": "/proc/self/fd/1",
+	"stderr": "/proc/self/fd/2",
+	"ptmx":   "pts/ptmx",
+}
+
+// mountSandbox mounts /proc, a read-only /sys, a minimal /dev and private
+// /tmp and /run over the merged root.
+func mountSandbox(root string) error {
+	mount := func(source, target, fstype string, flags uintptr, data string) error {
+		if err := os.MkdirAll(target, 0o755); err != nil {
+			return err
+		}
+		if err := syscall.Mount(source, target, fstype, flags, data); err != nil {
+			return fmt.Errorf("mount %s at %s: %w", fstype, target, err)
+		}
+		return nil
+	}
+	const noexec = syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC
+	at := func(path string) string { return filepath.Join(root, path) }
+
+	if err := mount("proc", at("/proc"), "proc", noexec, ""); err != nil {
+		return err
+	}
+	if err := mount("sysfs", at("/sys"), "sysfs", noexec|syscall.MS_RDONLY, ""); err != nil {
+		return err
+	}
+	if err := mount("tmpfs", at("/dev"), "tmpfs", syscall.MS_NOSUID|syscall.MS_NOEXEC, "mode=0755,size=1m"); err != nil {
+		return err
+	}
+	for _, n := range devNodes {
+		path := at("/dev/" + n.name)
+		if err := syscall.Mknod(path, syscall.S_IFCHR|0o666, int(n.major<<8|n.minor)); err != nil {
+			return fmt.Errorf("create %s: %w", path, err)
+		}
+		// mknod applies the umask.
+		if err := os.Chmod(path, 0o666); err != nil {
+			return err
+		}
+	}
+	for name, target := range devLinks {
+		if err := os.Symlink(target, at("/dev/"+name)); err != nil {
+			return err
+		}
+	}
+	// A devpts instance of the run's own, where the runner allocates the
+	// pseudo-terminal for -t after pivoting, so the script sees no host
+	// terminals. The host's is usually mounted with ptmxmode=000, which
+	// would keep unprivileged scripts from opening terminals of their own.
+	if err := mount("devpts", at("/dev/pts"), "devpts", syscall.MS_NOSUID|syscall.MS_NOEXEC, "newinstance,ptmxmode=0666,mode=0620"); err != nil {
+		return err
+	}
+	// The sizes are those systemd gives the host's; the run's memory limit
+	// applies to what is written there as well.
+	if err := mount("tmpfs", at("/dev/shm"), "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=1777,size=50%"); err != nil {
+		return err
+	}
+	if err := mount("tmpfs", at("/tmp"), "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=1777,size=50%"); err != nil {
+		return err
+	}
+	if err := mount("tmpfs", at("/run"), "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=0755,size=10%"); err != nil {
+		return err
+	}
+	if err := os.Mkdir(at("/run/lock"), 0o755); err != nil {
+		return err
+	}
+	if err := os.Chmod(at("/run/lock"), 0o777|os.ModeSticky); err != nil {
+		return err
+	}
+	return bindResolver(root)
+}
+
+// bindResolver keeps name resolution working when the host's
+// /etc/resolv.conf points into /run, as with systemd-resolved: the directory
+// holding its target is bound read-only into the private /run. A directory
+// rather than the file, so that the resolver's atomic rewrites stay visible,
+// unless that would be /run itself.
+func bindResolver(root string) error {
+	resolved, err := filepath.EvalSymlinks("/etc/resolv.conf")
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("resolve /etc/resolv.conf: %w", err)
+	}
+	if !strings.HasPrefix(resolved, "/run/") {
+		return nil
+	}
+	source := filepath.Dir(resolved)
+	if source == "/run" {
+		source = resolved
+	}
+	target := filepath.Join(root, source)
+	if source == resolved {
+		if err := os.WriteFile(target, nil, 0o644); err != nil {
+			return err
+		}
+	} else if err := os.MkdirAll(target, 0o755); err != nil {
+		return err
+	}
+	if err := syscall.Mount(source, target, "", syscall.MS_BIND, ""); err != nil {
+		return fmt.Errorf("bind %s: %w", source, err)
+	}
+	if err := syscall.Mount("", target, "", syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY|syscall.MS_NOSUID|syscall.MS_NODEV, ""); err != nil {
+		return fmt.Errorf("remount %s read-only: %w", target, err)
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+//go:build linux
+
+package overlay
+
+import "syscall"
+
+// runnerAttr starts the runner in a new mount namespace and, as configured,
+// new PID and network namespaces. With a PID namespace the runner is its
+// init.
+func runnerAttr(cfg RunConfig) *syscall.SysProcAttr {
+	flags := uintptr(syscall.CLONE_NEWNS)
+	if cfg.PIDNamespace {
+		flags |= syscall.CLONE_NEWPID
+	}
+	if cfg.Network == NetworkNone || cfg.Network == NetworkLoopback {
+		flags |= syscall.CLONE_NEWNET
+	}
+	return &syscall.SysProcAttr{Cloneflags: flags}
+}
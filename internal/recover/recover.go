@@ -8,7 +8,10 @@ import (
 	"github.com/ShriKaranHanda/atomic/internal/journal"
 )
 
-func Run(journalDir string, rootPrefix string) error {
+// Run finishes the commits left in journalDir. settle is called with each
+// journal whose operations are all applied; it checks the commit and
+// records it.
+func Run(journalDir string, rootPrefix string, settle func(journalPath string, j *journal.Journal) error) error {
 	pending, err := journal.ListPending(journalDir)
 	if err != nil {
 		return err
@@ -19,13 +22,31 @@ func Run(journalDir string, rootPrefix string) error {
 		if err != nil {
 			return err
 		}
+		switch {
+		case j.State == journal.StateVerifying:
+			// The daemon stopped before the run's hooks passed.
+			if err := settle(path, j); err != nil {
+				return err
+			}
+			continue
+		case j.State == journal.StateRollingBack && j.Hooks != nil:
+			// A rollback was interrupted. Resuming the commit instead would
+			// keep changes that were never verified.
+			if err := eng.Rollback(path, j); err != nil {
+				return fmt.Errorf("revert commit of run %s: %w", j.RunID, err)
+			}
+			if err := finalize(path, j); err != nil {
+				return err
+			}
+			continue
+		}
 		if err := eng.Apply(path, j); err != nil {
 			if rbErr := eng.Rollback(path, j); rbErr != nil {
 				return fmt.Errorf("resume commit failed: %v; rollback also failed: %w", err, rbErr)
 			}
 			return fmt.Errorf("resume commit failed and was rolled back: %w", err)
 		}
-		if err := finalize(path, j); err != nil {
+		if err := settle(path, j); err != nil {
 			return err
 		}
 	}